@@ -0,0 +1,148 @@
+package htpack
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// CacheImmutable is a Cache-Control value suitable for files that can
+	// never change, such as those with a content hash in their name.
+	CacheImmutable = "public, max-age=31536000, immutable"
+
+	// CacheNoCache is a Cache-Control value which requires the client to
+	// revalidate its cached copy (using the Etag) before each use. It is
+	// suitable for HTML documents, which reference other resources.
+	CacheNoCache = "no-cache"
+)
+
+// CachePolicy chooses a Cache-Control header for each file served. The first
+// of the following to match is used: a rule from Rules; Fingerprinted, if the
+// file name contains a content hash; or HTML, if the file is an HTML document.
+// If nothing matches, any Cache-Control header set with SetHeader is left in
+// place. A Cache-Control header stored in the pack for an individual file
+// always takes precedence over the policy.
+type CachePolicy struct {
+	// Rules are checked in order, and the first rule that matches the
+	// request path is used.
+	Rules []CacheRule
+
+	// Fingerprinted, if not empty, is used for any path whose final element
+	// contains a content hash of 6 to 64 lowercase hex digits just before
+	// the extension, as written by htpacker ("app.3f9a1c2b4d5e6f70.js") and
+	// other bundlers ("app.3f9a1c.js"). Typically CacheImmutable.
+	Fingerprinted string
+
+	// HTML, if not empty, is used for any file whose content type is
+	// text/html. Typically CacheNoCache.
+	HTML string
+}
+
+// DefaultCachePolicy returns a policy which marks fingerprinted files as
+// immutable and requires HTML documents to be revalidated.
+func DefaultCachePolicy() *CachePolicy {
+	return &CachePolicy{
+		Fingerprinted: CacheImmutable,
+		HTML:          CacheNoCache,
+	}
+}
+
+// CacheRule matches request paths against a pattern, setting the
+// Cache-Control header for matching files.
+type CacheRule struct {
+	// Pattern is a glob pattern (see path.Match). If it contains no "/",
+	// it is matched against the final element of the path only, so that
+	// "*.js" matches scripts in any directory. It is ignored if Regexp is
+	// set.
+	Pattern string
+
+	// Regexp, if set, is matched against the full request path.
+	Regexp *regexp.Regexp
+
+	// CacheControl is the value of the Cache-Control header.
+	CacheControl string
+}
+
+// SetCachePolicy sets the policy used to choose the Cache-Control header for
+// successful responses. Passing nil removes the policy.
+func (h *Handler) SetCachePolicy(policy *CachePolicy) {
+	h.cachePolicy = policy
+}
+
+// cacheControl returns the Cache-Control value chosen by the policy for the
+// given request path and content type, or an empty string if the policy does
+// not have an opinion.
+func (policy *CachePolicy) cacheControl(reqPath, contentType string) string {
+	for _, rule := range policy.Rules {
+		if rule.matches(reqPath) {
+			return rule.CacheControl
+		}
+	}
+
+	if policy.Fingerprinted != "" && isFingerprinted(path.Base(reqPath)) {
+		return policy.Fingerprinted
+	}
+
+	if policy.HTML != "" && strings.HasPrefix(contentType, "text/html") {
+		return policy.HTML
+	}
+
+	return ""
+}
+
+// matches returns true if the rule applies to the given request path.
+func (rule *CacheRule) matches(reqPath string) bool {
+	if rule.Regexp != nil {
+		return rule.Regexp.MatchString(reqPath)
+	}
+	if !strings.Contains(rule.Pattern, "/") {
+		reqPath = path.Base(reqPath)
+	}
+	ok, _ := path.Match(rule.Pattern, reqPath)
+	return ok
+}
+
+// minHashLen and maxHashLen bound the number of hex digits of a content hash
+// in a file name. htpacker writes 16; other bundlers typically write between 8
+// and 20, and some the full 64 digits of a SHA-256.
+const (
+	minHashLen = 6
+	maxHashLen = 64
+)
+
+// isFingerprinted returns true if the file name contains a content hash, as
+// an element of the name (separated by '.') just before the extension, as in
+// "app.3f9a1c.js", or at the end of a name which has no extension.
+func isFingerprinted(name string) bool {
+	elems := strings.Split(name, ".")
+	switch {
+	case elems[0] == "":
+		return false
+	case len(elems) == 2:
+		return isHash(elems[1])
+	case len(elems) > 2:
+		return isHash(elems[len(elems)-2])
+	}
+	return false
+}
+
+// isHash returns true if s looks like a content hash: between minHashLen and
+// maxHashLen lowercase hex digits, at least one of which is a decimal digit
+// (so that words such as "facade" are not mistaken for hashes).
+func isHash(s string) bool {
+	if len(s) < minHashLen || len(s) > maxHashLen {
+		return false
+	}
+	var digit bool
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digit = true
+		case r >= 'a' && r <= 'f':
+		default:
+			return false
+		}
+	}
+	return digit
+}
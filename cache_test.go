@@ -0,0 +1,75 @@
+package htpack
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestIsFingerprinted(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"app.3f9a1c2b4d5e6f70.js", true},
+		{"archive.tar.0123456789abcdef.gz", true},
+		{"LICENSE.0123456789abcdef", true},
+		{"1234567890123456.0123456789abcdef.css", true},
+		{"app.0123456789012345.js", true}, // digits only
+		{"app.3f9a1c.js", true},
+		{"main.5f3c2a1b.js", true},                // vite
+		{"vendors.8e0d5c4b3a2f1e0d9c8b.js", true}, // webpack
+		{"bundle." + strings.Repeat("a1", 32) + ".js", true},
+
+		{"app.js", false},
+		{"app.3f9a1.js", false}, // too short
+		{"bundle." + strings.Repeat("a1", 32) + "b.js", false}, // too long
+		{"app.facade.js", false},                               // no digits
+		{"app.3F9A1C2B4D5E6F70.js", false},                     // uppercase
+		{"app-3f9a1c2b4d5e6f70.js", false},                     // wrong separator
+		{"logo-facade1.png", false},
+		{"bead42.css", false},
+		{"app.3f9a1c2b4d5e6f70.min.js", false},
+		{".0123456789abcdef", false},
+		{"0123456789abcdef", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isFingerprinted(tt.name); got != tt.want {
+			t.Errorf("isFingerprinted(%q) = %v, want %v",
+				tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	policy := DefaultCachePolicy()
+	policy.Rules = []CacheRule{
+		{Pattern: "*.woff2", CacheControl: "public, max-age=604800"},
+		{Pattern: "/static/*/*.js", CacheControl: "public, max-age=60"},
+		{
+			Regexp:       regexp.MustCompile(`^/api/`),
+			CacheControl: "no-store",
+		},
+	}
+
+	tests := []struct {
+		path, contentType, want string
+	}{
+		{"/fonts/a.woff2", "font/woff2", "public, max-age=604800"},
+		{"/static/x/a.js", "text/javascript", "public, max-age=60"},
+		{"/static/a.js", "text/javascript", ""},
+		{"/api/index.html", "text/html", "no-store"},
+		{"/app.3f9a1c2b4d5e6f70.js", "text/javascript", CacheImmutable},
+		{"/index.html", "text/html; charset=utf-8", CacheNoCache},
+		{"/page.0123456789abcdef.html", "text/html", CacheImmutable},
+		{"/logo-facade1.png", "image/png", ""},
+	}
+	for _, tt := range tests {
+		got := policy.cacheControl(tt.path, tt.contentType)
+		if got != tt.want {
+			t.Errorf("cacheControl(%q, %q) = %q, want %q",
+				tt.path, tt.contentType, got, tt.want)
+		}
+	}
+}
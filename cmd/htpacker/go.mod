@@ -1,14 +1,23 @@
 module github.com/lwithers/htpack/cmd/htpacker
 
-go 1.12
+go 1.18
 
 require (
 	github.com/foobaz/go-zopfli v0.0.0-20140122214029-7432051485e2
-	github.com/kr/pretty v0.1.0 // indirect
 	github.com/lwithers/htpack v1.1.2
 	github.com/lwithers/pkg v1.2.1
 	github.com/spf13/cobra v0.0.5
-	golang.org/x/sys v0.0.0-20190624142023-c5567b49c5d0
-	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	golang.org/x/net v0.23.0
+	golang.org/x/sys v0.18.0
 	gopkg.in/yaml.v2 v2.2.2
 )
+
+require (
+	github.com/gogo/protobuf v1.2.1 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
+	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+)
+
+replace github.com/lwithers/htpack => ../..
@@ -47,28 +47,71 @@ func Inspect(filename string) error {
 	if dir != nil {
 		fmt.Printf("%d files:\n", len(dir.Files))
 		for path, info := range dir.Files {
-			fmt.Printf(" • %s\n"+
-				"    · Etag:         %s\n"+
-				"    · Content type: %s\n"+
-				"    · Uncompressed: %s (offset %d)\n",
-				path, info.Etag, info.ContentType,
-				printSize(info.Uncompressed.Length),
-				info.Uncompressed.Offset)
+			fmt.Printf(" • %s\n", path)
+			inspectFile(info, "    ")
+			for _, variant := range info.Variants {
+				fmt.Printf("    · Variant:\n")
+				inspectFile(variant, "        ")
+			}
+		}
 
-			if info.Gzip != nil {
-				fmt.Printf("    · Gzipped:      %s (offset %d)\n",
-					printSize(info.Gzip.Length), info.Gzip.Offset)
+		if len(dir.Redirects) > 0 {
+			fmt.Printf("%d redirects:\n", len(dir.Redirects))
+			for path, redir := range dir.Redirects {
+				fmt.Printf(" • %s → %s (%d)\n",
+					path, redir.Location, redir.Status)
 			}
+		}
 
-			if info.Brotli != nil {
-				fmt.Printf("    · Brotli:       %s (offset %d)\n",
-					printSize(info.Brotli.Length), info.Brotli.Offset)
+		if len(dir.Rewrites) > 0 {
+			fmt.Printf("%d rewrites:\n", len(dir.Rewrites))
+			for path, target := range dir.Rewrites {
+				fmt.Printf(" • %s → %s\n", path, target)
 			}
 		}
 	}
 	return err
 }
 
+// inspectFile prints the details of a single file (or variant) from the
+// directory, with each line indented by the given prefix.
+func inspectFile(info *packed.File, indent string) {
+	fmt.Printf(indent+"· Etag:         %s\n"+
+		indent+"· Integrity:    %s\n"+
+		indent+"· Content type: %s\n"+
+		indent+"· Uncompressed: %s (offset %d)\n",
+		info.Etag, info.Integrity, info.ContentType,
+		printSize(info.Uncompressed.Length),
+		info.Uncompressed.Offset)
+
+	if info.Gzip != nil {
+		fmt.Printf(indent+"· Gzipped:      %s (offset %d)\n",
+			printSize(info.Gzip.Length), info.Gzip.Offset)
+	}
+
+	if info.Brotli != nil {
+		fmt.Printf(indent+"· Brotli:       %s (offset %d)\n",
+			printSize(info.Brotli.Length), info.Brotli.Offset)
+	}
+
+	if info.Language != "" {
+		fmt.Printf(indent+"· Language:     %s\n", info.Language)
+	}
+
+	for hkey, hval := range info.Headers {
+		fmt.Printf(indent+"· Header:       %s: %s\n", hkey, hval)
+	}
+
+	for _, pl := range info.Preloads {
+		crossorigin := ""
+		if pl.Crossorigin {
+			crossorigin = ", crossorigin"
+		}
+		fmt.Printf(indent+"· Preload:      %s (%s%s)\n",
+			pl.Path, pl.As, crossorigin)
+	}
+}
+
 func printSize(size uint64) string {
 	switch {
 	case size < 1<<10:
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/lwithers/htpack/cmd/htpacker/packer"
 	"github.com/spf13/cobra"
@@ -15,6 +16,68 @@ import (
 var packCmd = &cobra.Command{
 	Use:   "pack",
 	Short: "creates a packfile from a YAML spec or set of files/dirs",
+	Long: `Creates a packfile from a YAML spec or set of files/dirs.
+
+The YAML spec may simply map served paths to files (as generated by the yaml
+command), or it may have the following sections:
+
+  files:          # map of served path to file, as generated by yaml command
+    /index.html:
+      filename: index.html
+  redirects:      # map of path to redirect (status 301, 302, 307 or 308)
+    /old.html:
+      location: /new.html
+      status: 308 # optional; default 301
+  rewrites:       # map of path to the path of a file served in its place
+    /home.html: /index.html
+  rules:          # settings applied to files matching a glob pattern
+    - match: "*.woff2"
+      headers:
+        Cross-Origin-Resource-Policy: cross-origin
+    - match: "/js/*.js"
+      fingerprint: true
+  fingerprint_originals: rewrite # or redirect, or none
+  preload: true   # record preload hints for HTML files
+
+Individual files may also set headers, and be fingerprinted:
+
+    /download.zip:
+      filename: download.zip
+      headers:
+        Content-Disposition: attachment
+    /app.js:
+      filename: app.js
+      fingerprint: true
+
+Files may have variants (alternative representations, chosen according to the
+client's Accept and Accept-Language headers):
+
+    /hero.jpg:
+      filename: hero.jpg
+      variants:
+        - filename: hero.avif
+          content_type: image/avif
+        - filename: hero.webp
+          content_type: image/webp
+    /index.html:
+      filename: index.html
+      language: en
+      variants:
+        - filename: index.de.html
+          language: de
+
+Fingerprinted files are served at a path containing a hash of their content,
+e.g. /app.js is served as /app.0123456789abcdef.js. The original path is kept
+as a rewrite (default) or redirect, or dropped, as set by
+fingerprint_originals (or the --fingerprint-originals flag). Use --manifest or
+--manifest-go to write out the mapping from original paths to URLs.
+
+With preload (or the --preload flag), each HTML file is scanned for the
+stylesheets, scripts and fonts (declared with @font-face in its stylesheets)
+that it references. Those which are in the pack are recorded as preload hints,
+which the server sends as "Link: <...>; rel=preload" headers and optionally as
+a 103 Early Hints response, so the client can fetch them sooner.
+`,
 	RunE: func(c *cobra.Command, args []string) error {
 		// convert "out" to an absolute path, so that it will still
 		// work after chdir
@@ -39,6 +102,24 @@ var packCmd = &cobra.Command{
 			}
 		}
 
+		// convert manifest filenames to absolute paths (updating the
+		// flags), so that they will still work after chdir
+		for _, name := range []string{"manifest", "manifest-go"} {
+			filename, err := c.Flags().GetString(name)
+			if err != nil {
+				return err
+			}
+			if filename == "" {
+				continue
+			}
+			if filename, err = filepath.Abs(filename); err != nil {
+				return err
+			}
+			if err = c.Flags().Set(name, filename); err != nil {
+				return err
+			}
+		}
+
 		// chdir if required
 		chdir, err := c.Flags().GetString("chdir")
 		if err != nil {
@@ -82,6 +163,18 @@ func init() {
 		"YAML specification file (if not present, just pack files)")
 	packCmd.Flags().StringP("chdir", "C", "",
 		"Change to directory before searching for input files")
+	packCmd.Flags().StringSlice("fingerprint", nil,
+		"Fingerprint files matching glob pattern (e.g. '*.js'); may be repeated")
+	packCmd.Flags().String("fingerprint-originals", "",
+		"Serve original path of fingerprinted files as: rewrite, redirect or none")
+	packCmd.Flags().Bool("preload", false,
+		"Record preload hints for resources referenced by HTML files")
+	packCmd.Flags().String("manifest", "",
+		"Write JSON manifest of original paths to URLs to this file")
+	packCmd.Flags().String("manifest-go", "",
+		"Write Go manifest of original paths to URLs to this file")
+	packCmd.Flags().String("manifest-go-package", "assets",
+		"Package name for Go manifest")
 }
 
 func PackFiles(c *cobra.Command, args []string, out string) error {
@@ -89,7 +182,7 @@ func PackFiles(c *cobra.Command, args []string, out string) error {
 	if err != nil {
 		return err
 	}
-	return packer.Pack(ftp, out)
+	return pack(c, &packer.Spec{Files: ftp}, out)
 }
 
 func PackSpec(c *cobra.Command, spec, out string) error {
@@ -98,10 +191,102 @@ func PackSpec(c *cobra.Command, spec, out string) error {
 		return err
 	}
 
-	var ftp packer.FilesToPack
-	if err := yaml.UnmarshalStrict(raw, &ftp); err != nil {
+	ps, err := parseSpec(raw)
+	if err != nil {
 		return fmt.Errorf("parsing YAML spec %s: %v", spec, err)
 	}
+	return pack(c, ps, out)
+}
+
+// parseSpec parses a YAML spec, which is either a full packer.Spec or (as
+// generated by the yaml command) just its files section. Served paths always
+// start with "/", so any other top level key means it is a full spec.
+func parseSpec(raw []byte) (*packer.Spec, error) {
+	var keys map[string]interface{}
+	if err := yaml.Unmarshal(raw, &keys); err != nil {
+		return nil, err
+	}
+
+	var full bool
+	for key := range keys {
+		if !strings.HasPrefix(key, "/") {
+			full = true
+		}
+	}
+
+	var (
+		ps  packer.Spec
+		err error
+	)
+	if full {
+		err = yaml.UnmarshalStrict(raw, &ps)
+	} else {
+		err = yaml.UnmarshalStrict(raw, &ps.Files)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ps, nil
+}
+
+// pack a spec, applying fingerprinting and preload options from the command
+// line and writing out any manifests requested.
+func pack(c *cobra.Command, spec *packer.Spec, out string) error {
+	fingerprint, err := c.Flags().GetStringSlice("fingerprint")
+	if err != nil {
+		return err
+	}
+	for _, match := range fingerprint {
+		spec.Rules = append(spec.Rules, packer.Rule{
+			Match:       match,
+			Fingerprint: true,
+		})
+	}
+
+	originals, err := c.Flags().GetString("fingerprint-originals")
+	if err != nil {
+		return err
+	}
+	if originals != "" {
+		spec.FingerprintOriginals = originals
+	}
+
+	preload, err := c.Flags().GetBool("preload")
+	if err != nil {
+		return err
+	}
+	if preload {
+		spec.Preload = true
+	}
+
+	manifest, err := packer.PackSpec(spec, out)
+	if err != nil {
+		return err
+	}
+
+	manifestJSON, err := c.Flags().GetString("manifest")
+	if err != nil {
+		return err
+	}
+	if manifestJSON != "" {
+		if err = manifest.WriteJSON(manifestJSON); err != nil {
+			return err
+		}
+	}
+
+	manifestGo, err := c.Flags().GetString("manifest-go")
+	if err != nil {
+		return err
+	}
+	manifestGoPkg, err := c.Flags().GetString("manifest-go-package")
+	if err != nil {
+		return err
+	}
+	if manifestGo != "" {
+		if err = manifest.WriteGo(manifestGo, manifestGoPkg); err != nil {
+			return err
+		}
+	}
 
-	return packer.Pack(ftp, out)
+	return nil
 }
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		files     int
+		redirects int
+		rewrites  int
+		rules     int
+		ok        bool
+	}{
+		{
+			name: "files only",
+			yaml: "/index.html:\n  filename: index.html\n" +
+				"/app.js:\n  filename: app.js\n",
+			files: 2,
+			ok:    true,
+		},
+		{
+			name: "full",
+			yaml: "files:\n  /index.html:\n    filename: index.html\n" +
+				"rewrites:\n  /home: /index.html\n",
+			files:    1,
+			rewrites: 1,
+			ok:       true,
+		},
+		{
+			name:      "redirects only",
+			yaml:      "redirects:\n  /old:\n    location: /new\n",
+			redirects: 1,
+			ok:        true,
+		},
+		{
+			name:  "rules only",
+			yaml:  "rules:\n  - match: '*.js'\n    fingerprint: true\n",
+			rules: 1,
+			ok:    true,
+		},
+		{
+			name: "unknown section",
+			yaml: "files: {}\nredirect:\n  /old:\n    location: /new\n",
+		},
+		{
+			name: "unknown file field",
+			yaml: "/index.html:\n  file: index.html\n",
+		},
+		{
+			name: "invalid",
+			yaml: "files: [",
+		},
+	}
+	for _, tt := range tests {
+		spec, err := parseSpec([]byte(tt.yaml))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v, want ok=%v", tt.name, err,
+				tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(spec.Files) != tt.files ||
+			len(spec.Redirects) != tt.redirects ||
+			len(spec.Rewrites) != tt.rewrites ||
+			len(spec.Rules) != tt.rules {
+			t.Errorf("%s: got %d files, %d redirects, %d rewrites, "+
+				"%d rules", tt.name, len(spec.Files),
+				len(spec.Redirects), len(spec.Rewrites),
+				len(spec.Rules))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package packer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"sort"
+
+	"github.com/lwithers/pkg/writefile"
+)
+
+// WriteJSON writes the manifest to a file as a JSON object, keyed by the
+// original path of each file.
+func (m Manifest) WriteJSON(outputFilename string) error {
+	raw, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshaling manifest: %v", err)
+	}
+	raw = append(raw, '\n')
+	return writeFile(outputFilename, raw)
+}
+
+// WriteGo writes the manifest to a file as Go source code, in the named
+// package. The generated code has a map named Manifest from the original path
+// of each file to the URL at which it is served, and a map named Integrity
+// from the original path to its Subresource Integrity string.
+func (m Manifest) WriteGo(outputFilename, pkg string) error {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by htpacker. DO NOT EDIT.\n\n"+
+		"package %s\n\n"+
+		"// Manifest maps the original path of each packed file to the "+
+		"URL at which\n"+
+		"// it is served.\n"+
+		"var Manifest = map[string]string{\n", pkg)
+	for _, path := range paths {
+		fmt.Fprintf(&b, "\t%q: %q,\n", path, m[path].URL)
+	}
+	b.WriteString("}\n\n" +
+		"// Integrity maps the original path of each packed file to its " +
+		"Subresource\n" +
+		"// Integrity string, for use in the HTML \"integrity\" attribute.\n" +
+		"var Integrity = map[string]string{\n")
+	for _, path := range paths {
+		fmt.Fprintf(&b, "\t%q: %q,\n", path, m[path].Integrity)
+	}
+	b.WriteString("}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting generated Go code: %v", err)
+	}
+	return writeFile(outputFilename, src)
+}
+
+// writeFile atomically replaces a file with the given contents.
+func writeFile(outputFilename string, data []byte) error {
+	finalFname, f, err := writefile.New(outputFilename)
+	if err != nil {
+		return err
+	}
+	defer writefile.Abort(f)
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	return writefile.Commit(finalFname, f)
+}
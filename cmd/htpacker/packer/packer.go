@@ -3,11 +3,16 @@ package packer
 import (
 	"bufio"
 	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
+	"strings"
 
 	"golang.org/x/sys/unix"
 
@@ -18,6 +23,71 @@ import (
 
 var BrotliPath string = "brotli"
 
+// Spec is a full specification of a pack: the files it contains, along with
+// any redirects and rewrites.
+type Spec struct {
+	Files     FilesToPack         `yaml:"files"`
+	Redirects map[string]Redirect `yaml:"redirects,omitempty"`
+	Rewrites  map[string]string   `yaml:"rewrites,omitempty"`
+	Rules     []Rule              `yaml:"rules,omitempty"`
+
+	// FingerprintOriginals controls what is served at the original path
+	// of a fingerprinted file. It may be FingerprintRewrite (the default),
+	// FingerprintRedirect or FingerprintNone.
+	FingerprintOriginals string `yaml:"fingerprint_originals,omitempty"`
+
+	// Preload causes each HTML file to be scanned for the stylesheets,
+	// scripts and fonts it references, recording those which are in the
+	// pack as preload hints (served as Link headers or 103 Early Hints).
+	Preload bool `yaml:"preload,omitempty"`
+}
+
+const (
+	// FingerprintRewrite serves fingerprinted files at their original
+	// path too, without redirecting the client.
+	FingerprintRewrite = "rewrite"
+
+	// FingerprintRedirect redirects requests for the original path of a
+	// fingerprinted file to its fingerprinted path.
+	FingerprintRedirect = "redirect"
+
+	// FingerprintNone serves fingerprinted files only at their
+	// fingerprinted path.
+	FingerprintNone = "none"
+)
+
+// fingerprintLen is the number of hex digits of the file's hash that are
+// inserted into the name of a fingerprinted file.
+const fingerprintLen = 16
+
+// Rule applies settings to each file whose served path matches a glob pattern
+// (see path.Match). If the pattern contains no "/", it is matched against the
+// final element of the path only, so "*.woff2" matches fonts in any
+// directory. Rules are applied in order, so later rules take precedence over
+// earlier ones, and settings on an individual file take precedence over all
+// rules.
+type Rule struct {
+	Match       string            `yaml:"match"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+	Fingerprint bool              `yaml:"fingerprint,omitempty"`
+}
+
+// matches returns true if the rule applies to the given served path.
+func (r *Rule) matches(filename string) bool {
+	if !strings.Contains(r.Match, "/") {
+		filename = path.Base(filename)
+	}
+	ok, _ := path.Match(r.Match, filename)
+	return ok
+}
+
+// Redirect sends the client to another URL. Status may be 301, 302, 307 or
+// 308; if it is not set, 301 is used.
+type Redirect struct {
+	Location string `yaml:"location"`
+	Status   uint32 `yaml:"status,omitempty"`
+}
+
 type FilesToPack map[string]FileToPack
 
 type FileToPack struct {
@@ -27,6 +97,27 @@ type FileToPack struct {
 	DisableGzip        bool   `yaml:"disable_gzip"`
 	DisableBrotli      bool   `yaml:"disable_brotli"`
 
+	// Language of the file (e.g. "en" or "de-CH"), if it is
+	// language-specific. Used to choose between variants.
+	Language string `yaml:"language,omitempty"`
+
+	// Headers to emit when serving the file.
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Variants are alternative representations of the file, such as the
+	// same image in other formats or the same page in other languages,
+	// chosen by content negotiation on the Accept and Accept-Language
+	// headers. Variants have their own content type (which should
+	// be set explicitly for formats that cannot be detected, e.g. AVIF)
+	// and headers (added to those of the file), but may not themselves
+	// have variants.
+	Variants []FileToPack `yaml:"variants,omitempty"`
+
+	// Fingerprint causes the file to be served at a path which includes
+	// a hash of its content (e.g. "/app.js" is served as
+	// "/app.0123456789abcdef.js"), allowing it to be cached forever.
+	Fingerprint bool `yaml:"fingerprint,omitempty"`
+
 	uncompressed, gzip, brotli packInfo
 }
 
@@ -49,11 +140,86 @@ const (
 	minCompressionFraction = 7 // i.e. files must be at least 1/128 smaller
 )
 
+// Manifest maps the original path of each packed file to the URL at which it
+// is served, which differs for fingerprinted files.
+type Manifest map[string]ManifestEntry
+
+// ManifestEntry describes a single packed file.
+type ManifestEntry struct {
+	URL       string `json:"url"`
+	Integrity string `json:"integrity"`
+}
+
 // Pack a file.
 func Pack(filesToPack FilesToPack, outputFilename string) error {
+	_, err := PackSpec(&Spec{Files: filesToPack}, outputFilename)
+	return err
+}
+
+// PackSpec packs the files, redirects and rewrites of a full specification
+// into a file. Returns a manifest of the files that were packed.
+func PackSpec(spec *Spec, outputFilename string) (Manifest, error) {
+	dir := packed.Directory{
+		Files:     make(map[string]*packed.File),
+		Redirects: make(map[string]*packed.Redirect),
+		Rewrites:  make(map[string]string),
+	}
+
+	// validate redirects and rewrites before doing any expensive work
+	for path, redir := range spec.Redirects {
+		if err := checkPath(path); err != nil {
+			return nil, fmt.Errorf("redirect %s: %v", path, err)
+		}
+		if _, exists := spec.Files[path]; exists {
+			return nil, fmt.Errorf("redirect %s: path is a file", path)
+		}
+		if redir.Location == "" {
+			return nil, fmt.Errorf("redirect %s: missing location", path)
+		}
+		switch redir.Status {
+		case 0:
+			redir.Status = http.StatusMovedPermanently
+		case 301, 302, 307, 308:
+			// OK
+		default:
+			return nil, fmt.Errorf("redirect %s: status %d not one of "+
+				"301, 302, 307, 308", path, redir.Status)
+		}
+		dir.Redirects[path] = &packed.Redirect{
+			Location: redir.Location,
+			Status:   redir.Status,
+		}
+	}
+	for path, target := range spec.Rewrites {
+		if err := checkPath(path); err != nil {
+			return nil, fmt.Errorf("rewrite %s: %v", path, err)
+		}
+		if _, exists := spec.Files[path]; exists {
+			return nil, fmt.Errorf("rewrite %s: path is a file", path)
+		}
+		if _, exists := spec.Files[target]; !exists {
+			return nil, fmt.Errorf("rewrite %s: target %s is not a file",
+				path, target)
+		}
+		dir.Rewrites[path] = target
+	}
+	for _, rule := range spec.Rules {
+		if _, err := path.Match(rule.Match, ""); err != nil {
+			return nil, fmt.Errorf("rule %q: %v", rule.Match, err)
+		}
+	}
+	switch spec.FingerprintOriginals {
+	case "", FingerprintRewrite, FingerprintRedirect, FingerprintNone:
+		// OK
+	default:
+		return nil, fmt.Errorf("fingerprint_originals %q not one of "+
+			"%s, %s, %s", spec.FingerprintOriginals,
+			FingerprintRewrite, FingerprintRedirect, FingerprintNone)
+	}
+
 	finalFname, outputFile, err := writefile.New(outputFilename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer writefile.Abort(outputFile)
 	packer := &packWriter{f: outputFile}
@@ -68,42 +234,193 @@ func Pack(filesToPack FilesToPack, outputFilename string) error {
 	m, _ := hdr.Marshal()
 	packer.Write(m)
 
-	dir := packed.Directory{
-		Files: make(map[string]*packed.File),
+	manifest := make(Manifest)
+	for path, fileToPack := range spec.Files {
+		info, digest, err := packOne(packer, fileToPack)
+		if err != nil {
+			return nil, err
+		}
+		info.Headers = spec.headers(path, fileToPack)
+
+		var variantDigests [][]byte
+		for _, variantToPack := range fileToPack.Variants {
+			if len(variantToPack.Variants) != 0 {
+				return nil, fmt.Errorf("%s: variant %s may not "+
+					"have variants", path,
+					variantToPack.Filename)
+			}
+			variant, variantDigest, err := packOne(packer,
+				variantToPack)
+			if err != nil {
+				return nil, err
+			}
+			variantDigests = append(variantDigests, variantDigest)
+			variant.Headers = mergeHeaders(info.Headers,
+				variantToPack.Headers)
+			info.Variants = append(info.Variants, &variant)
+		}
+
+		url := path
+		if spec.fingerprint(path, fileToPack) {
+			url = fingerprintPath(path,
+				fingerprintDigest(digest, variantDigests))
+			if err = addFingerprinted(spec, &dir, path, url); err != nil {
+				return nil, err
+			}
+		}
+		dir.Files[url] = &info
+		manifest[path] = ManifestEntry{
+			URL:       url,
+			Integrity: info.Integrity,
+		}
 	}
 
-	for path, fileToPack := range filesToPack {
-		info, err := packOne(packer, fileToPack)
-		if err != nil {
-			return err
+	// rewrites may have targeted a file that has since been fingerprinted
+	for path, target := range spec.Rewrites {
+		dir.Rewrites[path] = manifest[target].URL
+	}
+
+	if spec.Preload {
+		if err = addPreloads(spec, &dir, manifest); err != nil {
+			return nil, err
 		}
-		dir.Files[path] = &info
 	}
 
 	// write the directory
 	if m, err = dir.Marshal(); err != nil {
 		err = fmt.Errorf("marshaling directory object: %v", err)
-		return err
+		return nil, err
 	}
 
 	packer.Pad()
 	hdr.DirectoryOffset = packer.Pos()
 	hdr.DirectoryLength = uint64(len(m))
 	if _, err := packer.Write(m); err != nil {
-		return err
+		return nil, err
 	}
 
 	// write header at start of file
 	m, _ = hdr.Marshal()
 	if _, err = outputFile.WriteAt(m, 0); err != nil {
-		return err
+		return nil, err
 	}
 
 	// all done!
-	return writefile.Commit(finalFname, outputFile)
+	if err = writefile.Commit(finalFname, outputFile); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// fingerprintPath inserts the leading digits of the hex-encoded digest into a
+// path, before its extension (if any).
+func fingerprintPath(filename string, digest []byte) string {
+	hash := hex.EncodeToString(digest)[:fingerprintLen]
+	ext := path.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "." + hash + ext
 }
 
-func packOne(packer *packWriter, fileToPack FileToPack) (info packed.File, err error) {
+// fingerprintDigest returns the digest from which a file's fingerprint is
+// taken. If the file has variants, it covers them as well as the default
+// representation, so that changing any one of them changes the URL.
+func fingerprintDigest(digest []byte, variants [][]byte) []byte {
+	if len(variants) == 0 {
+		return digest
+	}
+	h := sha512.New384()
+	h.Write(digest)
+	for _, variant := range variants {
+		h.Write(variant)
+	}
+	return h.Sum(nil)
+}
+
+// addFingerprinted records the original path of a fingerprinted file in the
+// directory, according to the spec's FingerprintOriginals setting. It returns
+// an error if the fingerprinted path collides with another entry.
+func addFingerprinted(spec *Spec, dir *packed.Directory, orig, url string,
+) error {
+	_, isFile := spec.Files[url]
+	_, isRedirect := spec.Redirects[url]
+	_, isRewrite := spec.Rewrites[url]
+	if isFile || isRedirect || isRewrite {
+		return fmt.Errorf("fingerprinting %s: path %s already in use",
+			orig, url)
+	}
+
+	switch spec.FingerprintOriginals {
+	case "", FingerprintRewrite:
+		dir.Rewrites[orig] = url
+	case FingerprintRedirect:
+		dir.Redirects[orig] = &packed.Redirect{
+			Location: url,
+			Status:   http.StatusFound,
+		}
+	}
+	return nil
+}
+
+// headers returns the headers to be emitted for a file, merging those from
+// matching rules with those set on the file itself.
+func (spec *Spec) headers(filename string, fileToPack FileToPack,
+) map[string]string {
+	hdrs := make(map[string]string)
+	for _, rule := range spec.Rules {
+		if rule.matches(filename) {
+			for hkey, hval := range rule.Headers {
+				hdrs[hkey] = hval
+			}
+		}
+	}
+	return mergeHeaders(hdrs, fileToPack.Headers)
+}
+
+// mergeHeaders returns a new map holding the headers from base, overridden by
+// those in extra. Returns nil if there are no headers at all.
+func mergeHeaders(base, extra map[string]string) map[string]string {
+	if len(base) == 0 && len(extra) == 0 {
+		return nil
+	}
+	hdrs := make(map[string]string, len(base)+len(extra))
+	for hkey, hval := range base {
+		hdrs[hkey] = hval
+	}
+	for hkey, hval := range extra {
+		hdrs[hkey] = hval
+	}
+	return hdrs
+}
+
+// fingerprint returns true if the file should be fingerprinted, either because
+// it is marked as such or because a matching rule says so.
+func (spec *Spec) fingerprint(filename string, fileToPack FileToPack) bool {
+	if fileToPack.Fingerprint {
+		return true
+	}
+	for _, rule := range spec.Rules {
+		if rule.Fingerprint && rule.matches(filename) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPath ensures that a path to be served is absolute and canonical.
+func checkPath(filename string) error {
+	if !path.IsAbs(filename) {
+		return errors.New("path must be absolute")
+	}
+	if path.Clean(filename) != filename {
+		return errors.New("path is not canonical")
+	}
+	return nil
+}
+
+// packOne writes a file (and its compressed versions) into the pack. It
+// returns the directory entry, along with the SHA-384 digest of the file's
+// content (which the Etag is derived from).
+func packOne(packer *packWriter, fileToPack FileToPack,
+) (info packed.File, digest []byte, err error) {
 	// implementation detail: write files at a page boundary
 	if err = packer.Pad(); err != nil {
 		return
@@ -129,7 +446,11 @@ func packOne(packer *packWriter, fileToPack FileToPack) (info packed.File, err e
 	}
 	defer unix.Munmap(data)
 
-	info.Etag = etag(data)
+	sum := sha512.Sum384(data)
+	digest = sum[:]
+	info.Etag = etag(digest)
+	info.Integrity = integrity(digest)
+	info.Language = fileToPack.Language
 	info.ContentType = fileToPack.ContentType
 	if info.ContentType == "" {
 		info.ContentType = http.DetectContentType(data)
@@ -188,10 +509,13 @@ func packOne(packer *packWriter, fileToPack FileToPack) (info packed.File, err e
 	return
 }
 
-func etag(in []byte) string {
-	h := sha512.New384()
-	h.Write(in)
-	return fmt.Sprintf(`"1--%x"`, h.Sum(nil))
+func etag(digest []byte) string {
+	return fmt.Sprintf(`"1--%x"`, digest)
+}
+
+// integrity returns the Subresource Integrity string for a SHA-384 digest.
+func integrity(digest []byte) string {
+	return "sha384-" + base64.StdEncoding.EncodeToString(digest)
 }
 
 func packOneGzip(packer *packWriter, data []byte, uncompressedSize uint64,
@@ -0,0 +1,341 @@
+package packer
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/htpack"
+	"github.com/lwithers/htpack/packed"
+)
+
+func TestFingerprintPath(t *testing.T) {
+	digest := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0xff}
+	tests := []struct {
+		path, want string
+	}{
+		{"/app.js", "/app.0123456789abcdef.js"},
+		{"/css/site.min.css", "/css/site.min.0123456789abcdef.css"},
+		{"/LICENSE", "/LICENSE.0123456789abcdef"},
+	}
+	for _, tt := range tests {
+		if got := fingerprintPath(tt.path, digest); got != tt.want {
+			t.Errorf("fingerprintPath(%q) = %q, want %q",
+				tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestFingerprintVariants checks that changing only a variant of a
+// fingerprinted file changes its URL.
+func TestFingerprintVariants(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	pack := func(webp string) string {
+		spec := &Spec{
+			Files: FilesToPack{
+				"/logo.png": FileToPack{
+					Filename:           write("logo.png", "png data"),
+					DisableCompression: true,
+					Fingerprint:        true,
+					Variants: []FileToPack{{
+						Filename:           write("logo.webp", webp),
+						ContentType:        "image/webp",
+						DisableCompression: true,
+					}},
+				},
+			},
+		}
+		manifest, err := PackSpec(spec, filepath.Join(dir, "out.htpack"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return manifest["/logo.png"].URL
+	}
+
+	first, second, again := pack("webp data"), pack("new webp data"),
+		pack("webp data")
+	if first == second {
+		t.Errorf("URL %s unchanged when variant changed", first)
+	}
+	if first != again {
+		t.Errorf("URL changed from %s to %s for the same content",
+			first, again)
+	}
+}
+
+// loadPack reads back the directory of a pack written by the tests.
+func loadPack(t *testing.T, filename string) *packed.Directory {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, dir, err := packed.Load(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestPackSpec(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	appJS := "console.log('hello');\n"
+	appSum := sha512.Sum384([]byte(appJS))
+	appURL := fingerprintPath("/app.js", appSum[:])
+	files := FilesToPack{
+		"/app.js": {
+			Filename:      write("app.js", appJS),
+			ContentType:   "text/javascript",
+			DisableBrotli: true,
+		},
+		"/index.html": {
+			Filename:      write("index.html", "<p>hi</p>"),
+			DisableBrotli: true,
+		},
+	}
+
+	tests := []struct {
+		originals string
+		rewrite   bool
+		redirect  bool
+	}{
+		{"", true, false},
+		{FingerprintRewrite, true, false},
+		{FingerprintRedirect, false, true},
+		{FingerprintNone, false, false},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(dir, "out.htpack")
+		spec := &Spec{
+			Files: files,
+			Rules: []Rule{{Match: "*.js", Fingerprint: true}},
+			Rewrites: map[string]string{
+				"/home": "/index.html",
+				"/js":   "/app.js",
+			},
+			Redirects: map[string]Redirect{
+				"/legacy": {Location: "/index.html"},
+			},
+			FingerprintOriginals: tt.originals,
+		}
+		manifest, err := PackSpec(spec, out)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.originals, err)
+		}
+
+		want := Manifest{
+			"/app.js": {
+				URL: appURL,
+				Integrity: "sha384-" +
+					base64.StdEncoding.EncodeToString(appSum[:]),
+			},
+			"/index.html": manifest["/index.html"],
+		}
+		if manifest["/index.html"].URL != "/index.html" {
+			t.Errorf("%q: index URL %s", tt.originals,
+				manifest["/index.html"].URL)
+		}
+		if !reflect.DeepEqual(manifest, want) {
+			t.Errorf("%q: manifest %v, want %v", tt.originals,
+				manifest, want)
+		}
+
+		pd := loadPack(t, out)
+		if _, ok := pd.Files[appURL]; !ok {
+			t.Errorf("%q: %s not packed", tt.originals, appURL)
+		}
+		if _, ok := pd.Files["/app.js"]; ok {
+			t.Errorf("%q: original path packed as a file", tt.originals)
+		}
+		if got := pd.Rewrites["/app.js"]; (got == appURL) != tt.rewrite {
+			t.Errorf("%q: rewrite of original %q", tt.originals, got)
+		}
+		redir := pd.Redirects["/app.js"]
+		if (redir != nil && redir.Location == appURL) != tt.redirect {
+			t.Errorf("%q: redirect of original %v", tt.originals, redir)
+		}
+
+		// spec rewrites follow the fingerprinted target; redirects get a
+		// default status
+		if got := pd.Rewrites["/js"]; got != appURL {
+			t.Errorf("%q: rewrite /js to %s", tt.originals, got)
+		}
+		if got := pd.Rewrites["/home"]; got != "/index.html" {
+			t.Errorf("%q: rewrite /home to %s", tt.originals, got)
+		}
+		if redir := pd.Redirects["/legacy"]; redir == nil ||
+			redir.Status != 301 {
+			t.Errorf("%q: redirect /legacy %v", tt.originals, redir)
+		}
+	}
+
+	// the original function packs just files
+	out := filepath.Join(dir, "files.htpack")
+	if err := Pack(files, out); err != nil {
+		t.Fatal(err)
+	}
+	if pd := loadPack(t, out); len(pd.Files) != 2 ||
+		pd.Files["/app.js"] == nil {
+		t.Errorf("Pack: files %v", pd.Files)
+	}
+}
+
+func TestPackSpecErrors(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(filename, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := FilesToPack{"/a.txt": {Filename: filename}}
+	sum := sha512.Sum384([]byte("a"))
+
+	tests := []struct {
+		name string
+		spec Spec
+	}{
+		{"redirect over file", Spec{Files: files,
+			Redirects: map[string]Redirect{"/a.txt": {Location: "/"}}}},
+		{"redirect status", Spec{Files: files,
+			Redirects: map[string]Redirect{"/b": {Location: "/",
+				Status: 200}}}},
+		{"rewrite to nothing", Spec{Files: files,
+			Rewrites: map[string]string{"/b": "/c"}}},
+		{"bad rule", Spec{Files: files, Rules: []Rule{{Match: "["}}}},
+		{"bad originals", Spec{Files: files,
+			FingerprintOriginals: "copy"}},
+		{"collision", Spec{
+			Files: FilesToPack{
+				"/a.txt": {Filename: filename, Fingerprint: true,
+					DisableCompression: true},
+				fingerprintPath("/a.txt", sum[:]): {
+					Filename:           filename,
+					DisableCompression: true,
+				},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		_, err := PackSpec(&tt.spec, filepath.Join(dir, "out.htpack"))
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestManifestWrite(t *testing.T) {
+	dir := t.TempDir()
+	m := Manifest{
+		"/app.js": {URL: "/app.0123456789abcdef.js",
+			Integrity: "sha384-abc"},
+		"/index.html": {URL: "/index.html", Integrity: "sha384-def"},
+	}
+
+	jsonFile := filepath.Join(dir, "manifest.json")
+	if err := m.WriteJSON(jsonFile); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Manifest
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("JSON manifest %v, want %v", got, m)
+	}
+
+	goFile := filepath.Join(dir, "manifest.go")
+	if err := m.WriteGo(goFile, "assets"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err = os.ReadFile(goFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), goFile, raw,
+		0); err != nil {
+		t.Errorf("generated Go does not parse: %v", err)
+	}
+	src := string(raw)
+	for _, want := range []string{
+		"package assets\n",
+		"var Manifest = map[string]string{\n",
+		"\t\"/app.js\":     \"/app.0123456789abcdef.js\",\n",
+		"var Integrity = map[string]string{\n",
+		"\t\"/index.html\": \"sha384-def\",\n",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("generated Go missing %q:\n%s", want, src)
+		}
+	}
+}
+
+// TestIntegrity checks that the Subresource Integrity string served for a
+// packed file is the SHA-384 digest of its uncompressed content.
+func TestIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("body { color: red; }\n", 100)
+	filename := filepath.Join(dir, "site.css")
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.htpack")
+	_, err := PackSpec(&Spec{
+		Files: FilesToPack{
+			"/site.css": {Filename: filename, DisableBrotli: true},
+		},
+		Rewrites: map[string]string{"/style.css": "/site.css"},
+	}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha512.Sum384([]byte(content))
+	want := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	if info := loadPack(t, out).Files["/site.css"]; info.Gzip == nil {
+		t.Error("file not compressed; integrity must cover the " +
+			"uncompressed data")
+	}
+
+	h, err := htpack.New(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	tests := []struct {
+		path, want string
+	}{
+		{"/site.css", want},
+		{"/style.css", want},
+		{"/missing.css", ""},
+	}
+	for _, tt := range tests {
+		if got := h.Integrity(tt.path); got != tt.want {
+			t.Errorf("Integrity(%q) = %q, want %q", tt.path, got,
+				tt.want)
+		}
+	}
+}
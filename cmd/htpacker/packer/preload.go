@@ -0,0 +1,270 @@
+package packer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/lwithers/htpack/packed"
+)
+
+// preloader finds the resources referenced by HTML files which also exist in
+// the pack, so that they may be sent as preload hints. It is used once all
+// files have been packed, so that fingerprinted URLs are known.
+type preloader struct {
+	spec     *Spec
+	dir      *packed.Directory
+	manifest Manifest
+
+	// byURL maps the served URL of each file to its original path
+	byURL map[string]string
+
+	// fonts caches the fonts referenced by each stylesheet
+	fonts map[string][]*packed.Preload
+}
+
+func newPreloader(spec *Spec, dir *packed.Directory, manifest Manifest,
+) *preloader {
+	pl := &preloader{
+		spec:     spec,
+		dir:      dir,
+		manifest: manifest,
+		byURL:    make(map[string]string, len(manifest)),
+		fonts:    make(map[string][]*packed.Preload),
+	}
+	for orig, entry := range manifest {
+		pl.byURL[entry.URL] = orig
+	}
+	return pl
+}
+
+// addPreloads records preload hints for each HTML file in the pack, including
+// HTML variants.
+func addPreloads(spec *Spec, dir *packed.Directory, manifest Manifest) error {
+	pl := newPreloader(spec, dir, manifest)
+	for filePath, fileToPack := range spec.Files {
+		info := dir.Files[manifest[filePath].URL]
+		if err := pl.add(info, filePath, fileToPack.Filename); err != nil {
+			return fmt.Errorf("%s: finding preload hints: %v",
+				filePath, err)
+		}
+		for i, variantToPack := range fileToPack.Variants {
+			err := pl.add(info.Variants[i], filePath,
+				variantToPack.Filename)
+			if err != nil {
+				return fmt.Errorf("%s: finding preload hints: %v",
+					variantToPack.Filename, err)
+			}
+		}
+	}
+	return nil
+}
+
+// add records preload hints for a packed file, if it is an HTML document.
+// filename is the source file, and docPath the path at which it is served
+// (against which relative references are resolved).
+func (pl *preloader) add(info *packed.File, docPath, filename string) error {
+	if !strings.HasPrefix(info.ContentType, "text/html") {
+		return nil
+	}
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	addPreload := func(p *packed.Preload) {
+		if p != nil && !seen[p.Path] {
+			seen[p.Path] = true
+			info.Preloads = append(info.Preloads, p)
+		}
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(raw))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return nil // end of document
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "link":
+				addPreload(pl.link(docPath, tok))
+				if hasToken(attr(tok, "rel"), "stylesheet") {
+					for _, p := range pl.stylesheetFonts(docPath,
+						attr(tok, "href")) {
+						addPreload(p)
+					}
+				}
+
+			case "script":
+				addPreload(pl.script(docPath, tok))
+
+			case "style":
+				if z.Next() != html.TextToken {
+					continue
+				}
+				for _, p := range pl.cssFonts(docPath, string(z.Text())) {
+					addPreload(p)
+				}
+			}
+		}
+	}
+}
+
+// link returns a preload hint for a <link> element referencing a stylesheet,
+// or which is itself a preload of a resource in the pack.
+func (pl *preloader) link(docPath string, tok html.Token) *packed.Preload {
+	rel := attr(tok, "rel")
+	switch {
+	case hasToken(rel, "alternate"):
+		return nil
+	case hasToken(rel, "stylesheet"):
+		return pl.resolve(docPath, attr(tok, "href"), "style",
+			hasAttr(tok, "crossorigin"))
+	case hasToken(rel, "modulepreload"):
+		return pl.resolve(docPath, attr(tok, "href"), "script", true)
+	case hasToken(rel, "preload"):
+		as := attr(tok, "as")
+		switch as {
+		case "style", "script", "font", "image":
+			return pl.resolve(docPath, attr(tok, "href"), as,
+				as == "font" || hasAttr(tok, "crossorigin"))
+		}
+	}
+	return nil
+}
+
+// script returns a preload hint for a <script> element with a src attribute.
+// Module scripts are always fetched in CORS mode.
+func (pl *preloader) script(docPath string, tok html.Token) *packed.Preload {
+	src := attr(tok, "src")
+	if src == "" {
+		return nil
+	}
+	module := strings.EqualFold(attr(tok, "type"), "module")
+	return pl.resolve(docPath, src, "script",
+		module || hasAttr(tok, "crossorigin"))
+}
+
+// stylesheetFonts returns preload hints for the fonts used by a stylesheet
+// in the pack.
+func (pl *preloader) stylesheetFonts(docPath, href string,
+) []*packed.Preload {
+	style := pl.resolve(docPath, href, "style", false)
+	if style == nil {
+		return nil
+	}
+	if fonts, ok := pl.fonts[style.Path]; ok {
+		return fonts
+	}
+
+	var fonts []*packed.Preload
+	orig := pl.byURL[style.Path]
+	if info := pl.dir.Files[style.Path]; info != nil &&
+		strings.HasPrefix(info.ContentType, "text/css") {
+		raw, err := ioutil.ReadFile(pl.spec.Files[orig].Filename)
+		if err == nil {
+			fonts = pl.cssFonts(style.Path, string(raw))
+		}
+	}
+	pl.fonts[style.Path] = fonts
+	return fonts
+}
+
+var (
+	// fontFaceRegexp matches the body of an @font-face rule.
+	fontFaceRegexp = regexp.MustCompile(`(?i)@font-face\s*{([^}]*)}`)
+
+	// cssURLRegexp matches a url() reference, capturing the URL.
+	cssURLRegexp = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)`)
+)
+
+// cssFonts returns preload hints for the fonts declared in a stylesheet,
+// whose URLs are relative to cssPath. Only the first source of each
+// @font-face rule that is present in the pack is used, since browsers will
+// not fetch the others (which are typically older formats).
+func (pl *preloader) cssFonts(cssPath, css string) []*packed.Preload {
+	var fonts []*packed.Preload
+	for _, face := range fontFaceRegexp.FindAllStringSubmatch(css, -1) {
+		for _, m := range cssURLRegexp.FindAllStringSubmatch(face[1], -1) {
+			ref := m[1] + m[2] + m[3]
+			if p := pl.resolve(cssPath, ref, "font", true); p != nil {
+				fonts = append(fonts, p)
+				break
+			}
+		}
+	}
+	return fonts
+}
+
+// resolve converts a reference (e.g. an href attribute) found in the file
+// served at basePath into a preload hint, returning nil unless it refers to a
+// file in the pack. References to the original path of a fingerprinted file
+// are converted to its fingerprinted URL.
+func (pl *preloader) resolve(basePath, ref, as string, crossorigin bool,
+) *packed.Preload {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return nil
+	}
+	target := u.Path
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(basePath), target)
+	}
+	target = path.Clean(target)
+
+	if entry, ok := pl.manifest[target]; ok {
+		target = entry.URL
+	} else if rewrite, ok := pl.dir.Rewrites[target]; ok {
+		target = rewrite
+	}
+	if _, ok := pl.dir.Files[target]; !ok {
+		return nil
+	}
+	return &packed.Preload{
+		Path:        target,
+		As:          as,
+		Crossorigin: crossorigin,
+	}
+}
+
+// attr returns the value of an element's attribute, or an empty string if it
+// is not present.
+func attr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// hasAttr returns true if an element has the given attribute.
+func hasAttr(tok html.Token, key string) bool {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// hasToken returns true if a space-separated list of keywords (such as the
+// rel attribute) contains the given keyword.
+func hasToken(list, keyword string) bool {
+	for _, field := range strings.Fields(list) {
+		if strings.EqualFold(field, keyword) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package packer
+
+import (
+	"crypto/sha512"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lwithers/htpack/packed"
+)
+
+func TestPreload(t *testing.T) {
+	dir := t.TempDir()
+	files := make(FilesToPack)
+	file := func(path, contentType, content string) FileToPack {
+		filename := filepath.Join(dir, filepath.Base(path))
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return FileToPack{
+			Filename:           filename,
+			ContentType:        contentType,
+			DisableCompression: true,
+		}
+	}
+	add := func(path, contentType, content string) {
+		files[path] = file(path, contentType, content)
+	}
+
+	index := file("/index.html", "text/html; charset=utf-8", `<!DOCTYPE html>
+<link rel="stylesheet" href="css/site.css">
+<link rel="stylesheet" href="/css/site.css">
+<link rel="alternate" href="/feed.xml">
+<link rel="preload" href="/img/logo.png" as="image">
+<link rel="stylesheet" href="https://cdn.example.com/x.css">
+<script src="/app.js"></script>
+<script type="module" src="/mod.js"></script>
+<script src="/missing.js"></script>
+<style>
+@font-face {
+	font-family: b;
+	src: url("/fonts/missing.woff2") format("woff2"),
+		url('/fonts/b.woff2') format("woff2");
+}
+</style>
+`)
+	de := file("/index.de.html", "text/html",
+		`<script src="app.js"></script>`)
+	de.Language = "de"
+	index.Language = "en"
+	index.Variants = []FileToPack{de}
+	files["/index.html"] = index
+
+	add("/css/site.css", "text/css", `@font-face {
+	font-family: a;
+	src: url(../fonts/a.woff2) format("woff2"), url(../fonts/a.woff);
+}
+body { background: url(../img/logo.png); }
+`)
+	appJS := "console.log('app');\n"
+	add("/app.js", "text/javascript", appJS)
+	add("/mod.js", "text/javascript", "export {};\n")
+	add("/img/logo.png", "image/png", "png")
+	add("/fonts/a.woff2", "font/woff2", "a")
+	add("/fonts/a.woff", "font/woff", "a")
+	add("/fonts/b.woff2", "font/woff2", "b")
+	add("/feed.xml", "application/atom+xml", "<feed/>")
+
+	appSum := sha512.Sum384([]byte(appJS))
+	appURL := fingerprintPath("/app.js", appSum[:])
+
+	out := filepath.Join(dir, "out.htpack")
+	_, err := PackSpec(&Spec{
+		Files:   files,
+		Rules:   []Rule{{Match: "app.js", Fingerprint: true}},
+		Preload: true,
+	}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pd := loadPack(t, out)
+
+	tests := []struct {
+		name string
+		got  *packed.File
+		want []*packed.Preload
+	}{
+		{"index.html", pd.Files["/index.html"], []*packed.Preload{
+			{Path: "/css/site.css", As: "style"},
+			{Path: "/fonts/a.woff2", As: "font", Crossorigin: true},
+			{Path: "/img/logo.png", As: "image"},
+			{Path: appURL, As: "script"},
+			{Path: "/mod.js", As: "script", Crossorigin: true},
+			{Path: "/fonts/b.woff2", As: "font", Crossorigin: true},
+		}},
+		{"variant", pd.Files["/index.html"].Variants[0],
+			[]*packed.Preload{
+				{Path: appURL, As: "script"},
+			}},
+		{"stylesheet", pd.Files["/css/site.css"], nil},
+	}
+	for _, tt := range tests {
+		got := tt.got.Preloads
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d preloads, want %d: %v", tt.name,
+				len(got), len(tt.want), got)
+			continue
+		}
+		for i := range got {
+			if *got[i] != *tt.want[i] {
+				t.Errorf("%s: preload %d: got %v, want %v",
+					tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
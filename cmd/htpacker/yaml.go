@@ -6,7 +6,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/lwithers/htpack/cmd/htpacker/packer"
@@ -25,6 +28,15 @@ File names will be mapped as follows:
  • if you specify a directory, its contents will be merged into "/", such that a
    directory with contents "a", "b", and "c/d" will cause entries "/a", "/b" and
    "/c/d" to be served.
+
+With --detect-languages, files named in the form "name.LANG.ext" (such as
+"index.de.html" or "index.pt-BR.html"), where LANG is one of the listed
+languages (e.g. --detect-languages de,pt-BR), become language variants of
+"name.ext", chosen according to the client's Accept-Language header. They are
+then no longer served at their own path. Names containing other two-letter
+elements, such as "jquery.ui.css", are left alone. --default-language sets the
+language of "name.ext"; if that file does not exist, the file in the default
+language takes its place.
 `,
 	RunE: func(c *cobra.Command, args []string) error {
 		if len(args) == 0 {
@@ -52,7 +64,23 @@ File names will be mapped as follows:
 			}
 		}
 
-		if err := MakeYaml(args, out); err != nil {
+		languages, err := c.Flags().GetStringSlice("detect-languages")
+		if err != nil {
+			return err
+		}
+		defaultLanguage, err := c.Flags().GetString("default-language")
+		if err != nil {
+			return err
+		}
+		for _, lang := range languages {
+			if !languageTag.MatchString(lang) {
+				return fmt.Errorf("--detect-languages: %q is not "+
+					"a language tag such as de or pt-BR", lang)
+			}
+		}
+
+		if err := MakeYaml(args, out, languages,
+			defaultLanguage); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
@@ -66,13 +94,22 @@ func init() {
 	yamlCmd.MarkFlagRequired("out")
 	yamlCmd.Flags().StringP("chdir", "C", "",
 		"Change to directory before searching for input files")
+	yamlCmd.Flags().StringSlice("detect-languages", nil,
+		"Treat files named like index.de.html as language variants of index.html, for these languages (e.g. de,pt-BR)")
+	yamlCmd.Flags().String("default-language", "",
+		"Language of files without a language in their name (e.g. en)")
 }
 
-func MakeYaml(args []string, out string) error {
+func MakeYaml(args []string, out string, languages []string,
+	defaultLanguage string,
+) error {
 	ftp, err := filesFromList(args)
 	if err != nil {
 		return err
 	}
+	if len(languages) > 0 {
+		detectLanguages(ftp, languages, defaultLanguage)
+	}
 
 	raw, err := yaml.Marshal(ftp)
 	if err != nil {
@@ -172,3 +209,88 @@ func filesFromListR(prefix, arg string, ftp packer.FilesToPack) error {
 		return fmt.Errorf("%s: not file/dir (mode %x)", arg, fi.Mode())
 	}
 }
+
+// languageTag matches the language tags which may be passed to
+// --detect-languages, such as "de" or "pt-BR".
+var languageTag = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+
+// languageFile is a file whose name includes a language tag.
+type languageFile struct {
+	srvName, lang string
+}
+
+// detectLanguages finds files named in the form "name.LANG.ext", where LANG is
+// one of the given languages or defaultLanguage, and turns them into language
+// variants of "name.ext". If "name.ext" does not exist, but a file in
+// defaultLanguage does, then that file is served as "name.ext" instead
+// (otherwise, the files are left alone).
+func detectLanguages(ftp packer.FilesToPack, languages []string,
+	defaultLanguage string,
+) {
+	known := make(map[string]bool)
+	for _, lang := range languages {
+		known[lang] = true
+	}
+	if defaultLanguage != "" {
+		known[defaultLanguage] = true
+	}
+
+	// group files by the path that they are a variant of
+	groups := make(map[string][]languageFile)
+	members := make(map[string]bool)
+	for srvName := range ftp {
+		ext := path.Ext(srvName)
+		stem := strings.TrimSuffix(srvName, ext)
+		lang := strings.TrimPrefix(path.Ext(stem), ".")
+		if !known[lang] {
+			continue
+		}
+		base := strings.TrimSuffix(stem, "."+lang) + ext
+		groups[base] = append(groups[base], languageFile{
+			srvName: srvName,
+			lang:    lang,
+		})
+		members[srvName] = true
+	}
+
+	for base, files := range groups {
+		if members[base] {
+			// variants may not themselves have variants
+			continue
+		}
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].srvName < files[j].srvName
+		})
+
+		main, exists := ftp[base]
+		if exists {
+			if main.Language == "" {
+				main.Language = defaultLanguage
+			}
+		} else {
+			// promote the file in the default language, if any
+			promote := -1
+			for i := range files {
+				if defaultLanguage != "" &&
+					files[i].lang == defaultLanguage {
+					promote = i
+				}
+			}
+			if promote == -1 {
+				continue
+			}
+			main = ftp[files[promote].srvName]
+			main.Language = files[promote].lang
+			delete(ftp, files[promote].srvName)
+			files = append(files[:promote], files[promote+1:]...)
+		}
+
+		for _, file := range files {
+			variant := ftp[file.srvName]
+			variant.Language = file.lang
+			main.Variants = append(main.Variants, variant)
+			delete(ftp, file.srvName)
+		}
+		ftp[base] = main
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lwithers/htpack/cmd/htpacker/packer"
+)
+
+func TestDetectLanguages(t *testing.T) {
+	tests := []struct {
+		name            string
+		files           []string
+		languages       []string
+		defaultLanguage string
+
+		// want maps each path left in the spec to its language and the
+		// languages of its variants
+		want map[string][]string
+	}{
+		{
+			name: "variants",
+			files: []string{"/index.html", "/index.de.html",
+				"/index.pt-BR.html"},
+			languages: []string{"de", "pt-BR"},
+			want: map[string][]string{
+				"/index.html": {"", "de", "pt-BR"},
+			},
+		},
+		{
+			name:            "default language",
+			files:           []string{"/index.html", "/index.de.html"},
+			languages:       []string{"de"},
+			defaultLanguage: "en",
+			want: map[string][]string{
+				"/index.html": {"en", "de"},
+			},
+		},
+		{
+			name:            "promote default",
+			files:           []string{"/index.en.html", "/index.de.html"},
+			languages:       []string{"de"},
+			defaultLanguage: "en",
+			want: map[string][]string{
+				"/index.html": {"en", "de"},
+			},
+		},
+		{
+			name:      "no default to promote",
+			files:     []string{"/index.en.html", "/index.de.html"},
+			languages: []string{"de", "en"},
+			want: map[string][]string{
+				"/index.en.html": {""},
+				"/index.de.html": {""},
+			},
+		},
+		{
+			name: "unlisted two-letter elements",
+			files: []string{"/jquery.css", "/jquery.ui.css",
+				"/foo.map", "/foo.js.map", "/index.html",
+				"/index.fr.html"},
+			languages: []string{"de"},
+			want: map[string][]string{
+				"/jquery.css":    {""},
+				"/jquery.ui.css": {""},
+				"/foo.map":       {""},
+				"/foo.js.map":    {""},
+				"/index.html":    {""},
+				"/index.fr.html": {""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		ftp := make(packer.FilesToPack)
+		for _, name := range tt.files {
+			ftp[name] = packer.FileToPack{Filename: name[1:]}
+		}
+		detectLanguages(ftp, tt.languages, tt.defaultLanguage)
+
+		got := make(map[string][]string)
+		for name, file := range ftp {
+			langs := []string{file.Language}
+			for _, variant := range file.Variants {
+				langs = append(langs, variant.Language)
+			}
+			sort.Strings(langs[1:])
+			got[name] = langs
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
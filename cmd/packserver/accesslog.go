@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lwithers/htpack"
+)
+
+const (
+	accessLogCommon = "common"
+	accessLogJSON   = "json"
+)
+
+// accessLog writes a line for each request to a file, in either Common Log
+// Format or JSON.
+type accessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// newAccessLog opens the named access log file ("-" meaning stdout) for
+// appending, in the given format.
+func newAccessLog(filename, format string) (*accessLog, error) {
+	switch format {
+	case accessLogCommon, accessLogJSON:
+		// OK
+	default:
+		return nil, fmt.Errorf("access log format %q not one of %s, %s",
+			format, accessLogCommon, accessLogJSON)
+	}
+
+	if filename == "-" {
+		return &accessLog{w: os.Stdout, format: format}, nil
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0666)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLog{w: f, format: format}, nil
+}
+
+// jsonAccessLogEntry is the structure of each line of a JSON access log.
+type jsonAccessLogEntry struct {
+	Time     time.Time `json:"time"`
+	Remote   string    `json:"remote"`
+	Method   string    `json:"method"`
+	URI      string    `json:"uri"`
+	Proto    string    `json:"proto"`
+	Status   int       `json:"status"`
+	Bytes    uint64    `json:"bytes"`
+	Encoding string    `json:"encoding,omitempty"`
+	Range    bool      `json:"range,omitempty"`
+	Partial  bool      `json:"partial,omitempty"`
+	Sendfile bool      `json:"sendfile"`
+	Latency  float64   `json:"latency"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Log writes out a line describing the event. It is suitable for passing to
+// htpack.Handler.SetLogger.
+func (al *accessLog) Log(ev *htpack.Event) {
+	req := ev.Request
+	remote, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remote = req.RemoteAddr
+	}
+
+	var line []byte
+	switch al.format {
+	case accessLogJSON:
+		entry := jsonAccessLogEntry{
+			Time:     ev.Start,
+			Remote:   remote,
+			Method:   req.Method,
+			URI:      req.RequestURI,
+			Proto:    req.Proto,
+			Status:   ev.Status,
+			Bytes:    ev.Bytes,
+			Encoding: ev.Encoding,
+			Range:    ev.Range,
+			Partial:  ev.Partial,
+			Sendfile: ev.Sendfile,
+			Latency:  ev.Latency.Seconds(),
+		}
+		if ev.Err != nil {
+			entry.Error = ev.Err.Error()
+		}
+		line, _ = json.Marshal(&entry)
+		line = append(line, '\n')
+
+	default:
+		// https://httpd.apache.org/docs/current/logs.html#common
+		user, _, ok := req.BasicAuth()
+		if !ok || user == "" {
+			user = "-"
+		}
+		bytes := "-"
+		if ev.Bytes > 0 {
+			bytes = fmt.Sprint(ev.Bytes)
+		}
+		line = []byte(fmt.Sprintf("%s - %s [%s] %q %d %s\n",
+			remote, user, ev.Start.Format("02/Jan/2006:15:04:05 -0700"),
+			req.Method+" "+req.RequestURI+" "+req.Proto,
+			ev.Status, bytes))
+	}
+
+	al.mu.Lock()
+	al.w.Write(line)
+	al.mu.Unlock()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lwithers/htpack"
+)
+
+func TestAccessLog(t *testing.T) {
+	start := time.Date(2024, 3, 5, 14, 7, 9, 0, time.FixedZone("", 3600))
+	newRequest := func(method, target, remote string) *htpack.Event {
+		r := httptest.NewRequest(method, target, nil)
+		r.RemoteAddr = remote
+		return &htpack.Event{Request: r, Start: start}
+	}
+
+	rangeEv := newRequest("GET", "/big.bin?x=1", "192.0.2.1:4000")
+	rangeEv.Request.Header.Set("Range", "bytes=0-99")
+	rangeEv.Request.SetBasicAuth("alice", "secret")
+	rangeEv.Status = 206
+	rangeEv.Bytes = 100
+	rangeEv.Range, rangeEv.Partial, rangeEv.Sendfile = true, true, true
+	rangeEv.Latency = 1500 * time.Microsecond
+
+	plainEv := newRequest("GET", "/index.html", "[2001:db8::1]:4000")
+	plainEv.Status = 200
+	plainEv.Bytes = 5120
+	plainEv.Latency = 2 * time.Millisecond
+	plainEv.Err = errors.New("broken pipe")
+
+	gzipEv := newRequest("GET", "/app.js", "@")
+	gzipEv.Status = 200
+	gzipEv.Bytes = 42
+	gzipEv.Encoding = "gzip"
+
+	headEv := newRequest("HEAD", "/index.html", "192.0.2.1:4001")
+	headEv.Status = 200
+
+	events := []*htpack.Event{rangeEv, plainEv, gzipEv, headEv}
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{accessLogCommon, []string{
+			`192.0.2.1 - alice [05/Mar/2024:14:07:09 +0100] "GET /big.bin?x=1 HTTP/1.1" 206 100`,
+			`2001:db8::1 - - [05/Mar/2024:14:07:09 +0100] "GET /index.html HTTP/1.1" 200 5120`,
+			`@ - - [05/Mar/2024:14:07:09 +0100] "GET /app.js HTTP/1.1" 200 42`,
+			`192.0.2.1 - - [05/Mar/2024:14:07:09 +0100] "HEAD /index.html HTTP/1.1" 200 -`,
+		}},
+		{accessLogJSON, []string{
+			`{"time":"2024-03-05T14:07:09+01:00","remote":"192.0.2.1","method":"GET","uri":"/big.bin?x=1","proto":"HTTP/1.1","status":206,"bytes":100,"range":true,"partial":true,"sendfile":true,"latency":0.0015}`,
+			`{"time":"2024-03-05T14:07:09+01:00","remote":"2001:db8::1","method":"GET","uri":"/index.html","proto":"HTTP/1.1","status":200,"bytes":5120,"sendfile":false,"latency":0.002,"error":"broken pipe"}`,
+			`{"time":"2024-03-05T14:07:09+01:00","remote":"@","method":"GET","uri":"/app.js","proto":"HTTP/1.1","status":200,"bytes":42,"encoding":"gzip","sendfile":false,"latency":0}`,
+			`{"time":"2024-03-05T14:07:09+01:00","remote":"192.0.2.1","method":"HEAD","uri":"/index.html","proto":"HTTP/1.1","status":200,"bytes":0,"sendfile":false,"latency":0}`,
+		}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		al := &accessLog{w: &buf, format: tt.format}
+		for _, ev := range events {
+			al.Log(ev)
+		}
+		got := strings.Split(strings.TrimSuffix(buf.String(), "\n"),
+			"\n")
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d lines, want %d:\n%s", tt.format,
+				len(got), len(tt.want), buf.String())
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: line %d:\ngot  %s\nwant %s",
+					tt.format, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestNewAccessLog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(filename, []byte("existing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newAccessLog(filename, "xml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+
+	al, err := newAccessLog(filename, accessLogCommon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &htpack.Event{
+		Request: httptest.NewRequest("GET", "/", nil),
+		Status:  404,
+	}
+	al.Log(ev)
+	al.w.(*os.File).Close()
+
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(raw), "\n")
+	if len(lines) != 3 || lines[0] != "existing" ||
+		!strings.HasSuffix(lines[1], `"GET / HTTP/1.1" 404 -`) {
+		t.Errorf("log not appended:\n%s", raw)
+	}
+}
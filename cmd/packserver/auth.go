@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/lwithers/htpack"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authConfig describes how the requests to a mount are authenticated. The
+// files it names are (re)read each time the site is loaded.
+type authConfig struct {
+	realm        string
+	htpasswdFile string
+	tokenFile    string
+}
+
+// defaultAuthRealm is the realm sent in WWW-Authenticate if none is set.
+const defaultAuthRealm = "packserver"
+
+// maxVerifiedCredentials bounds the cache of credentials whose bcrypt hash has
+// already been checked.
+const maxVerifiedCredentials = 1024
+
+// authHandler requires each request to carry either HTTP Basic credentials
+// matching an htpasswd file, or a bearer token from a token file, before
+// passing it on. Other requests receive a 401 response.
+type authHandler struct {
+	realm   string
+	users   map[string][]byte
+	tokens  map[[sha256.Size]byte]bool
+	cors    bool
+	logger  func(*htpack.Event)
+	handler http.Handler
+
+	// verified caches the credentials which have been checked against
+	// their bcrypt hash, which is deliberately slow to compute, since
+	// clients send them with every request
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]bool
+}
+
+// newAuthHandler loads the htpasswd and token files for a mount, returning a
+// handler which authenticates requests before passing them on. If cors is
+// set, CORS preflight requests (which never carry credentials) are passed on
+// without authentication.
+func newAuthHandler(cfg *authConfig, cors bool, logger func(*htpack.Event),
+	handler http.Handler,
+) (*authHandler, error) {
+	ah := &authHandler{
+		realm:    cfg.realm,
+		cors:     cors,
+		logger:   logger,
+		handler:  handler,
+		verified: make(map[[sha256.Size]byte]bool),
+	}
+	if ah.realm == "" {
+		ah.realm = defaultAuthRealm
+	}
+
+	if cfg.htpasswdFile != "" {
+		users, err := loadHtpasswd(cfg.htpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		ah.users = users
+	}
+	if cfg.tokenFile != "" {
+		tokens, err := loadTokens(cfg.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		ah.tokens = tokens
+	}
+	return ah, nil
+}
+
+func (ah *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ah.authorized(r) {
+		ah.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if ah.users != nil {
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(
+			"Basic realm=%q, charset=\"UTF-8\"", ah.realm))
+	}
+	if ah.tokens != nil {
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(
+			"Bearer realm=%q", ah.realm))
+	}
+	htpack.LogError(ah.logger, w, r, http.StatusUnauthorized,
+		"401 unauthorized")
+}
+
+// authorized returns true if the request carries valid credentials, or is a
+// CORS preflight request which may be answered without them.
+func (ah *authHandler) authorized(r *http.Request) bool {
+	if ah.cors && r.Method == "OPTIONS" &&
+		r.Header.Get("Access-Control-Request-Method") != "" {
+		return true
+	}
+
+	authz := r.Header.Get("Authorization")
+	if pos := strings.IndexByte(authz, ' '); pos != -1 &&
+		strings.EqualFold(authz[:pos], "Bearer") && ah.tokens != nil {
+		token := strings.TrimSpace(authz[pos+1:])
+		return ah.tokens[sha256.Sum256([]byte(token))]
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok || ah.users == nil {
+		return false
+	}
+	hash, ok := ah.users[user]
+	if !ok {
+		return false
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" +
+		string(hash)))
+	ah.mu.Lock()
+	ok = ah.verified[key]
+	ah.mu.Unlock()
+	if ok {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+	ah.mu.Lock()
+	if len(ah.verified) >= maxVerifiedCredentials {
+		ah.verified = make(map[[sha256.Size]byte]bool)
+	}
+	ah.verified[key] = true
+	ah.mu.Unlock()
+	return true
+}
+
+// loadHtpasswd reads an htpasswd file, which has a line in the form
+// "user:hash" for each user. Only bcrypt hashes (as written by "htpasswd -B")
+// are supported. Blank lines and comments starting with '#' are ignored.
+func loadHtpasswd(filename string) (map[string][]byte, error) {
+	users := make(map[string][]byte)
+	err := readAuthFile(filename, func(line string) error {
+		pos := strings.IndexByte(line, ':')
+		if pos <= 0 {
+			return errors.New("expecting user:hash")
+		}
+		user, hash := line[:pos], []byte(line[pos+1:])
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return fmt.Errorf("user %q: not a bcrypt hash", user)
+		}
+		users[user] = hash
+		return nil
+	})
+	return users, err
+}
+
+// loadTokens reads a file of bearer tokens, one per line. Blank lines and
+// comments starting with '#' are ignored.
+func loadTokens(filename string) (map[[sha256.Size]byte]bool, error) {
+	tokens := make(map[[sha256.Size]byte]bool)
+	err := readAuthFile(filename, func(token string) error {
+		if strings.ContainsAny(token, " \t") {
+			return errors.New("token contains whitespace")
+		}
+		tokens[sha256.Sum256([]byte(token))] = true
+		return nil
+	})
+	return tokens, err
+}
+
+// readAuthFile calls fn for each non-blank, non-comment line of a file,
+// returning the first error (annotated with the line number).
+func readAuthFile(filename string, fn func(line string) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var lineNum int
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineNum++
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// minSigningKeyLen is the minimum length of a key for signed URLs.
+const minSigningKeyLen = 16
+
+// loadSigningKeys reads a file of keys for verifying signed URLs (see
+// htpack.SignURL), one per line. Blank lines and comments starting with '#'
+// are ignored.
+func loadSigningKeys(filename string) ([][]byte, error) {
+	var keys [][]byte
+	err := readAuthFile(filename, func(key string) error {
+		if len(key) < minSigningKeyLen {
+			return fmt.Errorf("key shorter than %d characters",
+				minSigningKeyLen)
+		}
+		keys = append(keys, []byte(key))
+		return nil
+	})
+	if err == nil && len(keys) == 0 {
+		err = fmt.Errorf("%s: no keys found", filename)
+	}
+	return keys, err
+}
+
+// privateWriter ensures that the Cache-Control header of a response marks it
+// as private, so that it is not stored by shared caches, whatever the handler
+// set. It passes through the http.Hijacker and http.Flusher interfaces, so
+// that sendfile(2) may still be used.
+type privateWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (pw *privateWriter) WriteHeader(status int) {
+	// informational (1xx) responses are not cached
+	if !pw.wroteHeader && status >= 200 {
+		hdr := pw.Header()
+		hdr.Set("Cache-Control", privateCacheControl(
+			hdr.Get("Cache-Control")))
+		pw.wroteHeader = true
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *privateWriter) Write(buf []byte) (int, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	return pw.ResponseWriter.Write(buf)
+}
+
+func (pw *privateWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := pw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	return hj.Hijack()
+}
+
+func (pw *privateWriter) Flush() {
+	if f, ok := pw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// privateCacheControl converts a Cache-Control value for use on a protected
+// response: "public" and "s-maxage" are removed, and "private" is added unless
+// the response may not be stored at all.
+func privateCacheControl(cc string) string {
+	directives := []string{"private"}
+	for _, directive := range strings.Split(cc, ",") {
+		directive = strings.TrimSpace(directive)
+		name := strings.ToLower(directive)
+		if pos := strings.IndexByte(name, '='); pos != -1 {
+			name = name[:pos]
+		}
+		switch name {
+		case "", "public", "s-maxage", "private":
+			continue
+		case "no-store":
+			directives[0] = directive
+			continue
+		}
+		directives = append(directives, directive)
+	}
+	return strings.Join(directives, ", ")
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/htpack"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestAuthHandler returns an authHandler for user "alice" with password
+// "secret", and token "t0ken", passing authorized requests to a handler which
+// responds "ok". Rejected requests are appended to *logged.
+func newTestAuthHandler(t *testing.T, cors bool, logged *[]*htpack.Event,
+) *authHandler {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"),
+		bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cfg := &authConfig{
+		realm:        "test",
+		htpasswdFile: filepath.Join(dir, "htpasswd"),
+		tokenFile:    filepath.Join(dir, "tokens"),
+	}
+	err = os.WriteFile(cfg.htpasswdFile,
+		[]byte("# users\nalice:"+string(hash)+"\n"), 0600)
+	if err == nil {
+		err = os.WriteFile(cfg.tokenFile, []byte("\nt0ken\n"), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	logger := func(ev *htpack.Event) {
+		*logged = append(*logged, ev)
+	}
+	ah, err := newAuthHandler(cfg, cors, logger, ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ah
+}
+
+func TestAuthHandler(t *testing.T) {
+	var logged []*htpack.Event
+	withCORS := newTestAuthHandler(t, true, &logged)
+	withoutCORS := newTestAuthHandler(t, false, &logged)
+
+	preflight := map[string]string{
+		"Origin":                        "https://example.com",
+		"Access-Control-Request-Method": "GET",
+	}
+	tests := []struct {
+		name    string
+		ah      *authHandler
+		method  string
+		user    string // for Basic auth, if set
+		pass    string
+		headers map[string]string
+		status  int
+	}{
+		{"no credentials", withCORS, "GET", "", "", nil, 401},
+		{"basic", withCORS, "GET", "alice", "secret", nil, 200},
+		{"basic wrong password", withCORS, "GET", "alice", "Secret",
+			nil, 401},
+		{"basic unknown user", withCORS, "GET", "bob", "secret", nil,
+			401},
+		{"bearer", withCORS, "GET", "", "", map[string]string{
+			"Authorization": "Bearer t0ken",
+		}, 200},
+		{"lowercase bearer", withCORS, "GET", "", "",
+			map[string]string{"Authorization": "bearer  t0ken "}, 200},
+		{"bearer mismatch", withCORS, "GET", "", "", map[string]string{
+			"Authorization": "Bearer t0ken2",
+		}, 401},
+		{"bearer prefix only", withCORS, "GET", "", "",
+			map[string]string{"Authorization": "Bearer"}, 401},
+		{"preflight", withCORS, "OPTIONS", "", "", preflight, 200},
+		{"OPTIONS without preflight", withCORS, "OPTIONS", "", "", nil,
+			401},
+		{"preflight without CORS", withoutCORS, "OPTIONS", "", "",
+			preflight, 401},
+	}
+	for _, tt := range tests {
+		logged = nil
+		r := httptest.NewRequest(tt.method, "/private", nil)
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		for hkey, hval := range tt.headers {
+			r.Header.Set(hkey, hval)
+		}
+		w := httptest.NewRecorder()
+		tt.ah.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code,
+				tt.status)
+			continue
+		}
+		if tt.status == http.StatusOK {
+			if len(logged) != 0 {
+				t.Errorf("%s: accepted request logged", tt.name)
+			}
+			continue
+		}
+
+		want := `Basic realm="test", charset="UTF-8"|Bearer realm="test"`
+		got := strings.Join(w.Header().Values("WWW-Authenticate"), "|")
+		if got != want {
+			t.Errorf("%s: WWW-Authenticate %q, want %q", tt.name,
+				got, want)
+		}
+		if len(logged) != 1 || logged[0].Status != tt.status {
+			t.Errorf("%s: rejection not logged", tt.name)
+		}
+	}
+}
+
+// TestAuthVerifiedCache checks that credentials are only checked against
+// their bcrypt hash once, and that the cache is bounded.
+func TestAuthVerifiedCache(t *testing.T) {
+	var logged []*htpack.Event
+	ah := newTestAuthHandler(t, false, &logged)
+	serve := func(user, pass string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth(user, pass)
+		w := httptest.NewRecorder()
+		ah.ServeHTTP(w, r)
+		return w.Code
+	}
+	cacheKey := func(user, pass string) [sha256.Size]byte {
+		return sha256.Sum256([]byte(user + "\x00" + pass + "\x00" +
+			string(ah.users[user])))
+	}
+
+	if status := serve("alice", "wrong"); status != 401 {
+		t.Errorf("wrong password: status %d", status)
+	}
+	if len(ah.verified) != 0 {
+		t.Errorf("wrong password cached")
+	}
+	if status := serve("alice", "secret"); status != 200 {
+		t.Errorf("right password: status %d", status)
+	}
+	if !ah.verified[cacheKey("alice", "secret")] || len(ah.verified) != 1 {
+		t.Errorf("right password not cached")
+	}
+
+	// a cached entry is trusted without checking the hash, so an entry
+	// for a wrong password shows that the cache was used
+	ah.verified[cacheKey("alice", "cached")] = true
+	if status := serve("alice", "cached"); status != 200 {
+		t.Errorf("cached password: status %d", status)
+	}
+	if status := serve("bob", "cached"); status != 401 {
+		t.Errorf("cached password for other user: status %d", status)
+	}
+
+	// once full, the cache is emptied before adding another entry
+	ah.verified = make(map[[sha256.Size]byte]bool)
+	for i := 0; i < maxVerifiedCredentials; i++ {
+		ah.verified[sha256.Sum256([]byte(strconv.Itoa(i)))] = true
+	}
+	if status := serve("alice", "secret"); status != 200 {
+		t.Errorf("right password with full cache: status %d", status)
+	}
+	if len(ah.verified) != 1 || !ah.verified[cacheKey("alice", "secret")] {
+		t.Errorf("cache not reset: %d entries", len(ah.verified))
+	}
+}
+
+func TestPrivateCacheControl(t *testing.T) {
+	tests := []struct {
+		cc, want string
+	}{
+		{"", "private"},
+		{"public, max-age=60", "private, max-age=60"},
+		{"Public,max-age=60 , s-maxage=600", "private, max-age=60"},
+		{"s-maxage=600, must-revalidate",
+			"private, must-revalidate"},
+		{"private, max-age=60", "private, max-age=60"},
+		{"no-store", "no-store"},
+		{"public, no-store, max-age=0", "no-store, max-age=0"},
+		{"no-cache", "private, no-cache"},
+		{"public, max-age=31536000, immutable",
+			"private, max-age=31536000, immutable"},
+	}
+	for _, tt := range tests {
+		if got := privateCacheControl(tt.cc); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.cc, got, tt.want)
+		}
+	}
+}
+
+// TestAddHeadersPrivate checks that responses from mounts which require
+// authentication are marked private, whatever Cache-Control is set.
+func TestAddHeadersPrivate(t *testing.T) {
+	tests := []struct {
+		name    string
+		private bool
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"mount header", true,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("ok"))
+			}, "private, max-age=60"},
+		{"handler header", true,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control",
+					"public, max-age=31536000, immutable")
+				w.WriteHeader(http.StatusOK)
+			}, "private, max-age=31536000, immutable"},
+		{"no-store", true,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "no-store")
+				w.WriteHeader(http.StatusNotFound)
+			}, "no-store"},
+		{"after early hints", true,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusOK)
+			}, "private, max-age=60"},
+		{"not private", false,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("ok"))
+			}, "public, max-age=60"},
+	}
+	for _, tt := range tests {
+		ah := &addHeaders{
+			extraHeaders: http.Header{
+				"Cache-Control": {"public, max-age=60"},
+			},
+			handler: tt.handler,
+			private: tt.private,
+		}
+		w := httptest.NewRecorder()
+		ah.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if got := w.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: Cache-Control %q, want %q", tt.name, got,
+				tt.want)
+		}
+	}
+}
@@ -0,0 +1,702 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lwithers/htpack"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// configFile is the structure of the YAML file passed to --config.
+type configFile struct {
+	Listeners   []configListener   `yaml:"listeners"`
+	Mounts      []configMount      `yaml:"mounts"`
+	Hosts       []configHost       `yaml:"hosts"`
+	UnknownHost *configUnknownHost `yaml:"unknown_host"`
+	HSTS        *configHSTS        `yaml:"hsts"`
+	Limits      *configLimits      `yaml:"limits"`
+}
+
+// configListener describes an address to serve on. If Key is set, HTTPS is
+// served; Cert defaults to the same file as Key. Setting TLS serves HTTPS
+// using only the certificates of the virtual hosts. H2C accepts cleartext
+// HTTP/2 on a plain HTTP listener. Mode and Owner apply to
+// Unix domain sockets. The TLS options (MinVersion etc.) are as for the
+// corresponding --tls-* and --client-* flags.
+//
+// A listener with RedirectHTTPS serves plain HTTP, redirecting to HTTPS on
+// HTTPSPort (by default 443), except for ACME challenges which are served from
+// ACMEChallenge (a directory or .htpack file) if it is set.
+type configListener struct {
+	Bind  string `yaml:"bind"`
+	Key   string `yaml:"key"`
+	Cert  string `yaml:"cert"`
+	TLS   bool   `yaml:"tls"`
+	H2C   bool   `yaml:"h2c"`
+	Mode  string `yaml:"mode"`
+	Owner string `yaml:"owner"`
+
+	MinVersion   string   `yaml:"tls_min_version"`
+	CipherSuites []string `yaml:"cipher_suites"`
+	ALPN         []string `yaml:"alpn"`
+	ClientCA     string   `yaml:"client_ca"`
+	ClientAuth   string   `yaml:"client_auth"`
+
+	RedirectHTTPS bool   `yaml:"redirect_https"`
+	HTTPSPort     int    `yaml:"https_port"`
+	ACMEChallenge string `yaml:"acme_challenge"`
+}
+
+// configHSTS describes the Strict-Transport-Security header set on HTTPS
+// responses.
+type configHSTS struct {
+	MaxAge            time.Duration `yaml:"max_age"`
+	IncludeSubDomains bool          `yaml:"include_subdomains"`
+	Preload           bool          `yaml:"preload"`
+}
+
+// configLimits describes the per-client request and bandwidth limits, and the
+// cap on concurrent connections. The timeouts apply only if MaxConnections is
+// set; if omitted, the defaults (as for the flags) are used.
+type configLimits struct {
+	RequestsPerSecond float64        `yaml:"requests_per_second"`
+	RequestBurst      int            `yaml:"request_burst"`
+	BytesPerSecond    float64        `yaml:"bytes_per_second"`
+	ByteBurst         int            `yaml:"byte_burst"`
+	TrustedProxies    []string       `yaml:"trusted_proxies"`
+	MaxConnections    int            `yaml:"max_connections"`
+	IdleTimeout       *time.Duration `yaml:"idle_timeout"`
+	ReadHeaderTimeout *time.Duration `yaml:"read_header_timeout"`
+	Status            int            `yaml:"status"`
+	Message           string         `yaml:"message"`
+}
+
+// configHost describes a virtual host, with its own mounts and (optionally)
+// certificate. The top level mounts, if any, make up the default host;
+// otherwise, one virtual host may be marked as the default.
+type configHost struct {
+	Names   []string      `yaml:"names"`
+	Default bool          `yaml:"default"`
+	Key     string        `yaml:"key"`
+	Cert    string        `yaml:"cert"`
+	Mounts  []configMount `yaml:"mounts"`
+}
+
+// configUnknownHost describes the response to requests for a host which is
+// not configured, if there is no default host.
+type configUnknownHost struct {
+	Status  int    `yaml:"status"`
+	Message string `yaml:"message"`
+}
+
+// configMount describes the pack files served at a prefix, and how they are
+// served.
+type configMount struct {
+	Prefix     string            `yaml:"prefix"`
+	Packs      []string          `yaml:"packs"`
+	IndexFile  string            `yaml:"index_file"`
+	Headers    map[string]string `yaml:"headers"`
+	HeaderFile string            `yaml:"header_file"`
+	Expiry     time.Duration     `yaml:"expiry"`
+	Cache      *configCache      `yaml:"cache"`
+	Fallback   *configFallback   `yaml:"fallback"`
+	ErrorPages map[int]string    `yaml:"error_pages"`
+	EarlyHints bool              `yaml:"early_hints"`
+	CORS       *configCORS       `yaml:"cors"`
+	Auth       *configAuth       `yaml:"auth"`
+	SignedKeys string            `yaml:"signed_keys"`
+	Bandwidth  *configBandwidth  `yaml:"bandwidth"`
+}
+
+// configCache describes a mount's cache policy. Fingerprinted and HTML take
+// the same defaults as the --cache-fingerprinted and --cache-html flags if
+// not given.
+type configCache struct {
+	Rules         []configCacheRule `yaml:"rules"`
+	Fingerprinted *string           `yaml:"fingerprinted"`
+	HTML          *string           `yaml:"html"`
+}
+
+// configCacheRule is a single cache rule. Match is a glob pattern, or a
+// regular expression if it starts with "~".
+type configCacheRule struct {
+	Match        string `yaml:"match"`
+	CacheControl string `yaml:"cache_control"`
+}
+
+// configFallback describes a mount's fallback file for single page apps.
+type configFallback struct {
+	File            string   `yaml:"file"`
+	ExcludeFiles    bool     `yaml:"exclude_files"`
+	ExcludePrefixes []string `yaml:"exclude_prefixes"`
+}
+
+// configBandwidth describes a mount's bandwidth limits, in bytes per second.
+type configBandwidth struct {
+	PerResponse float64 `yaml:"per_response"`
+	PerMount    float64 `yaml:"per_mount"`
+}
+
+// configCORS describes a mount's CORS policy.
+type configCORS struct {
+	Origins       []string      `yaml:"origins"`
+	Methods       []string      `yaml:"methods"`
+	Headers       []string      `yaml:"headers"`
+	ExposeHeaders []string      `yaml:"expose_headers"`
+	MaxAge        time.Duration `yaml:"max_age"`
+	Credentials   bool          `yaml:"credentials"`
+}
+
+// configAuth describes how requests to a mount are authenticated.
+type configAuth struct {
+	Realm    string `yaml:"realm"`
+	Htpasswd string `yaml:"htpasswd"`
+	Tokens   string `yaml:"tokens"`
+}
+
+// loadConfig reads and validates a configuration file. Relative paths within
+// the file are interpreted relative to the directory containing it.
+func loadConfig(filename string) (*siteConfig, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// decode twice: once strictly into the configuration structure, and
+	// once into a tree of nodes, which lets us report line numbers for
+	// any errors found while validating it
+	var cf configFile
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	switch err := dec.Decode(&cf); err {
+	case nil:
+		// OK
+	case io.EOF:
+		return nil, fmt.Errorf("%s: empty configuration file", filename)
+	default:
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	doc := nodePos{&root}.document()
+
+	cv := configValidator{
+		filename: filename,
+		dir:      filepath.Dir(filename),
+	}
+	cfg := &siteConfig{
+		unknownStatus:  http.StatusMisdirectedRequest,
+		unknownMessage: "unknown host",
+		limits: limits{
+			status:            defaultLimitStatus,
+			message:           defaultLimitMessage,
+			idleTimeout:       defaultIdleTimeout,
+			readHeaderTimeout: defaultReadHeaderTimeout,
+		},
+	}
+
+	if len(cf.Listeners) == 0 {
+		return nil, cv.errorf(doc, "no listeners defined")
+	}
+	var hasTLS bool
+	for i, cl := range cf.Listeners {
+		pos := doc.key("listeners").index(i)
+		if cl.Bind == "" {
+			return nil, cv.errorf(pos, "listener has no bind address")
+		}
+		if cl.Key == "" && cl.Cert != "" {
+			return nil, cv.errorf(pos.key("cert"),
+				"cannot specify cert without key")
+		}
+		mode, err := parseMode(cl.Mode)
+		if err != nil {
+			return nil, cv.errorf(pos.key("mode"), "%v", err)
+		}
+		if (mode != 0 || cl.Owner != "") &&
+			!strings.HasPrefix(cl.Bind, unixPrefix) {
+			return nil, cv.errorf(pos, "mode and owner may only be "+
+				"set for Unix domain sockets")
+		}
+		l := listener{
+			bind:          cl.Bind,
+			keyFile:       cv.path(cl.Key),
+			certFile:      cv.path(cl.Cert),
+			useTLS:        cl.TLS || cl.Key != "",
+			h2c:           cl.H2C,
+			mode:          mode,
+			owner:         cl.Owner,
+			redirect:      cl.RedirectHTTPS,
+			httpsPort:     cl.HTTPSPort,
+			acmeChallenge: cv.path(cl.ACMEChallenge),
+		}
+		if l.certFile == "" {
+			l.certFile = l.keyFile
+		}
+		hasTLSOpts := cl.MinVersion != "" || len(cl.CipherSuites) > 0 ||
+			len(cl.ALPN) > 0 || cl.ClientCA != "" || cl.ClientAuth != ""
+		if hasTLSOpts && !l.useTLS {
+			return nil, cv.errorf(pos, "TLS options given for a "+
+				"listener which does not use TLS")
+		}
+		l.tls, err = parseTLSOptions(cl.MinVersion, cl.CipherSuites,
+			cl.ALPN, cv.path(cl.ClientCA), cl.ClientAuth)
+		if err != nil {
+			return nil, cv.errorf(pos, "%v", err)
+		}
+		switch {
+		case l.redirect && l.useTLS:
+			return nil, cv.errorf(pos, "a listener which redirects "+
+				"to HTTPS cannot itself use TLS")
+		case cl.H2C && (l.useTLS || l.redirect):
+			return nil, cv.errorf(pos.key("h2c"), "h2c cannot be "+
+				"used with TLS or redirect_https")
+		case !l.redirect && (l.httpsPort != 0 || l.acmeChallenge != ""):
+			return nil, cv.errorf(pos, "https_port and "+
+				"acme_challenge require redirect_https")
+		case l.httpsPort < 0 || l.httpsPort > 65535:
+			return nil, cv.errorf(pos.key("https_port"),
+				"invalid port %d", l.httpsPort)
+		case l.redirect && l.httpsPort == 0:
+			l.httpsPort = 443
+		}
+		if l.useTLS {
+			hasTLS = true
+		}
+		cfg.listeners = append(cfg.listeners, l)
+	}
+
+	if h := cf.HSTS; h != nil {
+		cfg.hsts, err = hstsHeader(h.MaxAge, h.IncludeSubDomains,
+			h.Preload)
+		if err != nil {
+			return nil, cv.errorf(doc.key("hsts"), "%v", err)
+		}
+	}
+	if cl := cf.Limits; cl != nil {
+		pos := doc.key("limits")
+		cfg.limits.requestRate = cl.RequestsPerSecond
+		cfg.limits.requestBurst = cl.RequestBurst
+		cfg.limits.byteRate = cl.BytesPerSecond
+		cfg.limits.byteBurst = cl.ByteBurst
+		cfg.limits.maxConns = cl.MaxConnections
+		if cl.IdleTimeout != nil {
+			cfg.limits.idleTimeout = *cl.IdleTimeout
+		}
+		if cl.ReadHeaderTimeout != nil {
+			cfg.limits.readHeaderTimeout = *cl.ReadHeaderTimeout
+		}
+		if cl.Status != 0 {
+			cfg.limits.status = cl.Status
+		}
+		if cl.Message != "" {
+			cfg.limits.message = cl.Message
+		}
+		cfg.limits.trustedProxies, err = parseTrustedProxies(
+			cl.TrustedProxies)
+		if err != nil {
+			return nil, cv.errorf(pos.key("trusted_proxies"),
+				"%v", err)
+		}
+		if err = checkLimits(&cfg.limits); err != nil {
+			return nil, cv.errorf(pos, "%v", err)
+		}
+	}
+	if !hasTLS {
+		for i, l := range cfg.listeners {
+			if l.redirect {
+				return nil, cv.errorf(
+					doc.key("listeners").index(i),
+					"redirect_https requires a TLS listener")
+			}
+		}
+		if cfg.hsts != "" {
+			return nil, cv.errorf(doc.key("hsts"),
+				"hsts requires a TLS listener")
+		}
+	}
+
+	if len(cf.Mounts) == 0 && len(cf.Hosts) == 0 {
+		return nil, cv.errorf(doc, "no mounts or hosts defined")
+	}
+	if len(cf.Mounts) > 0 {
+		vh := &vhost{isDefault: true}
+		vh.mounts, err = cv.mounts(cf.Mounts, doc.key("mounts"))
+		if err != nil {
+			return nil, err
+		}
+		cfg.hosts = append(cfg.hosts, vh)
+	}
+
+	names := make(map[string]bool)
+	for i, ch := range cf.Hosts {
+		pos := doc.key("hosts").index(i)
+		if len(ch.Names) == 0 {
+			return nil, cv.errorf(pos, "host has no names")
+		}
+		if len(ch.Mounts) == 0 {
+			return nil, cv.errorf(pos, "host %q has no mounts",
+				ch.Names[0])
+		}
+		if ch.Default && len(cf.Mounts) > 0 {
+			return nil, cv.errorf(pos.key("default"), "cannot mark "+
+				"a host as default as well as having top level "+
+				"mounts")
+		}
+		if ch.Key == "" && ch.Cert != "" {
+			return nil, cv.errorf(pos.key("cert"),
+				"cannot specify cert without key")
+		}
+
+		vh := &vhost{
+			isDefault: ch.Default,
+			keyFile:   cv.path(ch.Key),
+			certFile:  cv.path(ch.Cert),
+		}
+		if vh.certFile == "" {
+			vh.certFile = vh.keyFile
+		}
+		for j, name := range ch.Names {
+			name = strings.TrimSuffix(strings.ToLower(name), ".")
+			if name == "" {
+				return nil, cv.errorf(pos.key("names").index(j),
+					"empty host name")
+			}
+			if names[name] {
+				return nil, cv.errorf(pos.key("names").index(j),
+					"duplicate host name %q", name)
+			}
+			names[name] = true
+			vh.names = append(vh.names, name)
+		}
+		vh.mounts, err = cv.mounts(ch.Mounts, pos.key("mounts"))
+		if err != nil {
+			return nil, err
+		}
+		cfg.hosts = append(cfg.hosts, vh)
+	}
+
+	var defaults int
+	for _, vh := range cfg.hosts {
+		if vh.isDefault {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return nil, cv.errorf(doc.key("hosts"),
+			"more than one host marked as default")
+	}
+
+	if uh := cf.UnknownHost; uh != nil {
+		pos := doc.key("unknown_host")
+		if uh.Status != 0 {
+			if uh.Status < 400 || uh.Status > 599 {
+				return nil, cv.errorf(pos.key("status"),
+					"status %d is not an error", uh.Status)
+			}
+			cfg.unknownStatus = uh.Status
+		}
+		if uh.Message != "" {
+			cfg.unknownMessage = uh.Message
+		}
+	}
+
+	return cfg, nil
+}
+
+// configValidator converts the decoded configuration file into a siteConfig,
+// reporting errors along with the line number at which they occur.
+type configValidator struct {
+	filename, dir string
+}
+
+func (cv *configValidator) errorf(pos nodePos, format string,
+	args ...interface{},
+) error {
+	return fmt.Errorf("%s:%d: %s", cv.filename, pos.line(),
+		fmt.Sprintf(format, args...))
+}
+
+// path returns the given path relative to the configuration file's directory.
+func (cv *configValidator) path(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(cv.dir, p)
+}
+
+// mounts validates a list of mounts, each of which must have a distinct
+// prefix.
+func (cv *configValidator) mounts(cms []configMount, pos nodePos,
+) ([]*mount, error) {
+	var mounts []*mount
+	prefixes := make(map[string]bool)
+	for i := range cms {
+		mountPos := pos.index(i)
+		m, err := cv.mount(&cms[i], mountPos)
+		if err != nil {
+			return nil, err
+		}
+		if prefixes[m.prefix] {
+			return nil, cv.errorf(mountPos.key("prefix"),
+				"duplicate prefix %q", m.prefix)
+		}
+		prefixes[m.prefix] = true
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// mount validates the configuration for a single mount.
+func (cv *configValidator) mount(cm *configMount, pos nodePos,
+) (*mount, error) {
+	if cm.Prefix == "" {
+		return nil, cv.errorf(pos, "mount has no prefix")
+	}
+	prefix := path.Clean(cm.Prefix)
+	if prefix[0] != '/' {
+		return nil, cv.errorf(pos.key("prefix"),
+			"prefix must start with '/'")
+	}
+	if len(cm.Packs) == 0 {
+		return nil, cv.errorf(pos, "mount %q has no packs", prefix)
+	}
+
+	m := &mount{
+		prefix:     prefix,
+		headers:    make(http.Header),
+		headerFile: cv.path(cm.HeaderFile),
+		indexFile:  cm.IndexFile,
+		errorPages: make(map[int]string),
+		earlyHints: cm.EarlyHints,
+		signedKeys: cv.path(cm.SignedKeys),
+	}
+	for _, packfile := range cm.Packs {
+		m.packs = append(m.packs, cv.path(packfile))
+	}
+	for name, value := range cm.Headers {
+		m.headers.Add(name, value)
+	}
+
+	//  NB: https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cache-Control
+	switch {
+	case cm.Expiry < 0:
+		return nil, cv.errorf(pos.key("expiry"),
+			"expiry must not be negative")
+	case cm.Expiry == 0:
+		m.cacheControl = "no-cache"
+	default:
+		m.cacheControl = fmt.Sprintf("public, max-age=%d",
+			cm.Expiry/time.Second)
+	}
+
+	m.cachePolicy = htpack.DefaultCachePolicy()
+	if cc := cm.Cache; cc != nil {
+		if cc.Fingerprinted != nil {
+			m.cachePolicy.Fingerprinted = *cc.Fingerprinted
+		}
+		if cc.HTML != nil {
+			m.cachePolicy.HTML = *cc.HTML
+		}
+		for i, cr := range cc.Rules {
+			rulePos := pos.key("cache").key("rules").index(i)
+			rule, err := cacheRule(cr.Match, cr.CacheControl)
+			if err != nil {
+				return nil, cv.errorf(rulePos, "%v", err)
+			}
+			m.cachePolicy.Rules = append(m.cachePolicy.Rules, rule)
+		}
+	}
+
+	if fb := cm.Fallback; fb != nil {
+		if fb.File == "" {
+			return nil, cv.errorf(pos.key("fallback"),
+				"fallback has no file")
+		}
+		m.fallback = fb.File
+		m.fallbackOpts = htpack.FallbackOptions{
+			ExcludeFiles:    fb.ExcludeFiles,
+			ExcludePrefixes: fb.ExcludePrefixes,
+		}
+	}
+
+	for status, filename := range cm.ErrorPages {
+		if status < 400 || status > 599 {
+			return nil, cv.errorf(pos.key("error_pages"),
+				"error page status %d is not an error", status)
+		}
+		if filename == "" {
+			return nil, cv.errorf(pos.key("error_pages"),
+				"error page for status %d has no file", status)
+		}
+		m.errorPages[status] = filename
+	}
+
+	if bw := cm.Bandwidth; bw != nil {
+		if bw.PerResponse < 0 || bw.PerMount < 0 {
+			return nil, cv.errorf(pos.key("bandwidth"),
+				"bandwidth must not be negative")
+		}
+		m.responseBandwidth = bw.PerResponse
+		m.mountBandwidth = bw.PerMount
+	}
+
+	if cc := cm.CORS; cc != nil {
+		policy := &htpack.CORSPolicy{
+			AllowOrigins:     cc.Origins,
+			AllowMethods:     cc.Methods,
+			AllowHeaders:     cc.Headers,
+			ExposeHeaders:    cc.ExposeHeaders,
+			MaxAge:           cc.MaxAge,
+			AllowCredentials: cc.Credentials,
+		}
+		if err := checkCORSPolicy(policy); err != nil {
+			return nil, cv.errorf(pos.key("cors"), "%v", err)
+		}
+		m.cors = policy
+	}
+
+	if ca := cm.Auth; ca != nil {
+		if ca.Htpasswd == "" && ca.Tokens == "" {
+			return nil, cv.errorf(pos.key("auth"),
+				"auth needs an htpasswd or tokens file")
+		}
+		m.auth = &authConfig{
+			realm:        ca.Realm,
+			htpasswdFile: cv.path(ca.Htpasswd),
+			tokenFile:    cv.path(ca.Tokens),
+		}
+	}
+
+	return m, nil
+}
+
+// cacheRule parses a cache rule's pattern, which is a glob or (if prefixed
+// with "~") a regular expression.
+func cacheRule(pattern, cacheControl string) (htpack.CacheRule, error) {
+	rule := htpack.CacheRule{
+		Pattern:      pattern,
+		CacheControl: cacheControl,
+	}
+	if pattern == "" {
+		return rule, errors.New("cache rule has no pattern")
+	}
+
+	var err error
+	if pattern[0] == '~' {
+		rule.Regexp, err = regexp.Compile(pattern[1:])
+	} else {
+		_, err = path.Match(pattern, "")
+	}
+	if err != nil {
+		return rule, fmt.Errorf("cache rule %q: %v", pattern, err)
+	}
+	return rule, nil
+}
+
+// checkCORSPolicy validates a CORS policy, normalising its method names.
+func checkCORSPolicy(policy *htpack.CORSPolicy) error {
+	if len(policy.AllowOrigins) == 0 {
+		return errors.New("CORS policy has no origins")
+	}
+	for _, origin := range policy.AllowOrigins {
+		if origin == "*" {
+			if policy.AllowCredentials {
+				return errors.New("CORS origin * cannot be used " +
+					"with credentials; list the origins instead")
+			}
+			continue
+		}
+		if !strings.HasPrefix(origin, "http://") &&
+			!strings.HasPrefix(origin, "https://") {
+			return fmt.Errorf("CORS origin %q must start with "+
+				"http:// or https:// (or be *)", origin)
+		}
+		if _, err := path.Match(origin, ""); err != nil {
+			return fmt.Errorf("CORS origin %q: %v", origin, err)
+		}
+		if strings.Count(origin, "/") != 2 {
+			return fmt.Errorf("CORS origin %q must not have a path",
+				origin)
+		}
+	}
+	for i, method := range policy.AllowMethods {
+		policy.AllowMethods[i] = strings.ToUpper(method)
+	}
+	if policy.MaxAge < 0 {
+		return errors.New("CORS max-age must not be negative")
+	}
+	return nil
+}
+
+// checkLimits validates the request and bandwidth limits.
+func checkLimits(l *limits) error {
+	switch {
+	case l.requestRate < 0 || l.requestBurst < 0:
+		return errors.New("request rate and burst must not be negative")
+	case l.byteRate < 0 || l.byteBurst < 0:
+		return errors.New("bandwidth and burst must not be negative")
+	case l.requestBurst > 0 && l.requestRate == 0:
+		return errors.New("request burst requires a request rate")
+	case l.byteBurst > 0 && l.byteRate == 0:
+		return errors.New("bandwidth burst requires a bandwidth limit")
+	case l.maxConns < 0:
+		return errors.New("max connections must not be negative")
+	case l.idleTimeout < 0 || l.readHeaderTimeout < 0:
+		return errors.New("timeouts must not be negative")
+	case l.status < 400 || l.status > 599:
+		return fmt.Errorf("invalid rate limit status %d", l.status)
+	}
+	return nil
+}
+
+// nodePos is a position within a parsed YAML document, used to find the line
+// number to report in errors. If the requested element does not exist, the
+// position of its closest parent is used instead.
+type nodePos struct {
+	node *yaml.Node
+}
+
+// document returns the position of the document's top level content.
+func (p nodePos) document() nodePos {
+	if p.node.Kind == yaml.DocumentNode && len(p.node.Content) > 0 {
+		return nodePos{p.node.Content[0]}
+	}
+	return p
+}
+
+// key returns the position of the value of the named key in a mapping.
+func (p nodePos) key(name string) nodePos {
+	if p.node.Kind != yaml.MappingNode {
+		return p
+	}
+	for i := 0; i+1 < len(p.node.Content); i += 2 {
+		if p.node.Content[i].Value == name {
+			return nodePos{p.node.Content[i+1]}
+		}
+	}
+	return p
+}
+
+// index returns the position of an element of a sequence.
+func (p nodePos) index(i int) nodePos {
+	if p.node.Kind != yaml.SequenceNode || i >= len(p.node.Content) {
+		return p
+	}
+	return nodePos{p.node.Content[i]}
+}
+
+func (p nodePos) line() int {
+	return p.node.Line
+}
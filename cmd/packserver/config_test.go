@@ -0,0 +1,300 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/htpack"
+)
+
+// writeConfig writes a configuration file to a temporary directory, returning
+// its path.
+func writeConfig(t *testing.T, config string) string {
+	filename := filepath.Join(t.TempDir(), "packserver.yaml")
+	if err := os.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadConfig(t *testing.T) {
+	filename := writeConfig(t, `
+listeners:
+  - bind: ":8080"
+  - bind: unix:/run/packserver.sock
+    mode: "0660"
+mounts:
+  - prefix: /static/
+    packs: [static.htpack, /srv/base.htpack]
+    expiry: 1h
+    headers:
+      X-Frame-Options: DENY
+    error_pages:
+      404: /404.html
+hosts:
+  - names: [Example.COM., "*.example.org"]
+    key: site.key
+    mounts:
+      - prefix: /
+        packs: [site.htpack]
+        fallback:
+          file: /index.html
+          exclude_prefixes: [/api]
+unknown_host:
+  status: 404
+  message: no such site
+`)
+	dir := filepath.Dir(filename)
+	noTLS, err := parseTLSOptions("", nil, nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &siteConfig{
+		listeners: []listener{
+			{bind: ":8080", tls: noTLS},
+			{bind: "unix:/run/packserver.sock", mode: 0660,
+				tls: noTLS},
+		},
+		hosts: []*vhost{
+			{isDefault: true, mounts: []*mount{{
+				prefix: "/static",
+				packs: []string{
+					filepath.Join(dir, "static.htpack"),
+					"/srv/base.htpack",
+				},
+				headers: http.Header{
+					"X-Frame-Options": {"DENY"},
+				},
+				cacheControl: "public, max-age=3600",
+				cachePolicy:  htpack.DefaultCachePolicy(),
+				errorPages:   map[int]string{404: "/404.html"},
+			}}},
+			{
+				names:    []string{"example.com", "*.example.org"},
+				keyFile:  filepath.Join(dir, "site.key"),
+				certFile: filepath.Join(dir, "site.key"),
+				mounts: []*mount{{
+					prefix: "/",
+					packs: []string{
+						filepath.Join(dir, "site.htpack"),
+					},
+					headers:      http.Header{},
+					cacheControl: "no-cache",
+					cachePolicy:  htpack.DefaultCachePolicy(),
+					fallback:     "/index.html",
+					fallbackOpts: htpack.FallbackOptions{
+						ExcludePrefixes: []string{"/api"},
+					},
+					errorPages: map[int]string{},
+				}},
+			},
+		},
+		unknownStatus:  http.StatusNotFound,
+		unknownMessage: "no such site",
+		limits: limits{
+			status:            defaultLimitStatus,
+			message:           defaultLimitMessage,
+			idleTimeout:       defaultIdleTimeout,
+			readHeaderTimeout: defaultReadHeaderTimeout,
+		},
+	}
+
+	got, err := loadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+		for i := range got.hosts {
+			t.Logf("host %d: %+v", i, got.hosts[i])
+			for _, m := range got.hosts[i].mounts {
+				t.Logf("  mount %+v", m)
+			}
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	const mounts = `
+mounts:
+  - prefix: /
+    packs: [site.htpack]
+`
+	tests := []struct {
+		name   string
+		config string
+		want   string // expected in the error, after the filename
+	}{
+		{"empty", "", "empty configuration file"},
+		{"unknown top level key", `
+listeners:
+  - bind: ":8080"
+colour: blue
+` + mounts, "line 4: field colour not found"},
+		{"unknown mount key", `
+listeners:
+  - bind: ":8080"
+mounts:
+  - prefix: /
+    packs: [site.htpack]
+    pack_file: other.htpack
+`, "line 7: field pack_file not found"},
+		{"no listeners", mounts, ":2: no listeners defined"},
+		{"listener without bind", `
+listeners:
+  - bind: ":8080"
+  - key: site.key
+` + mounts, ":4: listener has no bind address"},
+		{"bad mode", `
+listeners:
+  - bind: unix:/run/packserver.sock
+    mode: rw-rw----
+` + mounts, ":4: invalid mode"},
+		{"mode for TCP", `
+listeners:
+  - bind: ":8080"
+    mode: "0660"
+` + mounts, ":3: mode and owner may only be set"},
+		{"bad TLS version", `
+listeners:
+  - bind: ":8443"
+    key: site.key
+    tls_min_version: "1.4"
+` + mounts, `:3: TLS version "1.4"`},
+		{"h2c with TLS", `
+listeners:
+  - bind: ":8443"
+    key: site.key
+    h2c: true
+` + mounts, ":5: h2c cannot be used"},
+		{"mount without packs", `
+listeners:
+  - bind: ":8080"
+mounts:
+  - prefix: /
+  - prefix: /static
+`, `:5: mount "/" has no packs`},
+		{"bad prefix", `
+listeners:
+  - bind: ":8080"
+mounts:
+  - prefix: static
+    packs: [static.htpack]
+`, ":5: prefix must start with '/'"},
+		{"negative expiry", `
+listeners:
+  - bind: ":8080"
+mounts:
+  - prefix: /
+    packs: [site.htpack]
+    expiry: -1s
+`, ":7: expiry must not be negative"},
+		{"duplicate prefix", `
+listeners:
+  - bind: ":8080"
+mounts:
+  - prefix: /static
+    packs: [a.htpack]
+  - prefix: /static/
+    packs: [b.htpack]
+`, `:7: duplicate prefix "/static"`},
+		{"duplicate host", `
+listeners:
+  - bind: ":8080"
+hosts:
+  - names: [example.com]
+    mounts:
+      - prefix: /
+        packs: [a.htpack]
+  - names:
+      - www.example.com
+      - Example.com.
+    mounts:
+      - prefix: /
+        packs: [b.htpack]
+`, `:11: duplicate host name "example.com"`},
+		{"two defaults", `
+listeners:
+  - bind: ":8080"
+hosts:
+  - names: [a.example.com]
+    default: true
+    mounts: [{prefix: /, packs: [a.htpack]}]
+  - names: [b.example.com]
+    default: true
+    mounts: [{prefix: /, packs: [b.htpack]}]
+`, ":5: more than one host marked as default"},
+		{"bad unknown host status", `
+listeners:
+  - bind: ":8080"
+unknown_host:
+  status: 200
+` + mounts, ":5: status 200 is not an error"},
+	}
+	for _, tt := range tests {
+		filename := writeConfig(t, tt.config)
+		_, err := loadConfig(filename)
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		if msg := err.Error(); !strings.HasPrefix(msg, filename) ||
+			!strings.Contains(msg, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, msg, tt.want)
+		}
+	}
+}
+
+func TestCheckCORSPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy htpack.CORSPolicy
+		ok     bool
+	}{
+		{"any", htpack.CORSPolicy{AllowOrigins: []string{"*"}}, true},
+		{"listed", htpack.CORSPolicy{
+			AllowOrigins:     []string{"https://*.example.com"},
+			AllowCredentials: true,
+		}, true},
+		{"any with credentials", htpack.CORSPolicy{
+			AllowOrigins:     []string{"https://a.example", "*"},
+			AllowCredentials: true,
+		}, false},
+		{"no origins", htpack.CORSPolicy{}, false},
+		{"no scheme", htpack.CORSPolicy{
+			AllowOrigins: []string{"example.com"},
+		}, false},
+		{"path", htpack.CORSPolicy{
+			AllowOrigins: []string{"https://example.com/"},
+		}, false},
+		{"bad pattern", htpack.CORSPolicy{
+			AllowOrigins: []string{"https://[.example.com"},
+		}, false},
+		{"negative max-age", htpack.CORSPolicy{
+			AllowOrigins: []string{"*"},
+			MaxAge:       -1,
+		}, false},
+	}
+	for _, tt := range tests {
+		err := checkCORSPolicy(&tt.policy)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+
+	policy := htpack.CORSPolicy{
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{"get", "Put"},
+	}
+	if err := checkCORSPolicy(&policy); err != nil {
+		t.Fatal(err)
+	}
+	if m := policy.AllowMethods; m[0] != "GET" || m[1] != "PUT" {
+		t.Errorf("methods not normalised: %v", m)
+	}
+}
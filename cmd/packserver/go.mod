@@ -1,10 +1,21 @@
 module github.com/lwithers/htpack/cmd/packserver
 
-go 1.12
+go 1.18
 
 require (
-	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/lwithers/htpack v1.1.4
 	github.com/spf13/cobra v0.0.3
+	golang.org/x/crypto v0.21.0
+	golang.org/x/net v0.23.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/gogo/protobuf v1.2.1 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.3 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
+
+replace github.com/lwithers/htpack => ../..
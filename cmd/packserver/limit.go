@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lwithers/htpack"
+)
+
+// limits describes the per-client request and bandwidth limits, and the cap on
+// concurrent connections. A zero rate or count means unlimited.
+type limits struct {
+	requestRate  float64
+	requestBurst int
+	byteRate     float64
+	byteBurst    int
+
+	// trustedProxies are the addresses of proxies whose X-Forwarded-For
+	// header is believed when identifying the client. Connections over a
+	// Unix domain socket are always trusted.
+	trustedProxies []*net.IPNet
+
+	// maxConns caps the number of connections open at once, across all
+	// listeners. Changes require a restart.
+	maxConns int
+
+	// idleTimeout and readHeaderTimeout are applied to every connection
+	// if maxConns is set, so that idle or slow clients cannot hold on to
+	// all of the connections. Changes require a restart.
+	idleTimeout       time.Duration
+	readHeaderTimeout time.Duration
+
+	// status and message make up the response to a client which exceeds
+	// its request rate.
+	status  int
+	message string
+}
+
+const (
+	defaultLimitStatus  = http.StatusTooManyRequests
+	defaultLimitMessage = "too many requests"
+
+	defaultIdleTimeout       = time.Minute
+	defaultReadHeaderTimeout = 10 * time.Second
+)
+
+// parseTrustedProxies parses a list of IP addresses and CIDR ranges.
+func parseTrustedProxies(addrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q",
+					addr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", addr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// clientLimiter applies the request and bandwidth limits to each client,
+// identified by its IP address. Its state is discarded when the site is
+// reloaded.
+type clientLimiter struct {
+	limits *limits
+	logger func(*htpack.Event)
+
+	// idle is how long a client's buckets take to refill completely, after
+	// which its state is no longer needed.
+	idle time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*clientState
+	lastSweep time.Time
+}
+
+// clientState holds the token buckets of one client.
+type clientState struct {
+	requests *htpack.RateLimiter
+	bytes    *htpack.RateLimiter
+	lastSeen time.Time
+	active   int
+}
+
+// byteLimiterKey is the context key under which the bandwidth limiter of a
+// request's client is stored.
+type byteLimiterKey struct{}
+
+// newClientLimiter returns a limiter applying the given limits, or nil if
+// there are no per-client limits. Rejected requests are passed to logger,
+// which may be nil.
+func newClientLimiter(l *limits, logger func(*htpack.Event),
+) *clientLimiter {
+	if l.requestRate == 0 && l.byteRate == 0 {
+		return nil
+	}
+	cl := &clientLimiter{
+		limits:    l,
+		logger:    logger,
+		idle:      time.Minute,
+		clients:   make(map[string]*clientState),
+		lastSweep: time.Now(),
+	}
+	if l.requestRate > 0 {
+		cl.idle = maxDuration(cl.idle, refillTime(l.requestRate,
+			l.requestBurst))
+	}
+	if l.byteRate > 0 {
+		cl.idle = maxDuration(cl.idle, refillTime(l.byteRate,
+			l.byteBurst))
+	}
+	return cl
+}
+
+// refillTime returns how long a bucket created by htpack.NewLimiter takes to
+// fill up from empty.
+func refillTime(rate float64, burst int) time.Duration {
+	return time.Duration(math.Max(float64(burst), rate) / rate *
+		float64(time.Second))
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// serve passes the request on to next unless the client has exceeded its
+// request rate, in which case it receives the configured response. The
+// client's bandwidth limiter, if any, is stored in the request's context (see
+// requestByteLimiter).
+func (cl *clientLimiter) serve(w http.ResponseWriter, r *http.Request,
+	next http.Handler,
+) {
+	if cl == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	cs := cl.acquire(cl.clientIP(r))
+	defer cl.release(cs)
+
+	if cs.requests != nil {
+		if ok, wait := cs.requests.Allow(1); !ok {
+			cl.reject(w, r, wait)
+			return
+		}
+	}
+	if cs.bytes != nil {
+		r = r.WithContext(context.WithValue(r.Context(),
+			byteLimiterKey{}, cs.bytes))
+	}
+	next.ServeHTTP(w, r)
+}
+
+// reject sends the response to a request which exceeds the client's rate,
+// telling it to retry after the given delay.
+func (cl *clientLimiter) reject(w http.ResponseWriter, r *http.Request,
+	wait time.Duration,
+) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	htpack.LogError(cl.logger, w, r, cl.limits.status, cl.limits.message)
+}
+
+// acquire returns the state of a client, creating it if need be, and marks it
+// as active so that it is not discarded.
+func (cl *clientLimiter) acquire(ip string) *clientState {
+	now := time.Now()
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if now.Sub(cl.lastSweep) > cl.idle {
+		for key, cs := range cl.clients {
+			if cs.active == 0 && now.Sub(cs.lastSeen) > cl.idle {
+				delete(cl.clients, key)
+			}
+		}
+		cl.lastSweep = now
+	}
+
+	cs := cl.clients[ip]
+	if cs == nil {
+		cs = new(clientState)
+		if cl.limits.requestRate > 0 {
+			cs.requests = htpack.NewLimiter(cl.limits.requestRate,
+				cl.limits.requestBurst)
+		}
+		if cl.limits.byteRate > 0 {
+			cs.bytes = htpack.NewLimiter(cl.limits.byteRate,
+				cl.limits.byteBurst)
+		}
+		cl.clients[ip] = cs
+	}
+	cs.active++
+	cs.lastSeen = now
+	return cs
+}
+
+// release marks the end of a request from a client.
+func (cl *clientLimiter) release(cs *clientState) {
+	cl.mu.Lock()
+	cs.active--
+	cs.lastSeen = time.Now()
+	cl.mu.Unlock()
+}
+
+// clientIP returns the address of the client making a request. If the request
+// arrived from a trusted proxy, the X-Forwarded-For header is followed back
+// (from right to left) to the first address which is not itself trusted.
+func (cl *clientLimiter) clientIP(r *http.Request) string {
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	if ip := net.ParseIP(client); ip != nil && !cl.trusted(ip) {
+		return client
+	}
+
+	var forwarded []string
+	for _, hdr := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(hdr, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !cl.trusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// trusted returns true if ip is the address of a trusted proxy.
+func (cl *clientLimiter) trusted(ip net.IP) bool {
+	for _, ipNet := range cl.limits.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestByteLimiter returns the bandwidth limiter stored in a request's
+// context by clientLimiter.serve, if any. It is passed to
+// htpack.Handler.SetLimiter.
+func requestByteLimiter(r *http.Request) htpack.Limiter {
+	rl, ok := r.Context().Value(byteLimiterKey{}).(*htpack.RateLimiter)
+	if ok {
+		return rl
+	}
+	return nil
+}
+
+// connLimit is a semaphore capping the number of connections open at once
+// across several listeners.
+type connLimit chan struct{}
+
+// listener wraps ln so that it only accepts a connection while there is a
+// free slot, which the connection holds until it is closed.
+func (cl connLimit) listener(ln net.Listener) net.Listener {
+	return &limitListener{
+		Listener: ln,
+		slots:    cl,
+		done:     make(chan struct{}),
+	}
+}
+
+// limitListener is a listener whose connections are limited by a connLimit.
+type limitListener struct {
+	net.Listener
+	slots     connLimit
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (ll *limitListener) Accept() (net.Conn, error) {
+	select {
+	case ll.slots <- struct{}{}:
+	case <-ll.done:
+		return nil, net.ErrClosed
+	}
+
+	conn, err := ll.Listener.Accept()
+	if err != nil {
+		<-ll.slots
+		return nil, err
+	}
+	lc := &limitConn{Conn: conn, slots: ll.slots}
+	if sc, ok := conn.(syscall.Conn); ok {
+		// keep the file descriptor reachable, for sendfile(2)
+		return &limitSyscallConn{limitConn: lc, sc: sc}, nil
+	}
+	return lc, nil
+}
+
+func (ll *limitListener) Close() error {
+	ll.closeOnce.Do(func() {
+		close(ll.done)
+	})
+	return ll.Listener.Close()
+}
+
+// limitConn is a connection which holds a slot in a connLimit until it is
+// closed.
+type limitConn struct {
+	net.Conn
+	slots       connLimit
+	releaseOnce sync.Once
+}
+
+func (lc *limitConn) Close() error {
+	err := lc.Conn.Close()
+	lc.releaseOnce.Do(func() {
+		<-lc.slots
+	})
+	return err
+}
+
+// limitSyscallConn is a limitConn whose underlying connection implements
+// syscall.Conn.
+type limitSyscallConn struct {
+	*limitConn
+	sc syscall.Conn
+}
+
+func (lc *limitSyscallConn) SyscallConn() (syscall.RawConn, error) {
+	return lc.sc.SyscallConn()
+}
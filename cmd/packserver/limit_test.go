@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		addrs []string
+		want  []string
+		ok    bool
+	}{
+		{nil, nil, true},
+		{[]string{"127.0.0.1", "10.0.0.0/8"},
+			[]string{"127.0.0.1/32", "10.0.0.0/8"}, true},
+		{[]string{"::1", "fd00::/8"}, []string{"::1/128", "fd00::/8"},
+			true},
+		{[]string{"10.1.2.3/8"}, []string{"10.0.0.0/8"}, true},
+		{[]string{"localhost"}, nil, false},
+		{[]string{"10.0.0.0/33"}, nil, false},
+	}
+	for _, tt := range tests {
+		nets, err := parseTrustedProxies(tt.addrs)
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: got error %v, want ok=%v", tt.addrs, err,
+				tt.ok)
+			continue
+		}
+		var got []string
+		for _, n := range nets {
+			got = append(got, n.String())
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%v: got %v, want %v", tt.addrs, got, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.1",
+		"192.168.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := &clientLimiter{limits: &limits{trustedProxies: proxies}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted forwarder", "203.0.113.5:1234",
+			[]string{"198.51.100.7"}, "203.0.113.5"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.7"},
+			"198.51.100.7"},
+		{"proxy chain", "10.0.0.1:1234",
+			[]string{"1.2.3.4, 198.51.100.7, 192.168.1.1"},
+			"198.51.100.7"},
+		{"several headers", "10.0.0.1:1234",
+			[]string{"1.2.3.4", "198.51.100.7"}, "198.51.100.7"},
+		{"all trusted", "10.0.0.1:1234", []string{"192.168.1.1"},
+			"192.168.1.1"},
+		{"garbage", "10.0.0.1:1234",
+			[]string{"198.51.100.7, bogus"}, "10.0.0.1"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"unix socket", "@", []string{"198.51.100.7"},
+			"198.51.100.7"},
+		{"ipv6", "[2001:db8::1]:1234", nil, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, hdr := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", hdr)
+		}
+		if got := cl.clientIP(r); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestClientLimiterReject checks that requests over the rate limit are
+// refused, and are counted in the metrics.
+func TestClientLimiterReject(t *testing.T) {
+	stats := newMetrics()
+	cl := newClientLimiter(&limits{
+		requestRate:  1,
+		requestBurst: 2,
+		status:       http.StatusServiceUnavailable,
+		message:      "slow down",
+	}, eventLogger(limitedLabel, nil, stats))
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestByteLimiter(r) != nil {
+			t.Error("bandwidth limiter set without a limit")
+		}
+	})
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		cl.serve(w, r, ok)
+		return w
+	}
+
+	for i, want := range []int{200, 200, 503} {
+		if w := serve("203.0.113.5:1"); w.Code != want {
+			t.Errorf("request %d: status %d, want %d", i, w.Code,
+				want)
+		}
+	}
+	w := serve("203.0.113.5:2")
+	if ra := w.Header().Get("Retry-After"); ra != "1" {
+		t.Errorf("Retry-After %q", ra)
+	}
+	if body := w.Body.String(); body != "slow down\n" {
+		t.Errorf("body %q", body)
+	}
+	if w := serve("203.0.113.6:1"); w.Code != http.StatusOK {
+		t.Errorf("other client: status %d", w.Code)
+	}
+
+	m := httptest.NewRecorder()
+	stats.ServeHTTP(m, httptest.NewRequest("GET", "/metrics", nil))
+	want := `packserver_requests_total{prefix="",status="503",` +
+		`encoding="identity"} 2`
+	if !strings.Contains(m.Body.String(), want+"\n") {
+		t.Errorf("metrics missing %s:\n%s", want, m.Body.String())
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	valid := limits{status: defaultLimitStatus}
+	tests := []struct {
+		name   string
+		modify func(*limits)
+		ok     bool
+	}{
+		{"defaults", func(*limits) {}, true},
+		{"burst without rate", func(l *limits) {
+			l.requestBurst = 10
+		}, false},
+		{"negative bandwidth", func(l *limits) {
+			l.byteRate = -1
+		}, false},
+		{"negative timeout", func(l *limits) {
+			l.idleTimeout = -time.Second
+		}, false},
+		{"bad status", func(l *limits) {
+			l.status = 200
+		}, false},
+	}
+	for _, tt := range tests {
+		l := valid
+		tt.modify(&l)
+		if err := checkLimits(&l); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	unixPrefix    = "unix:"
+	systemdPrefix = "systemd"
+)
+
+// listen opens the socket for a listener. The bind address may be a TCP
+// address, "unix:/path" for a Unix domain socket, or "systemd" or
+// "systemd:name" for a socket passed in by systemd (see sd_listen_fds(3)).
+func listen(l listener, inherited *inheritedSockets) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(l.bind, unixPrefix):
+		return listenUnix(l)
+	case l.bind == systemdPrefix:
+		return inherited.listener("")
+	case strings.HasPrefix(l.bind, systemdPrefix+":"):
+		return inherited.listener(l.bind[len(systemdPrefix)+1:])
+	}
+	return net.Listen("tcp", l.bind)
+}
+
+// listenUnix creates a Unix domain socket, setting its mode and owner if
+// specified. A stale socket left behind by a previous process is removed.
+func listenUnix(l listener) (net.Listener, error) {
+	sockPath := l.bind[len(unixPrefix):]
+	if sockPath == "" {
+		return nil, fmt.Errorf("%s: missing socket path", l.bind)
+	}
+
+	if fi, err := os.Lstat(sockPath); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s: exists and is not a socket",
+				sockPath)
+		}
+		if conn, err := net.Dial("unix", sockPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: socket is in use", sockPath)
+		}
+		if err := os.Remove(sockPath); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, err
+	}
+	if l.mode != 0 {
+		if err := os.Chmod(sockPath, l.mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	if l.owner != "" {
+		uid, gid, err := lookupOwner(l.owner)
+		if err == nil {
+			err = os.Lchown(sockPath, uid, gid)
+		}
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// lookupOwner converts an owner in the form "user[:group]" (or ":group") to
+// numeric IDs, which may be given directly. An ID of -1 means unchanged.
+func lookupOwner(owner string) (uid, gid int, err error) {
+	userName, groupName := owner, ""
+	if pos := strings.IndexRune(owner, ':'); pos != -1 {
+		userName, groupName = owner[:pos], owner[pos+1:]
+	}
+
+	uid, gid = -1, -1
+	if userName != "" {
+		if uid, err = strconv.Atoi(userName); err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return 0, 0, err
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if groupName != "" {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return 0, 0, err
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// parseMode parses an octal file mode such as "0660".
+func parseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q (expecting octal "+
+			"permissions, e.g. 0660)", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// inheritedSockets are the listening sockets passed in by systemd socket
+// activation, using the LISTEN_FDS protocol (see sd_listen_fds(3)).
+type inheritedSockets struct {
+	files []*os.File
+	names []string
+}
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// inheritSockets returns the sockets passed in by systemd, if any. The
+// environment variables describing them are cleared, so that they are not
+// passed on to child processes.
+func inheritSockets() *inheritedSockets {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	s := new(inheritedSockets)
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return s
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return s
+	}
+
+	var names []string
+	if fdnames := os.Getenv("LISTEN_FDNAMES"); fdnames != "" {
+		names = strings.Split(fdnames, ":")
+	}
+	for i := 0; i < nfds; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) {
+			name = names[i]
+		}
+		s.files = append(s.files, os.NewFile(uintptr(fd), name))
+		s.names = append(s.names, name)
+	}
+	return s
+}
+
+// listener returns the inherited socket with the given name (as set by
+// FileDescriptorName= in the systemd socket unit), or the first socket if the
+// name is empty. Each socket may only be used once.
+func (s *inheritedSockets) listener(name string) (net.Listener, error) {
+	if len(s.files) == 0 {
+		return nil, errors.New("no sockets passed by systemd " +
+			"(LISTEN_FDS not set)")
+	}
+
+	for i, f := range s.files {
+		if f == nil || (name != "" && s.names[i] != name) {
+			continue
+		}
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %s: %v",
+				s.names[i], err)
+		}
+		f.Close()
+		s.files[i] = nil
+		return ln, nil
+	}
+
+	if name == "" {
+		return nil, errors.New("all sockets passed by systemd are " +
+			"already in use")
+	}
+	return nil, fmt.Errorf("no unused socket named %q passed by systemd",
+		name)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		s    string
+		want os.FileMode
+		ok   bool
+	}{
+		{"", 0, true},
+		{"0660", 0660, true},
+		{"660", 0660, true},
+		{"0777", 0777, true},
+		{"1777", 0, false},
+		{"0888", 0, false},
+		{"rw-rw----", 0, false},
+		{"-1", 0, false},
+		{"0x1b6", 0, false},
+	}
+	for _, tt := range tests {
+		mode, err := parseMode(tt.s)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: got error %v, want ok=%v", tt.s, err, tt.ok)
+			continue
+		}
+		if mode != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.s, mode, tt.want)
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	sockPath := filepath.Join(dir, "packserver.sock")
+	l := listener{bind: unixPrefix + sockPath}
+
+	for _, mode := range []os.FileMode{0600, 0666} {
+		l.mode = mode
+		ln, err := listen(l, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Lstat(sockPath)
+		switch {
+		case err != nil:
+			t.Error(err)
+		case fi.Mode()&os.ModeSocket == 0:
+			t.Errorf("%v: not a socket", fi.Mode())
+		case fi.Mode().Perm() != mode:
+			t.Errorf("got mode %v, want %v", fi.Mode().Perm(), mode)
+		}
+
+		if _, err := listen(l, nil); err == nil {
+			t.Error("listened on a socket already in use")
+		}
+
+		// leave a stale socket behind, to be replaced by the next
+		// listener
+		ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		ln.Close()
+	}
+
+	notSocket := filepath.Join(dir, "file")
+	if err := os.WriteFile(notSocket, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, bind := range []string{unixPrefix, unixPrefix + notSocket} {
+		if _, err := listen(listener{bind: bind}, nil); err == nil {
+			t.Errorf("%s: no error", bind)
+		}
+	}
+	if _, err := os.Stat(notSocket); err != nil {
+		t.Errorf("file removed: %v", err)
+	}
+}
+
+func TestInheritSockets(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		name, pid, fds string
+	}{
+		{"unset", "", ""},
+		{"other process", strconv.Itoa(os.Getpid() + 1), "1"},
+		{"zero count", pid, "0"},
+		{"bad count", pid, "x"},
+	}
+	for _, tt := range tests {
+		t.Setenv("LISTEN_PID", tt.pid)
+		t.Setenv("LISTEN_FDS", tt.fds)
+		t.Setenv("LISTEN_FDNAMES", "http")
+
+		s := inheritSockets()
+		if len(s.files) != 0 {
+			t.Errorf("%s: got %d sockets", tt.name, len(s.files))
+		}
+		for _, env := range []string{"LISTEN_PID", "LISTEN_FDS",
+			"LISTEN_FDNAMES"} {
+			if v, ok := os.LookupEnv(env); ok {
+				t.Errorf("%s: %s=%q not cleared", tt.name, env, v)
+			}
+		}
+		if _, err := s.listener(""); err == nil {
+			t.Errorf("%s: listener returned without sockets",
+				tt.name)
+		}
+	}
+}
@@ -5,15 +5,24 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/lwithers/htpack"
 	"github.com/spf13/cobra"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 var rootCmd = &cobra.Command{
@@ -22,21 +31,199 @@ var rootCmd = &cobra.Command{
 	Long: `packserver can efficiently serve a pre-packed file tree over HTTP(S).
 The files must first have been prepared using the ‘htpacker’ tool.
 
+The --bind address may be a TCP address, "unix:/path" for a Unix domain socket
+(whose permissions may be set with --unix-mode and --unix-owner), or "systemd"
+to use a socket passed in by systemd socket activation. Where systemd passes
+several sockets, "systemd:name" selects one by its FileDescriptorName=.
+
 In order to use HTTPS, specify the --key (or -k) flag. This should name a
 PEM-encoded key file. This file may also contain the certificate; if not, then
 pass the --cert (or -c) flag in addition.
 
 Pack files may be specified as "/prefix=file", or just as "file" (which implies
 "/=file"). Any /prefix present in the request URL will be stripped off before
-searching the .htpack for the named file. Only one .htpack file can be served
-at a particular prefix, and serving matches the longest (most specific)
-prefixes first.`,
+searching the .htpack for the named file. Serving matches the longest (most
+specific) prefixes first.
+
+Without HTTPS, --h2c accepts HTTP/2 over cleartext (both with prior knowledge
+and by upgrading from HTTP/1.1), for use behind a proxy which terminates TLS
+and speaks HTTP/2 to its backends.
+
+Certificates are reloaded automatically when their files change (checked at
+most every 10 seconds), as well as on SIGHUP. By default, TLS 1.2 is the
+minimum version accepted; see the --tls-* flags. If --client-ca is given,
+clients must authenticate with a certificate signed by one of its CAs.
+
+With HTTPS, --redirect-bind adds a plain HTTP listener which redirects every
+request to HTTPS with a 308 status. ACME (e.g. Let's Encrypt) HTTP-01
+challenges are still answered on that listener from --acme-challenge, which
+may be a directory or a .htpack file containing each token at the top level.
+The Strict-Transport-Security header is set on HTTPS responses if
+--hsts-max-age is given.
+
+Virtual hosts are served by adding a host name before the prefix, as in
+"example.com/prefix=file" or just "example.com=file". Requests are routed by
+the Host header, and the first label of the name may be a wildcard (e.g.
+"*.example.com"). Requests for other hosts are served from the .htpack files
+with no host name, or the host named by --default-host; if there is neither,
+they receive the --unknown-host-status response. With HTTPS, --host-cert gives
+the certificate for each host, selected by SNI; --key may then be omitted, or
+used for clients which do not match any host.
+
+If more than one .htpack file is given for the same prefix, they are layered:
+each request is served from the first file (in command line order) which
+contains the requested path. This allows site-specific files to override a
+shared base pack.
+
+The Cache-Control header is set from --expiry, except for HTML documents
+(--cache-html, by default "no-cache") and fingerprinted files, whose names
+contain a content hash such as app.3f9a1c2b4d5e6f70.js or app.3f9a1c.js
+(--cache-fingerprinted, by default cached for a year and marked immutable).
+Further rules may be given with --cache-rule, and the first matching rule takes
+precedence over all of the above.
+
+Preload hints recorded in the .htpack files (see "htpacker pack --preload") are
+sent as Link headers. With --early-hints, they are also sent in a 103 Early
+Hints response before the rest of the response.
+
+Cross-origin requests (e.g. for fonts or JSON fetched by another site) are
+allowed from the origins given by --cors-origin, which may be patterns such as
+"https://*.example.com". Preflight OPTIONS requests are answered according to
+the other --cors-* flags. With --cors-credentials, the origins must be listed
+explicitly; "*" is rejected.
+
+Requests may be required to authenticate, either with HTTP Basic credentials
+checked against an htpasswd file (--auth-htpasswd; entries must use bcrypt, as
+written by "htpasswd -B") or with a bearer token listed in a file
+(--auth-tokens; one per line). If both are given, either is accepted. These
+files are re-read on SIGHUP. Responses from protected mounts are always marked
+"Cache-Control: private", so that they are not stored by shared caches.
+
+With --signed-keys, only URLs carrying a valid signature and an unexpired
+timestamp (as created by htpack.SignURL, in the form "?exp=...&sig=...") are
+served, and other requests receive a 403 response. The file holds one key per
+line; a signature made with any of them is accepted, so that keys may be
+rotated. It is re-read on SIGHUP, and responses are marked private as above.
+
+Each client may be limited to --rate-limit requests per second, beyond which
+it receives a 429 response with a Retry-After header, and to --bandwidth-limit
+bytes per second across all of its responses. Clients are identified by IP
+address; for connections from a --trusted-proxy (or over a Unix domain
+socket), the X-Forwarded-For header is used instead. --max-connections caps
+the number of connections open at once across all listeners; further clients
+wait to be accepted. So that idle or slow clients cannot hold on to all of the
+connections, it also closes connections which are idle for --idle-timeout or
+take longer than --read-header-timeout to send a request's headers.
+
+Large downloads can be kept from saturating the uplink: --response-bandwidth
+caps the bytes per second sent in each response, and --mount-bandwidth those
+sent by each prefix as a whole. Each allows a burst of one second's worth.
+
+If --metrics-bind is given, request counts, latencies and bytes sent (per
+prefix) and details of the loaded packs are served in the Prometheus text
+format at /metrics on that address. The prefix label of a virtual host's
+mounts includes the host name, e.g. "example.com/prefix".
+
+On SIGHUP, the header file, .htpack files and TLS key pair are reloaded without
+closing the listening socket; requests already in flight finish using the old
+files. On SIGTERM or SIGINT, the server stops accepting connections and waits
+up to --drain-timeout for requests in flight to complete before exiting.
+
+Instead of the flags and .htpack arguments above, the server may be described
+by a YAML file given with --config. This allows several listeners, and
+different settings for each virtual host and mount. Relative paths are
+interpreted relative to the configuration file's directory. The file is
+re-read on SIGHUP (though changes to the listeners require a restart). For
+example:
+
+    listeners:
+      - bind: ":443"
+        key: /etc/ssl/private/site.pem
+        tls_min_version: "1.2"
+        alpn: [h2, http/1.1]
+      - bind: unix:/run/packserver.sock
+        mode: "0660"
+        owner: www-data:www-data
+      - bind: ":80"
+        redirect_https: true
+        acme_challenge: /var/lib/acme/challenges
+    mounts:
+      - prefix: /
+        packs: [site.htpack, base.htpack]
+        index_file: index.html
+        headers:
+          X-Frame-Options: DENY
+        header_file: headers.txt
+        expiry: 1h
+        fallback:
+          file: /index.html
+          exclude_files: true
+          exclude_prefixes: [/api/]
+        error_pages:
+          404: /404.html
+        early_hints: true
+        cors:
+          origins: ["https://*.example.com"]
+          headers: [Authorization]
+          max_age: 1h
+      - prefix: /internal
+        packs: [docs.htpack]
+        auth:
+          realm: Internal documentation
+          htpasswd: docs.htpasswd
+          tokens: docs.tokens
+      - prefix: /downloads
+        packs: [downloads.htpack]
+        signed_keys: downloads.keys
+        bandwidth:
+          per_response: 2000000
+          per_mount: 20000000
+        cache:
+          rules:
+            - match: "*.woff2"
+              cache_control: "public, max-age=604800"
+          fingerprinted: "public, max-age=31536000, immutable"
+          html: no-cache
+    hosts:
+      - names: [example.com, "*.example.com"]
+        key: example.pem
+        mounts:
+          - prefix: /
+            packs: [example.htpack]
+    unknown_host:
+      status: 421
+      message: unknown host
+    hsts:
+      max_age: 8760h
+      include_subdomains: true
+    limits:
+      requests_per_second: 20
+      request_burst: 100
+      bytes_per_second: 1000000
+      trusted_proxies: [127.0.0.1, 10.0.0.0/8]
+      max_connections: 1000
+      idle_timeout: 1m
+      read_header_timeout: 10s
+      status: 429
+      message: too many requests
+
+The top level mounts make up the default host, used for requests which do not
+match any of the named hosts. If there are none, a host may instead be marked
+with "default: true". A listener with "tls: true" serves HTTPS using only the
+hosts' certificates.
+
+Pass --check-config to validate the configuration and check that every file
+can be loaded, without starting the server.`,
 	RunE: run,
 }
 
 func main() {
 	rootCmd.Flags().StringP("bind", "b", ":8080",
-		"Address to listen on / bind to")
+		"Address to listen on / bind to (host:port, unix:/path or systemd[:name])")
+	rootCmd.Flags().String("unix-mode", "",
+		"Permissions of Unix domain socket, in octal (e.g. 0660)")
+	rootCmd.Flags().String("unix-owner", "",
+		"Owner of Unix domain socket, in form user[:group]")
 	rootCmd.Flags().StringP("key", "k", "",
 		"Path to PEM-encoded HTTPS key")
 	rootCmd.Flags().StringP("cert", "c", "",
@@ -47,8 +234,118 @@ func main() {
 		"Path to text file containing one line for each header=value to add")
 	rootCmd.Flags().String("index-file", "",
 		"Name of index file (index.html or similar)")
+	rootCmd.Flags().String("fallback", "",
+		"File to serve for unknown paths (e.g. /index.html for single page apps)")
+	rootCmd.Flags().Bool("fallback-exclude-files", false,
+		"Do not serve fallback file for paths with an extension (e.g. /missing.js)")
+	rootCmd.Flags().StringSlice("fallback-exclude-prefix", nil,
+		"Do not serve fallback file for paths with this prefix (e.g. /api/)")
+	rootCmd.Flags().StringSlice("error-page", nil,
+		"Serve file from pack for error; use flag once for each, in form --error-page 404=/404.html")
 	rootCmd.Flags().Duration("expiry", 0,
 		"Tell client how long it can cache data for; 0 means no caching")
+	rootCmd.Flags().StringSlice("cache-rule", nil,
+		"Cache-Control for matching paths; use flag once for each, in form --cache-rule 'glob=value' or --cache-rule '~regexp=value'")
+	rootCmd.Flags().String("cache-fingerprinted", htpack.CacheImmutable,
+		"Cache-Control for content-hashed file names (e.g. app.3f9a1c2b4d5e6f70.js); empty to disable")
+	rootCmd.Flags().String("cache-html", htpack.CacheNoCache,
+		"Cache-Control for HTML documents; empty to disable")
+	rootCmd.Flags().Bool("early-hints", false,
+		"Send preload hints recorded in the .htpack files as 103 Early Hints")
+	rootCmd.Flags().Float64("response-bandwidth", 0,
+		"Bytes per second sent in each response; 0 means unlimited")
+	rootCmd.Flags().Float64("mount-bandwidth", 0,
+		"Bytes per second sent by each prefix, across all of its responses; 0 means unlimited")
+	rootCmd.Flags().StringSlice("cors-origin", nil,
+		"Allow cross-origin requests from this origin (e.g. https://*.example.com, or * for any); may be repeated")
+	rootCmd.Flags().StringSlice("cors-methods", nil,
+		"Methods allowed in cross-origin requests (default GET, HEAD)")
+	rootCmd.Flags().StringSlice("cors-headers", nil,
+		"Request headers allowed in cross-origin requests (* for any)")
+	rootCmd.Flags().StringSlice("cors-expose-headers", nil,
+		"Response headers exposed to cross-origin requests")
+	rootCmd.Flags().Duration("cors-max-age", 0,
+		"How long clients may cache CORS preflight responses; 0 means the client's default")
+	rootCmd.Flags().Bool("cors-credentials", false,
+		"Allow cross-origin requests with credentials (cookies or HTTP authentication)")
+	rootCmd.Flags().String("auth-htpasswd", "",
+		"Require HTTP Basic authentication against this htpasswd file (bcrypt entries only)")
+	rootCmd.Flags().String("auth-tokens", "",
+		"Require a bearer token from this file (one per line)")
+	rootCmd.Flags().String("auth-realm", defaultAuthRealm,
+		"Realm for HTTP authentication")
+	rootCmd.Flags().String("signed-keys", "",
+		"Serve only signed, unexpired URLs, verified with the keys in this file (one per line)")
+
+	rootCmd.Flags().String("default-host", "",
+		"Host to serve for requests with an unknown Host header (by default, the .htpack files with no host name)")
+	rootCmd.Flags().StringArray("host-cert", nil,
+		"HTTPS key and cert for a host, selected by SNI; use flag once for each, in form --host-cert host=key.pem[,cert.pem]")
+	rootCmd.Flags().Int("unknown-host-status", http.StatusMisdirectedRequest,
+		"Status code for requests with an unknown Host header, if there is no default host")
+	rootCmd.Flags().String("unknown-host-message", "unknown host",
+		"Response body for requests with an unknown Host header, if there is no default host")
+
+	rootCmd.Flags().Bool("h2c", false,
+		"Accept HTTP/2 over cleartext (h2c), e.g. behind a TLS-terminating proxy")
+	rootCmd.Flags().String("tls-min-version", "1.2",
+		"Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	rootCmd.Flags().StringSlice("tls-cipher-suites", nil,
+		"TLS 1.2 cipher suites to allow, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; default is Go's secure set")
+	rootCmd.Flags().StringSlice("tls-alpn", defaultALPN,
+		"Protocols to offer with ALPN: h2, http/1.1")
+	rootCmd.Flags().String("client-ca", "",
+		"Path to PEM-encoded CA bundle; clients must present a certificate signed by one of these CAs")
+	rootCmd.Flags().String("client-auth", "",
+		"With --client-ca, whether a client certificate is required (require) or only verified if given (optional)")
+
+	rootCmd.Flags().String("redirect-bind", "",
+		"Address for a plain HTTP listener which redirects to HTTPS (e.g. :80)")
+	rootCmd.Flags().Int("redirect-https-port", 0,
+		"Port to redirect to; 0 means the port of --bind")
+	rootCmd.Flags().String("acme-challenge", "",
+		"Directory or .htpack file to serve ACME challenges from on the --redirect-bind listener")
+	rootCmd.Flags().Duration("hsts-max-age", 0,
+		"Set Strict-Transport-Security on HTTPS responses with this max-age; 0 means disabled")
+	rootCmd.Flags().Bool("hsts-include-subdomains", false,
+		"Add includeSubDomains to Strict-Transport-Security")
+	rootCmd.Flags().Bool("hsts-preload", false,
+		"Add preload to Strict-Transport-Security (requires --hsts-include-subdomains and --hsts-max-age of at least 8760h)")
+
+	rootCmd.Flags().Float64("rate-limit", 0,
+		"Requests per second allowed from each client; 0 means unlimited")
+	rootCmd.Flags().Int("rate-limit-burst", 0,
+		"Requests a client may make at once before --rate-limit applies (default one second's worth)")
+	rootCmd.Flags().Float64("bandwidth-limit", 0,
+		"Bytes per second sent to each client; 0 means unlimited")
+	rootCmd.Flags().Int("bandwidth-limit-burst", 0,
+		"Bytes sent to a client at full speed before --bandwidth-limit applies (default one second's worth)")
+	rootCmd.Flags().StringSlice("trusted-proxy", nil,
+		"Address or CIDR range of a proxy whose X-Forwarded-For header identifies the client; may be repeated")
+	rootCmd.Flags().Int("max-connections", 0,
+		"Maximum number of connections open at once; 0 means unlimited")
+	rootCmd.Flags().Duration("idle-timeout", defaultIdleTimeout,
+		"With --max-connections, close connections idle for this long; 0 means no limit")
+	rootCmd.Flags().Duration("read-header-timeout", defaultReadHeaderTimeout,
+		"With --max-connections, close connections which take this long to send request headers; 0 means no limit")
+	rootCmd.Flags().Int("rate-limit-status", defaultLimitStatus,
+		"Status code for requests which exceed --rate-limit")
+	rootCmd.Flags().String("rate-limit-message", defaultLimitMessage,
+		"Response body for requests which exceed --rate-limit")
+
+	rootCmd.Flags().String("config", "",
+		"Path to YAML configuration file describing listeners and mounts")
+	rootCmd.Flags().Bool("check-config", false,
+		"Check the configuration (and that all files load) then exit")
+
+	rootCmd.Flags().String("access-log", "",
+		"Write access log to file (- for stdout)")
+	rootCmd.Flags().String("access-log-format", accessLogCommon,
+		"Format of access log: common or json")
+	rootCmd.Flags().Duration("drain-timeout", 30*time.Second,
+		"On SIGTERM/SIGINT, time to wait for requests in flight to complete; 0 means no limit")
+	rootCmd.Flags().String("metrics-bind", "",
+		"Address to serve Prometheus metrics on (at /metrics); empty to disable")
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -56,26 +353,262 @@ func main() {
 	}
 }
 
+// siteFlags are the flags which describe the site to serve. They may not be
+// combined with --config.
+var siteFlags = []string{
+	"bind", "unix-mode", "unix-owner", "key", "cert", "header", "header-file", "index-file",
+	"fallback", "fallback-exclude-files", "fallback-exclude-prefix",
+	"error-page", "expiry", "cache-rule", "cache-fingerprinted",
+	"cache-html", "early-hints", "response-bandwidth", "mount-bandwidth",
+	"cors-origin", "cors-methods",
+	"cors-headers", "cors-expose-headers", "cors-max-age",
+	"cors-credentials", "auth-htpasswd", "auth-tokens", "auth-realm",
+	"signed-keys", "default-host", "host-cert", "unknown-host-status",
+	"unknown-host-message", "h2c", "tls-min-version", "tls-cipher-suites",
+	"tls-alpn", "client-ca", "client-auth", "redirect-bind", "redirect-https-port",
+	"acme-challenge", "hsts-max-age", "hsts-include-subdomains",
+	"hsts-preload", "rate-limit", "rate-limit-burst", "bandwidth-limit",
+	"bandwidth-limit-burst", "trusted-proxy", "max-connections",
+	"idle-timeout", "read-header-timeout", "rate-limit-status",
+	"rate-limit-message",
+}
+
 func run(c *cobra.Command, args []string) error {
-	bindAddr, err := c.Flags().GetString("bind")
+	// the site is described either by a configuration file, which is
+	// re-read on reload, or by command line flags
+	configFilename, err := c.Flags().GetString("config")
+	if err != nil {
+		return err
+	}
+	var configure func() (*siteConfig, error)
+	if configFilename != "" {
+		if len(args) > 0 {
+			return errors.New("cannot specify .htpack files with --config")
+		}
+		for _, name := range siteFlags {
+			if c.Flags().Changed(name) {
+				return fmt.Errorf("cannot specify --%s with --config",
+					name)
+			}
+		}
+		configure = func() (*siteConfig, error) {
+			return loadConfig(configFilename)
+		}
+	} else {
+		cfg, err := configFromFlags(c, args)
+		if err != nil {
+			return err
+		}
+		configure = func() (*siteConfig, error) {
+			return cfg, nil
+		}
+	}
+
+	checkConfig, err := c.Flags().GetBool("check-config")
+	if err != nil {
+		return err
+	}
+
+	// optional access log
+	var logger func(*htpack.Event)
+	accessLogFile, err := c.Flags().GetString("access-log")
+	if err != nil {
+		return err
+	}
+	accessLogFormat, err := c.Flags().GetString("access-log-format")
+	if err != nil {
+		return err
+	}
+	if accessLogFile != "" && !checkConfig {
+		al, err := newAccessLog(accessLogFile, accessLogFormat)
+		if err != nil {
+			return err
+		}
+		logger = al.Log
+	}
+
+	// optional metrics, served from their own listener so they are not
+	// exposed alongside the site
+	metricsBind, err := c.Flags().GetString("metrics-bind")
+	if err != nil {
+		return err
+	}
+	var stats *metrics
+	if metricsBind != "" {
+		stats = newMetrics()
+	}
+
+	drainTimeout, err := c.Flags().GetDuration("drain-timeout")
+	if err != nil {
+		return err
+	}
+
+	// load packfiles and TLS key pairs
+	cfg, err := configure()
 	if err != nil {
 		return err
 	}
+	st, err := loadSite(cfg, logger, stats)
+	if err != nil {
+		return err
+	}
+	handler := new(siteHandler)
+	handler.swap(st)
+	if stats != nil {
+		stats.setPacks(st.packs)
+	}
+
+	var (
+		servers  []*http.Server
+		keyPairs []*keyPair
+	)
+	for _, l := range cfg.listeners {
+		server := &http.Server{
+			Addr:    l.bind,
+			Handler: handler,
+		}
+		if cfg.limits.maxConns > 0 {
+			// otherwise idle or slow clients could hold on to all
+			// of the connections
+			server.IdleTimeout = cfg.limits.idleTimeout
+			server.ReadHeaderTimeout = cfg.limits.readHeaderTimeout
+		}
+		if l.redirect {
+			server.Handler = &redirectHandler{
+				sites:     handler,
+				httpsPort: l.httpsPort,
+				acme:      l.acmeChallenge,
+			}
+		}
+		if l.h2c {
+			server.Handler = h2c.NewHandler(server.Handler,
+				new(http2.Server))
+		}
+		if l.useTLS {
+			var kp *keyPair
+			if l.keyFile != "" {
+				kp = &keyPair{certFile: l.certFile, keyFile: l.keyFile}
+				if err := kp.load(); err != nil {
+					return err
+				}
+				keyPairs = append(keyPairs, kp)
+			}
+			l.tls.configure(server, func(hello *tls.ClientHelloInfo,
+			) (*tls.Certificate, error) {
+				return handler.certificate(hello, kp)
+			})
+		}
+		servers = append(servers, server)
+	}
+
+	if checkConfig {
+		fmt.Println("configuration OK")
+		return nil
+	}
+
+	// open sockets before serving, so that errors are reported straight
+	// away and Unix domain sockets have the right permissions before any
+	// client connects
+	inherited := inheritSockets()
+	listeners := make([]net.Listener, len(servers))
+	var conns connLimit
+	if cfg.limits.maxConns > 0 {
+		conns = make(connLimit, cfg.limits.maxConns)
+	}
+	for i, l := range cfg.listeners {
+		if listeners[i], err = listen(l, inherited); err != nil {
+			return err
+		}
+		if conns != nil {
+			listeners[i] = conns.listener(listeners[i])
+		}
+	}
+
+	if stats != nil {
+		ln, err := listen(listener{bind: metricsBind}, inherited)
+		if err != nil {
+			return fmt.Errorf("--metrics-bind: %v", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", stats)
+		go func() {
+			err := http.Serve(ln, mux)
+			fmt.Fprintln(os.Stderr, "--metrics-bind:", err)
+			os.Exit(1)
+		}()
+	}
+
+	// main server loop
+	serveErr := make(chan error, len(servers))
+	for i, server := range servers {
+		go func(server *http.Server, ln net.Listener) {
+			if server.TLSConfig == nil {
+				serveErr <- server.Serve(ln)
+			} else {
+				serveErr <- server.ServeTLS(ln, "", "")
+			}
+		}(server, listeners[i])
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case err := <-serveErr:
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reload(configure, handler, keyPairs, logger, stats)
+				continue
+			}
+			return shutdown(servers, handler, drainTimeout)
+		}
+	}
+}
+
+// configFromFlags builds the site configuration from the command line flags
+// and .htpack arguments. Every prefix shares the same settings.
+func configFromFlags(c *cobra.Command, args []string) (*siteConfig, error) {
+	bindAddr, err := c.Flags().GetString("bind")
+	if err != nil {
+		return nil, err
+	}
+
+	// Unix domain socket permissions
+	unixModeArg, err := c.Flags().GetString("unix-mode")
+	if err != nil {
+		return nil, err
+	}
+	unixMode, err := parseMode(unixModeArg)
+	if err != nil {
+		return nil, fmt.Errorf("--unix-mode: %v", err)
+	}
+	unixOwner, err := c.Flags().GetString("unix-owner")
+	if err != nil {
+		return nil, err
+	}
+	if (unixMode != 0 || unixOwner != "") &&
+		!strings.HasPrefix(bindAddr, unixPrefix) {
+		return nil, errors.New("--unix-mode and --unix-owner may only " +
+			"be used with a unix:/path --bind address")
+	}
 
 	// parse TLS arguments
 	keyFile, err := c.Flags().GetString("key")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	certFile, err := c.Flags().GetString("cert")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	switch {
 	case keyFile == "" && certFile == "":
 		// nothing to do
 	case keyFile == "":
-		return errors.New("cannot specify --cert without --key")
+		return nil, errors.New("cannot specify --cert without --key")
 	case certFile == "":
 		certFile = keyFile
 	}
@@ -84,106 +617,621 @@ func run(c *cobra.Command, args []string) error {
 	extraHeaders := make(http.Header)
 	hdrs, err := c.Flags().GetStringSlice("header")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for _, hdr := range hdrs {
 		pos := strings.IndexRune(hdr, '=')
 		if pos == -1 {
-			return fmt.Errorf("header %q must be in form "+
+			return nil, fmt.Errorf("header %q must be in form "+
 				"name=value", hdr)
 		}
 		extraHeaders.Add(hdr[:pos], hdr[pos+1:])
 	}
 
+	//  NB: the header file is read as the site is loaded, so that it is
+	//  picked up again on reload
 	hdrfile, err := c.Flags().GetString("header-file")
 	if err != nil {
-		return err
-	}
-	if err := loadHeaderFile(hdrfile, extraHeaders); err != nil {
-		fmt.Fprintln(os.Stderr, "--header-file:", err)
-		os.Exit(1)
+		return nil, err
 	}
 
 	// parse expiry time
 	//  NB: https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cache-Control
 	expiry, err := c.Flags().GetDuration("expiry")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if expiry <= 0 {
-		extraHeaders.Set("Cache-Control", "no-cache")
-	} else {
-		extraHeaders.Set("Cache-Control",
-			fmt.Sprintf("public, max-age=%d", expiry/1e9))
+	cacheControl := "no-cache"
+	if expiry > 0 {
+		cacheControl = fmt.Sprintf("public, max-age=%d", expiry/1e9)
+	}
+
+	// cache policy, which overrides the expiry time for matching files
+	cachePolicy, err := cachePolicyFromFlags(c)
+	if err != nil {
+		return nil, err
 	}
 
 	// optional index file
-	//  NB: this is set below, as the handlers are instantiated
 	indexFile, err := c.Flags().GetString("index-file")
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// optional fallback route for single page apps
+	fallback, err := c.Flags().GetString("fallback")
+	if err != nil {
+		return nil, err
+	}
+	var fallbackOpts htpack.FallbackOptions
+	fallbackOpts.ExcludeFiles, err = c.Flags().GetBool("fallback-exclude-files")
+	if err != nil {
+		return nil, err
+	}
+	fallbackOpts.ExcludePrefixes, err = c.Flags().GetStringSlice(
+		"fallback-exclude-prefix")
+	if err != nil {
+		return nil, err
+	}
+
+	// send preload hints as 103 Early Hints?
+	earlyHints, err := c.Flags().GetBool("early-hints")
+	if err != nil {
+		return nil, err
+	}
+
+	// bandwidth limits for each response and each prefix
+	responseBandwidth, err := c.Flags().GetFloat64("response-bandwidth")
+	if err != nil {
+		return nil, err
+	}
+	mountBandwidth, err := c.Flags().GetFloat64("mount-bandwidth")
+	if err != nil {
+		return nil, err
+	}
+	if responseBandwidth < 0 || mountBandwidth < 0 {
+		return nil, errors.New("--response-bandwidth and " +
+			"--mount-bandwidth must not be negative")
+	}
+
+	// optional CORS policy
+	cors, err := corsPolicyFromFlags(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// optional authentication
+	auth, err := authFromFlags(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// optional keys for signed URLs
+	signedKeys, err := c.Flags().GetString("signed-keys")
+	if err != nil {
+		return nil, err
+	}
+
+	// optional error pages, in form "status=/file"
+	errorPageArgs, err := c.Flags().GetStringSlice("error-page")
+	if err != nil {
+		return nil, err
+	}
+	errorPages := make(map[int]string)
+	for _, arg := range errorPageArgs {
+		pos := strings.IndexRune(arg, '=')
+		if pos == -1 {
+			return nil, fmt.Errorf("error page %q must be in form "+
+				"status=/file", arg)
+		}
+		status, err := strconv.Atoi(arg[:pos])
+		if err != nil {
+			return nil, fmt.Errorf("error page %q: invalid status code",
+				arg)
+		}
+		errorPages[status] = arg[pos+1:]
+	}
+
+	// virtual hosts
+	defaultHost, err := c.Flags().GetString("default-host")
+	if err != nil {
+		return nil, err
+	}
+	defaultHost = strings.ToLower(defaultHost)
+	hostCerts, err := c.Flags().GetStringArray("host-cert")
+	if err != nil {
+		return nil, err
+	}
+	unknownStatus, err := c.Flags().GetInt("unknown-host-status")
+	if err != nil {
+		return nil, err
+	}
+	unknownMessage, err := c.Flags().GetString("unknown-host-message")
+	if err != nil {
+		return nil, err
+	}
+
+	// optional HTTP to HTTPS redirect, and HSTS
+	redirectBind, err := c.Flags().GetString("redirect-bind")
+	if err != nil {
+		return nil, err
+	}
+	redirectPort, err := c.Flags().GetInt("redirect-https-port")
+	if err != nil {
+		return nil, err
+	}
+	if redirectPort == 0 {
+		redirectPort = httpsPort(bindAddr)
+	}
+	acmeChallenge, err := c.Flags().GetString("acme-challenge")
+	if err != nil {
+		return nil, err
+	}
+	hstsMaxAge, err := c.Flags().GetDuration("hsts-max-age")
+	if err != nil {
+		return nil, err
+	}
+	hstsIncludeSubDomains, err := c.Flags().GetBool(
+		"hsts-include-subdomains")
+	if err != nil {
+		return nil, err
+	}
+	hstsPreload, err := c.Flags().GetBool("hsts-preload")
+	if err != nil {
+		return nil, err
+	}
+	hsts, err := hstsHeader(hstsMaxAge, hstsIncludeSubDomains, hstsPreload)
+	if err != nil {
+		return nil, err
+	}
+	useTLS := keyFile != "" || len(hostCerts) > 0
+
+	// per-client limits and the connection cap
+	limits, err := limitsFromFlags(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// cleartext HTTP/2, for use behind a TLS-terminating proxy
+	useH2C, err := c.Flags().GetBool("h2c")
+	if err != nil {
+		return nil, err
+	}
+	if useH2C && useTLS {
+		return nil, errors.New("cannot specify --h2c with HTTPS " +
+			"(HTTP/2 is always available over TLS)")
+	}
+
+	// TLS options
+	tlsMinVersion, err := c.Flags().GetString("tls-min-version")
+	if err != nil {
+		return nil, err
+	}
+	tlsCipherSuites, err := c.Flags().GetStringSlice("tls-cipher-suites")
+	if err != nil {
+		return nil, err
+	}
+	tlsALPN, err := c.Flags().GetStringSlice("tls-alpn")
+	if err != nil {
+		return nil, err
+	}
+	clientCA, err := c.Flags().GetString("client-ca")
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := c.Flags().GetString("client-auth")
+	if err != nil {
+		return nil, err
+	}
+	tlsOpts, err := parseTLSOptions(tlsMinVersion, tlsCipherSuites,
+		tlsALPN, clientCA, clientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if !useTLS {
+		for _, name := range []string{"tls-min-version",
+			"tls-cipher-suites", "tls-alpn", "client-ca", "client-auth",
+		} {
+			if c.Flags().Changed(name) {
+				return nil, fmt.Errorf("--%s requires HTTPS (--key "+
+					"or --host-cert)", name)
+			}
+		}
+	}
+	switch {
+	case (redirectBind != "" || hsts != "") && !useTLS:
+		return nil, errors.New("--redirect-bind and --hsts-max-age " +
+			"require HTTPS (--key or --host-cert)")
+	case acmeChallenge != "" && redirectBind == "":
+		return nil, errors.New("--acme-challenge requires --redirect-bind")
 	}
 
 	// verify .htpack specifications
 	if len(args) == 0 {
-		return errors.New("must specify one or more .htpack files")
+		return nil, errors.New("must specify one or more .htpack files")
+	}
+
+	cfg := &siteConfig{
+		listeners: []listener{{
+			bind:     bindAddr,
+			certFile: certFile,
+			keyFile:  keyFile,
+			useTLS:   useTLS,
+			tls:      tlsOpts,
+			h2c:      useH2C,
+			mode:     unixMode,
+			owner:    unixOwner,
+		}},
+		unknownStatus:  unknownStatus,
+		unknownMessage: unknownMessage,
+		hsts:           hsts,
+		limits:         limits,
+	}
+	if redirectBind != "" {
+		cfg.listeners = append(cfg.listeners, listener{
+			bind:          redirectBind,
+			redirect:      true,
+			httpsPort:     redirectPort,
+			acmeChallenge: acmeChallenge,
+		})
+	}
+	hosts := make(map[string]*vhost)
+	getHost := func(name string) *vhost {
+		vh := hosts[name]
+		if vh == nil {
+			vh = new(vhost)
+			if name != "" {
+				vh.names = []string{name}
+			}
+			hosts[name] = vh
+			cfg.hosts = append(cfg.hosts, vh)
+		}
+		return vh
 	}
 
-	packPaths := make(map[string]string)
+	mounts := make(map[string]*mount)
 	for _, arg := range args {
 		prefix, packfile := "/", arg
 		if pos := strings.IndexRune(arg, '='); pos != -1 {
 			prefix, packfile = arg[:pos], arg[pos+1:]
 		}
 
+		// optional host name, in form "host/prefix"
+		var host string
+		if prefix != "" && prefix[0] != '/' {
+			host = prefix
+			prefix = "/"
+			if pos := strings.IndexRune(host, '/'); pos != -1 {
+				host, prefix = host[:pos], host[pos:]
+			}
+			host = strings.ToLower(host)
+		}
+
 		prefix = filepath.Clean(prefix)
 		if prefix[0] != '/' {
-			return fmt.Errorf("%s: prefix must start with '/'", arg)
+			return nil, fmt.Errorf("%s: prefix must start with '/'", arg)
 		}
 
-		if other, used := packPaths[prefix]; used {
-			return fmt.Errorf("%s: prefix %q already used by %s",
-				arg, prefix, other)
+		m := mounts[host+prefix]
+		if m == nil {
+			m = &mount{
+				prefix:       prefix,
+				headers:      extraHeaders,
+				headerFile:   hdrfile,
+				cacheControl: cacheControl,
+				cachePolicy:  cachePolicy,
+				indexFile:    indexFile,
+				fallback:     fallback,
+				fallbackOpts: fallbackOpts,
+				errorPages:   errorPages,
+				earlyHints:   earlyHints,
+				cors:         cors,
+				auth:         auth,
+				signedKeys:   signedKeys,
+
+				responseBandwidth: responseBandwidth,
+				mountBandwidth:    mountBandwidth,
+			}
+			mounts[host+prefix] = m
+			vh := getHost(host)
+			vh.mounts = append(vh.mounts, m)
 		}
-		packPaths[prefix] = packfile
+		m.packs = append(m.packs, packfile)
 	}
 
-	// load packfiles, registering handlers as we go
-	for prefix, packfile := range packPaths {
-		packHandler, err := htpack.New(packfile)
-		if err != nil {
-			return err
-		}
-		if indexFile != "" {
-			packHandler.SetIndex(indexFile)
+	// the default host is the one given by --default-host, or otherwise
+	// the mounts that have no host name
+	switch {
+	case defaultHost == "":
+		if vh := hosts[""]; vh != nil {
+			vh.isDefault = true
 		}
+	case hosts[""] != nil:
+		return nil, errors.New("cannot specify --default-host with " +
+			".htpack files that have no host name")
+	case hosts[defaultHost] == nil:
+		return nil, fmt.Errorf("--default-host %q has no .htpack files",
+			defaultHost)
+	default:
+		hosts[defaultHost].isDefault = true
+	}
 
-		handler := &addHeaders{
-			extraHeaders: extraHeaders,
-			handler:      packHandler,
+	// per-host certificates, in form "host=key[,cert]"
+	for _, arg := range hostCerts {
+		pos := strings.IndexRune(arg, '=')
+		if pos == -1 {
+			return nil, fmt.Errorf("host certificate %q must be in "+
+				"form host=key[,cert]", arg)
+		}
+		vh := hosts[strings.ToLower(arg[:pos])]
+		if vh == nil || len(vh.names) == 0 {
+			return nil, fmt.Errorf("host certificate %q: host has "+
+				"no .htpack files", arg)
+		}
+		vh.keyFile, vh.certFile = arg[pos+1:], arg[pos+1:]
+		if pos := strings.IndexRune(vh.keyFile, ','); pos != -1 {
+			vh.keyFile, vh.certFile = vh.keyFile[:pos], vh.keyFile[pos+1:]
 		}
+	}
+
+	return cfg, nil
+}
 
-		if prefix != "/" {
-			http.Handle(prefix+"/",
-				http.StripPrefix(prefix, handler))
-		} else {
-			http.Handle("/", handler)
+// reload re-reads the configuration, header files, pack files and TLS key
+// pairs. New requests are served from the reloaded site straight away, while
+// those in flight finish on the old one. If anything fails to load, the
+// server carries on as before. Changes to the listeners are not picked up
+// until the server is restarted.
+func reload(configure func() (*siteConfig, error), handler *siteHandler,
+	keyPairs []*keyPair, logger func(*htpack.Event), stats *metrics,
+) {
+	cfg, err := configure()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reload failed:", err)
+		return
+	}
+	st, err := loadSite(cfg, logger, stats)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reload failed:", err)
+		return
+	}
+	for _, kp := range keyPairs {
+		if err := kp.load(); err != nil {
+			st.close()
+			fmt.Fprintln(os.Stderr, "reload failed:", err)
+			return
 		}
 	}
 
-	// main server loop
-	if keyFile == "" {
-		err = http.ListenAndServe(bindAddr, nil)
-	} else {
-		err = http.ListenAndServeTLS(bindAddr, certFile, keyFile, nil)
+	handler.swap(st)
+	if stats != nil {
+		stats.setPacks(st.packs)
+	}
+	fmt.Fprintln(os.Stderr, "reloaded")
+}
+
+// shutdown stops accepting new connections and waits up to drainTimeout for
+// requests in flight to complete.
+func shutdown(servers []*http.Server, handler *siteHandler,
+	drainTimeout time.Duration,
+) error {
+	ctx := context.Background()
+	if drainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, drainTimeout)
+		defer cancel()
+	}
+
+	var err error
+	for _, server := range servers {
+		if err = server.Shutdown(ctx); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		// hijacked connections (serving via sendfile) are not
+		// tracked by the server, so wait for them separately
+		err = handler.wait(ctx)
 	}
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return fmt.Errorf("shutdown: %v", err)
 	}
 	return nil
 }
 
+// eventLogger returns a function which passes each request event to the
+// access log and metrics, either or both of which may be nil.
+func eventLogger(prefix string, logger func(*htpack.Event), stats *metrics,
+) func(*htpack.Event) {
+	switch {
+	case stats == nil:
+		return logger
+	case logger == nil:
+		return func(ev *htpack.Event) {
+			stats.observe(prefix, ev)
+		}
+	}
+	return func(ev *htpack.Event) {
+		logger(ev)
+		stats.observe(prefix, ev)
+	}
+}
+
+func cachePolicyFromFlags(c *cobra.Command) (*htpack.CachePolicy, error) {
+	var (
+		policy htpack.CachePolicy
+		err    error
+	)
+
+	policy.Fingerprinted, err = c.Flags().GetString("cache-fingerprinted")
+	if err != nil {
+		return nil, err
+	}
+	policy.HTML, err = c.Flags().GetString("cache-html")
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := c.Flags().GetStringSlice("cache-rule")
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range rules {
+		pos := strings.IndexRune(arg, '=')
+		if pos == -1 {
+			return nil, fmt.Errorf("cache rule %q must be in form "+
+				"pattern=value", arg)
+		}
+		rule, err := cacheRule(arg[:pos], arg[pos+1:])
+		if err != nil {
+			return nil, err
+		}
+		policy.Rules = append(policy.Rules, rule)
+	}
+
+	return &policy, nil
+}
+
+// corsPolicyFromFlags returns the CORS policy given by the --cors-* flags, or
+// nil if no origins are allowed.
+func corsPolicyFromFlags(c *cobra.Command) (*htpack.CORSPolicy, error) {
+	var (
+		policy htpack.CORSPolicy
+		err    error
+	)
+
+	policy.AllowOrigins, err = c.Flags().GetStringSlice("cors-origin")
+	if err != nil {
+		return nil, err
+	}
+	policy.AllowMethods, err = c.Flags().GetStringSlice("cors-methods")
+	if err != nil {
+		return nil, err
+	}
+	policy.AllowHeaders, err = c.Flags().GetStringSlice("cors-headers")
+	if err != nil {
+		return nil, err
+	}
+	policy.ExposeHeaders, err = c.Flags().GetStringSlice(
+		"cors-expose-headers")
+	if err != nil {
+		return nil, err
+	}
+	policy.MaxAge, err = c.Flags().GetDuration("cors-max-age")
+	if err != nil {
+		return nil, err
+	}
+	policy.AllowCredentials, err = c.Flags().GetBool("cors-credentials")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(policy.AllowOrigins) == 0 {
+		for _, name := range []string{"cors-methods", "cors-headers",
+			"cors-expose-headers", "cors-max-age", "cors-credentials",
+		} {
+			if c.Flags().Changed(name) {
+				return nil, fmt.Errorf("--%s requires --cors-origin",
+					name)
+			}
+		}
+		return nil, nil
+	}
+	if err = checkCORSPolicy(&policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// authFromFlags returns the authentication settings given by the --auth-*
+// flags, or nil if authentication is not required.
+func authFromFlags(c *cobra.Command) (*authConfig, error) {
+	var (
+		auth authConfig
+		err  error
+	)
+
+	auth.htpasswdFile, err = c.Flags().GetString("auth-htpasswd")
+	if err != nil {
+		return nil, err
+	}
+	auth.tokenFile, err = c.Flags().GetString("auth-tokens")
+	if err != nil {
+		return nil, err
+	}
+	auth.realm, err = c.Flags().GetString("auth-realm")
+	if err != nil {
+		return nil, err
+	}
+
+	if auth.htpasswdFile == "" && auth.tokenFile == "" {
+		if c.Flags().Changed("auth-realm") {
+			return nil, errors.New("--auth-realm requires " +
+				"--auth-htpasswd or --auth-tokens")
+		}
+		return nil, nil
+	}
+	return &auth, nil
+}
+
+// limitsFromFlags returns the limits given by the --rate-limit*,
+// --bandwidth-limit*, --trusted-proxy, --max-connections and *-timeout flags.
+func limitsFromFlags(c *cobra.Command) (limits, error) {
+	var (
+		l   limits
+		err error
+	)
+
+	l.requestRate, err = c.Flags().GetFloat64("rate-limit")
+	if err != nil {
+		return l, err
+	}
+	l.requestBurst, err = c.Flags().GetInt("rate-limit-burst")
+	if err != nil {
+		return l, err
+	}
+	l.byteRate, err = c.Flags().GetFloat64("bandwidth-limit")
+	if err != nil {
+		return l, err
+	}
+	l.byteBurst, err = c.Flags().GetInt("bandwidth-limit-burst")
+	if err != nil {
+		return l, err
+	}
+	l.maxConns, err = c.Flags().GetInt("max-connections")
+	if err != nil {
+		return l, err
+	}
+	l.idleTimeout, err = c.Flags().GetDuration("idle-timeout")
+	if err != nil {
+		return l, err
+	}
+	l.readHeaderTimeout, err = c.Flags().GetDuration("read-header-timeout")
+	if err != nil {
+		return l, err
+	}
+	l.status, err = c.Flags().GetInt("rate-limit-status")
+	if err != nil {
+		return l, err
+	}
+	l.message, err = c.Flags().GetString("rate-limit-message")
+	if err != nil {
+		return l, err
+	}
+	proxies, err := c.Flags().GetStringSlice("trusted-proxy")
+	if err != nil {
+		return l, err
+	}
+	if l.trustedProxies, err = parseTrustedProxies(proxies); err != nil {
+		return l, fmt.Errorf("--trusted-proxy: %v", err)
+	}
+
+	if err = checkLimits(&l); err != nil {
+		return l, err
+	}
+	return l, nil
+}
+
 func loadHeaderFile(hdrfile string, extraHeaders http.Header) error {
 	if hdrfile == "" {
 		return nil
@@ -217,14 +1265,22 @@ func loadHeaderFile(hdrfile string, extraHeaders http.Header) error {
 	return nil
 }
 
+// addHeaders adds headers to each response. If private is set (for mounts
+// requiring authentication or signed URLs), the Cache-Control header is also
+// forced to mark the response as private, overriding any other value set by
+// the handler.
 type addHeaders struct {
 	extraHeaders http.Header
 	handler      http.Handler
+	private      bool
 }
 
 func (ah *addHeaders) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	for name, values := range ah.extraHeaders {
 		w.Header()[name] = append(w.Header()[name], values...)
 	}
+	if ah.private {
+		w = &privateWriter{ResponseWriter: w}
+	}
 	ah.handler.ServeHTTP(w, r)
 }
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lwithers/htpack"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the request latency
+// histogram. These extend to long durations since large downloads are
+// included.
+var latencyBuckets = []float64{
+	0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60,
+}
+
+// metrics collects statistics about the requests served by each prefix, and
+// exposes them in the Prometheus text format.
+type metrics struct {
+	mu          sync.Mutex
+	requests    map[requestKey]uint64
+	bytes       map[string]uint64
+	conditional map[string]uint64
+	notModified map[string]uint64
+	transfers   map[transferKey]uint64
+	latency     map[string]*histogram
+	packs       map[string][]htpack.PackInfo
+}
+
+// requestKey holds the labels of the request counter.
+type requestKey struct {
+	prefix, status, encoding string
+}
+
+// transferKey holds the labels of the body transfer counter.
+type transferKey struct {
+	prefix, method string
+}
+
+// histogram counts observations into buckets. counts[i] is the number of
+// observations less than or equal to latencyBuckets[i] (i.e. cumulative).
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:    make(map[requestKey]uint64),
+		bytes:       make(map[string]uint64),
+		conditional: make(map[string]uint64),
+		notModified: make(map[string]uint64),
+		transfers:   make(map[transferKey]uint64),
+		latency:     make(map[string]*histogram),
+	}
+}
+
+// setPacks records information about the packs served at each prefix,
+// replacing any previously recorded (e.g. before a reload).
+func (m *metrics) setPacks(packs map[string][]htpack.PackInfo) {
+	m.mu.Lock()
+	m.packs = packs
+	m.mu.Unlock()
+}
+
+// observe records the details of a request served at a prefix.
+func (m *metrics) observe(prefix string, ev *htpack.Event) {
+	_, inm := ev.Request.Header["If-None-Match"]
+	_, ims := ev.Request.Header["If-Modified-Since"]
+	encoding := ev.Encoding
+	if encoding == "" {
+		encoding = "identity"
+	}
+	latency := ev.Latency.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{
+		prefix:   prefix,
+		status:   fmt.Sprint(ev.Status),
+		encoding: encoding,
+	}]++
+	m.bytes[prefix] += ev.Bytes
+
+	if inm || ims {
+		m.conditional[prefix]++
+		if ev.Status == http.StatusNotModified {
+			m.notModified[prefix]++
+		}
+	}
+
+	if ev.Bytes > 0 {
+		method := "copy"
+		if ev.Sendfile {
+			method = "sendfile"
+		}
+		m.transfers[transferKey{prefix: prefix, method: method}]++
+	}
+
+	hist := m.latency[prefix]
+	if hist == nil {
+		hist = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[prefix] = hist
+	}
+	for i, bound := range latencyBuckets {
+		if latency <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += latency
+}
+
+// snapshot returns a copy of the metrics collected so far.
+func (m *metrics) snapshot() *metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := &metrics{
+		requests:    make(map[requestKey]uint64, len(m.requests)),
+		bytes:       make(map[string]uint64, len(m.bytes)),
+		conditional: make(map[string]uint64, len(m.conditional)),
+		notModified: make(map[string]uint64, len(m.notModified)),
+		transfers:   make(map[transferKey]uint64, len(m.transfers)),
+		latency:     make(map[string]*histogram, len(m.latency)),
+
+		// the packs map is replaced, never modified, by setPacks
+		packs: m.packs,
+	}
+	for k, v := range m.requests {
+		s.requests[k] = v
+	}
+	for k, v := range m.bytes {
+		s.bytes[k] = v
+	}
+	for k, v := range m.conditional {
+		s.conditional[k] = v
+	}
+	for k, v := range m.notModified {
+		s.notModified[k] = v
+	}
+	for k, v := range m.transfers {
+		s.transfers[k] = v
+	}
+	for k, hist := range m.latency {
+		s.latency[k] = &histogram{
+			counts: append([]uint64(nil), hist.counts...),
+			count:  hist.count,
+			sum:    hist.sum,
+		}
+	}
+	return s
+}
+
+// ServeHTTP writes out the metrics in the Prometheus text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	b := bufio.NewWriter(w)
+	defer b.Flush()
+
+	// format a copy of the metrics, so that the lock is not held while
+	// writing to a (possibly slow) client
+	s := m.snapshot()
+
+	writeHeader(b, "packserver_requests_total", "counter",
+		"Requests served, by prefix, status code and content encoding.")
+	reqKeys := make([]requestKey, 0, len(s.requests))
+	for k := range s.requests {
+		reqKeys = append(reqKeys, k)
+	}
+	sort.Slice(reqKeys, func(i, j int) bool {
+		a, b := reqKeys[i], reqKeys[j]
+		if a.prefix != b.prefix {
+			return a.prefix < b.prefix
+		}
+		if a.status != b.status {
+			return a.status < b.status
+		}
+		return a.encoding < b.encoding
+	})
+	for _, k := range reqKeys {
+		fmt.Fprintf(b, "packserver_requests_total{prefix=%s,status=%s,"+
+			"encoding=%s} %d\n", label(k.prefix), label(k.status),
+			label(k.encoding), s.requests[k])
+	}
+
+	writeHeader(b, "packserver_response_bytes_total", "counter",
+		"Bytes of response body sent, by prefix.")
+	writePrefixCounter(b, "packserver_response_bytes_total", s.bytes)
+
+	writeHeader(b, "packserver_conditional_requests_total", "counter",
+		"Requests with If-None-Match or If-Modified-Since, by prefix.")
+	writePrefixCounter(b, "packserver_conditional_requests_total",
+		s.conditional)
+
+	writeHeader(b, "packserver_not_modified_total", "counter",
+		"Conditional requests answered with 304 Not Modified, by prefix.")
+	writePrefixCounter(b, "packserver_not_modified_total", s.notModified)
+
+	writeHeader(b, "packserver_body_transfers_total", "counter",
+		"Response bodies sent, by prefix and method (sendfile or copy).")
+	xferKeys := make([]transferKey, 0, len(s.transfers))
+	for k := range s.transfers {
+		xferKeys = append(xferKeys, k)
+	}
+	sort.Slice(xferKeys, func(i, j int) bool {
+		a, b := xferKeys[i], xferKeys[j]
+		if a.prefix != b.prefix {
+			return a.prefix < b.prefix
+		}
+		return a.method < b.method
+	})
+	for _, k := range xferKeys {
+		fmt.Fprintf(b, "packserver_body_transfers_total{prefix=%s,"+
+			"method=%s} %d\n", label(k.prefix), label(k.method),
+			s.transfers[k])
+	}
+
+	writeHeader(b, "packserver_request_duration_seconds", "histogram",
+		"Time taken to write the full response, by prefix.")
+	for _, prefix := range sortedKeys(s.latency) {
+		hist := s.latency[prefix]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(b, "packserver_request_duration_seconds_bucket"+
+				"{prefix=%s,le=\"%g\"} %d\n",
+				label(prefix), bound, hist.counts[i])
+		}
+		fmt.Fprintf(b, "packserver_request_duration_seconds_bucket"+
+			"{prefix=%s,le=\"+Inf\"} %d\n", label(prefix), hist.count)
+		fmt.Fprintf(b, "packserver_request_duration_seconds_sum"+
+			"{prefix=%s} %g\n", label(prefix), hist.sum)
+		fmt.Fprintf(b, "packserver_request_duration_seconds_count"+
+			"{prefix=%s} %d\n", label(prefix), hist.count)
+	}
+
+	writeHeader(b, "packserver_pack_files", "gauge",
+		"Number of files in each loaded pack.")
+	s.writePacks(b, "packserver_pack_files", func(pi htpack.PackInfo) string {
+		return fmt.Sprint(pi.Files)
+	})
+
+	writeHeader(b, "packserver_pack_size_bytes", "gauge",
+		"Size of each loaded pack.")
+	s.writePacks(b, "packserver_pack_size_bytes", func(pi htpack.PackInfo) string {
+		return fmt.Sprint(pi.Size)
+	})
+
+	writeHeader(b, "packserver_pack_load_timestamp_seconds", "gauge",
+		"Time at which each pack was loaded, as a Unix timestamp.")
+	s.writePacks(b, "packserver_pack_load_timestamp_seconds",
+		func(pi htpack.PackInfo) string {
+			return fmt.Sprint(pi.LoadTime.Unix())
+		})
+}
+
+// writePacks writes out a gauge for each loaded pack, labelled by prefix and
+// pack filename.
+func (m *metrics) writePacks(b *bufio.Writer, name string,
+	value func(htpack.PackInfo) string,
+) {
+	prefixes := make([]string, 0, len(m.packs))
+	for prefix := range m.packs {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		for _, pi := range m.packs[prefix] {
+			fmt.Fprintf(b, "%s{prefix=%s,path=%s} %s\n", name,
+				label(prefix), label(pi.Filename), value(pi))
+		}
+	}
+}
+
+func writeHeader(b *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writePrefixCounter(b *bufio.Writer, name string, values map[string]uint64) {
+	prefixes := make([]string, 0, len(values))
+	for prefix := range values {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		fmt.Fprintf(b, "%s{prefix=%s} %d\n", name, label(prefix),
+			values[prefix])
+	}
+}
+
+func sortedKeys(m map[string]*histogram) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label returns a quoted and escaped label value.
+func label(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lwithers/htpack"
+)
+
+func TestMetrics(t *testing.T) {
+	stats := newMetrics()
+	var logged int
+	logger := func(*htpack.Event) {
+		logged++
+	}
+	site := eventLogger("/site", logger, stats)
+	dl := eventLogger("example.com/dl", nil, stats)
+
+	request := func(conditional bool) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		if conditional {
+			r.Header.Set("If-None-Match", `"etag"`)
+		}
+		return r
+	}
+	site(&htpack.Event{
+		Request:  request(false),
+		Status:   http.StatusOK,
+		Encoding: "br",
+		Bytes:    1000,
+		Sendfile: true,
+		Latency:  2 * time.Millisecond,
+	})
+	site(&htpack.Event{
+		Request: request(true),
+		Status:  http.StatusNotModified,
+		Latency: 500 * time.Microsecond,
+	})
+	site(&htpack.Event{
+		Request: request(true),
+		Status:  http.StatusOK,
+		Bytes:   24,
+		Latency: 20 * time.Millisecond,
+	})
+	dl(&htpack.Event{
+		Request: request(false),
+		Status:  http.StatusNotFound,
+		Bytes:   10,
+		Latency: 90 * time.Second,
+	})
+	if logged != 3 {
+		t.Errorf("logger called %d times, want 3", logged)
+	}
+
+	stats.setPacks(map[string][]htpack.PackInfo{
+		"/site": {{
+			Filename: `/srv/"site".htpack`,
+			Files:    12,
+			Size:     4096,
+			LoadTime: time.Unix(1700000000, 0),
+		}},
+	})
+
+	w := httptest.NewRecorder()
+	stats.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct,
+		"text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	body := w.Body.String()
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+
+	want := []string{
+		`# HELP packserver_requests_total Requests served, by prefix, status code and content encoding.`,
+		`# TYPE packserver_requests_total counter`,
+		`packserver_requests_total{prefix="/site",status="200",encoding="br"} 1`,
+		`packserver_requests_total{prefix="/site",status="200",encoding="identity"} 1`,
+		`packserver_requests_total{prefix="/site",status="304",encoding="identity"} 1`,
+		`packserver_requests_total{prefix="example.com/dl",status="404",encoding="identity"} 1`,
+		`# TYPE packserver_response_bytes_total counter`,
+		`packserver_response_bytes_total{prefix="/site"} 1024`,
+		`packserver_response_bytes_total{prefix="example.com/dl"} 10`,
+		`packserver_conditional_requests_total{prefix="/site"} 2`,
+		`packserver_not_modified_total{prefix="/site"} 1`,
+		`packserver_body_transfers_total{prefix="/site",method="copy"} 1`,
+		`packserver_body_transfers_total{prefix="/site",method="sendfile"} 1`,
+		`packserver_body_transfers_total{prefix="example.com/dl",method="copy"} 1`,
+		`# TYPE packserver_request_duration_seconds histogram`,
+		`packserver_request_duration_seconds_bucket{prefix="/site",le="0.001"} 1`,
+		`packserver_request_duration_seconds_bucket{prefix="/site",le="0.005"} 2`,
+		`packserver_request_duration_seconds_bucket{prefix="/site",le="0.01"} 2`,
+		`packserver_request_duration_seconds_bucket{prefix="/site",le="0.025"} 3`,
+		`packserver_request_duration_seconds_bucket{prefix="/site",le="60"} 3`,
+		`packserver_request_duration_seconds_bucket{prefix="/site",le="+Inf"} 3`,
+		`packserver_request_duration_seconds_sum{prefix="/site"} 0.0225`,
+		`packserver_request_duration_seconds_count{prefix="/site"} 3`,
+		`packserver_request_duration_seconds_bucket{prefix="example.com/dl",le="60"} 0`,
+		`packserver_request_duration_seconds_bucket{prefix="example.com/dl",le="+Inf"} 1`,
+		`packserver_request_duration_seconds_count{prefix="example.com/dl"} 1`,
+		`# TYPE packserver_pack_files gauge`,
+		`packserver_pack_files{prefix="/site",path="/srv/\"site\".htpack"} 12`,
+		`packserver_pack_size_bytes{prefix="/site",path="/srv/\"site\".htpack"} 4096`,
+		`packserver_pack_load_timestamp_seconds{prefix="/site",path="/srv/\"site\".htpack"} 1700000000`,
+	}
+
+	// each wanted line must be present, in order
+	pos := 0
+	for _, line := range want {
+		for pos < len(lines) && lines[pos] != line {
+			pos++
+		}
+		if pos == len(lines) {
+			t.Fatalf("missing or out of order: %s\n\n%s", line, body)
+		}
+	}
+
+	// every line is a comment or a sample
+	for _, line := range lines {
+		if strings.HasPrefix(line, "# HELP ") ||
+			strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) != 2 ||
+			!strings.HasPrefix(fields[0], "packserver_") {
+			t.Errorf("malformed line: %q", line)
+		}
+	}
+}
+
+// TestMetricsSlowScraper checks that requests can still be recorded while a
+// scrape is blocked writing to the client.
+func TestMetricsSlowScraper(t *testing.T) {
+	stats := newMetrics()
+	logger := eventLogger("/", nil, stats)
+
+	// enough prefixes that the output overflows any buffering
+	for i := 0; i < 100; i++ {
+		stats.observe(fmt.Sprintf("/prefix%d", i), &htpack.Event{
+			Request: httptest.NewRequest("GET", "/", nil),
+			Status:  http.StatusOK,
+		})
+	}
+
+	w := &blockingWriter{
+		ResponseRecorder: httptest.NewRecorder(),
+		writing:          make(chan struct{}),
+		unblock:          make(chan struct{}),
+	}
+	scraped := make(chan struct{})
+	go func() {
+		stats.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		close(scraped)
+	}()
+	<-w.writing
+
+	done := make(chan struct{})
+	go func() {
+		logger(&htpack.Event{
+			Request: httptest.NewRequest("GET", "/", nil),
+			Status:  http.StatusOK,
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("observe blocked by scrape")
+	}
+	close(w.unblock)
+	<-scraped
+}
+
+// blockingWriter blocks each write until unblock is closed, closing writing
+// when the first write starts.
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	writing, unblock chan struct{}
+	once             sync.Once
+}
+
+func (bw *blockingWriter) Write(buf []byte) (int, error) {
+	bw.once.Do(func() {
+		close(bw.writing)
+	})
+	<-bw.unblock
+	return bw.ResponseRecorder.Write(buf)
+}
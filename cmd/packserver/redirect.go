@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lwithers/htpack"
+)
+
+// acmeChallengePrefix is the path at which ACME (e.g. Let's Encrypt) HTTP-01
+// challenge responses are served. See RFC 8555 §8.3.
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// redirectHandler serves a plain HTTP listener which redirects every request
+// to HTTPS, except for ACME challenges.
+type redirectHandler struct {
+	sites     *siteHandler
+	httpsPort int
+	acme      string
+}
+
+func (rh *redirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rh.acme != "" && strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+		rh.sites.serve(func(s *site) {
+			// the listeners are fixed at startup, but a reloaded
+			// configuration may have dropped this challenge source
+			acme, ok := s.acme[rh.acme]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			acme.ServeHTTP(w, r)
+		})
+		return
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		http.Error(w, "missing Host header", http.StatusBadRequest)
+		return
+	}
+	if strings.IndexByte(host, ':') != -1 {
+		// IPv6 literal
+		host = "[" + host + "]"
+	}
+	if rh.httpsPort != 443 {
+		host += ":" + strconv.Itoa(rh.httpsPort)
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(),
+		http.StatusPermanentRedirect)
+}
+
+// loadACME returns a handler which serves ACME challenge responses from
+// either a directory or a .htpack file. The files are served directly under
+// acmeChallengePrefix, so the token "abc" would be served from the file
+// "abc" in the directory, or "/abc" in the pack.
+func (s *site) loadACME(source string) (http.Handler, error) {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimSuffix(acmeChallengePrefix, "/")
+	if fi.IsDir() {
+		return http.StripPrefix(prefix, acmeDir(source)), nil
+	}
+
+	h, err := htpack.New(source)
+	if err != nil {
+		return nil, err
+	}
+	s.handlers = append(s.handlers, h)
+	return http.StripPrefix(prefix, h), nil
+}
+
+// acmeDir serves ACME challenge responses from files in a directory (but not
+// its subdirectories).
+type acmeDir string
+
+func (dir acmeDir) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := path.Clean(r.URL.Path)[1:]
+	if token == "" || strings.ContainsAny(token, "/.") {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(string(dir), token))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, token, fi.ModTime(), f)
+}
+
+// hstsHeader returns the value of the Strict-Transport-Security header. See
+// RFC 6797 and https://hstspreload.org/ for the requirements of preloading.
+func hstsHeader(maxAge time.Duration, includeSubDomains, preload bool,
+) (string, error) {
+	if maxAge <= 0 {
+		if includeSubDomains || preload {
+			return "", errors.New("HSTS max-age must be set")
+		}
+		return "", nil
+	}
+
+	value := fmt.Sprintf("max-age=%d", maxAge/time.Second)
+	if includeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		if !includeSubDomains || maxAge < 365*24*time.Hour {
+			return "", errors.New("HSTS preload requires " +
+				"includeSubDomains and a max-age of at least " +
+				"one year")
+		}
+		value += "; preload"
+	}
+	return value, nil
+}
+
+// httpsPort returns the port of a bind address, for use in redirects, or 443
+// if it has none (e.g. for Unix domain sockets behind a proxy).
+func httpsPort(bind string) int {
+	_, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return 443
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n == 0 {
+		return 443
+	}
+	return n
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	acme := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("challenge"))
+	})
+	sites := new(siteHandler)
+	sites.swap(&site{
+		acme: map[string]http.Handler{"/srv/acme": acme},
+	})
+
+	tests := []struct {
+		acme      string
+		httpsPort int
+		url       string
+		status    int
+		location  string
+	}{
+		{"", 443, "http://example.com/a?b=c", http.StatusPermanentRedirect,
+			"https://example.com/a?b=c"},
+		{"", 8443, "http://example.com:8080/", http.StatusPermanentRedirect,
+			"https://example.com:8443/"},
+		{"", 443, "http://[::1]:80/x", http.StatusPermanentRedirect,
+			"https://[::1]/x"},
+		{"/srv/acme", 443, "http://example.com" + acmeChallengePrefix + "tok",
+			http.StatusOK, ""},
+		{"/srv/acme", 443, "http://example.com/other",
+			http.StatusPermanentRedirect, "https://example.com/other"},
+
+		// challenge source dropped from the configuration by a reload
+		{"/srv/gone", 443, "http://example.com" + acmeChallengePrefix + "tok",
+			http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rh := &redirectHandler{
+			sites:     sites,
+			httpsPort: tt.httpsPort,
+			acme:      tt.acme,
+		}
+		w := httptest.NewRecorder()
+		rh.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.url, w.Code,
+				tt.status)
+		}
+		if loc := w.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: Location %q, want %q", tt.url, loc,
+				tt.location)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/lwithers/htpack"
+)
+
+// siteConfig describes the listeners and virtual hosts of the server, whether
+// given on the command line or in a configuration file.
+type siteConfig struct {
+	listeners []listener
+	hosts     []*vhost
+
+	// unknownStatus and unknownMessage make up the response to requests
+	// for a host which is not configured, if there is no default host.
+	unknownStatus  int
+	unknownMessage string
+
+	// hsts is the Strict-Transport-Security header set by HTTPS
+	// listeners, if not empty.
+	hsts string
+
+	// limits are applied to every request, whatever its host.
+	limits limits
+}
+
+// listener is an address to serve on (see listen). If useTLS is set, HTTPS is
+// served, using the certificate of the virtual host selected by SNI or (if
+// there is none) the listener's own key pair, with the given TLS options.
+// Otherwise, if h2c is set, HTTP/2 is accepted over cleartext as well as
+// HTTP/1.1. The mode and owner are applied to Unix domain sockets.
+//
+// If redirect is set, the listener serves plain HTTP and redirects every
+// request to HTTPS on httpsPort, except for ACME challenges which are served
+// from acmeChallenge (a directory or .htpack file) if it is set.
+type listener struct {
+	bind, certFile, keyFile string
+	useTLS                  bool
+	mode                    os.FileMode
+	owner                   string
+	tls                     tlsOptions
+	h2c                     bool
+	redirect                bool
+	httpsPort               int
+	acmeChallenge           string
+}
+
+// vhost is a virtual host, selected by the request's Host header. A vhost
+// with no names is only used as the default.
+type vhost struct {
+	names             []string
+	isDefault         bool
+	certFile, keyFile string
+	mounts            []*mount
+}
+
+// label returns the name used for the mount at prefix in metrics.
+func (vh *vhost) label(prefix string) string {
+	if len(vh.names) == 0 {
+		return prefix
+	}
+	return vh.names[0] + prefix
+}
+
+// mount holds the settings for the pack file(s) served at one prefix. Pack
+// files, the header file and any authentication or signing key files are
+// (re)read each time the site is loaded.
+type mount struct {
+	prefix       string
+	packs        []string
+	headers      http.Header
+	headerFile   string
+	cacheControl string
+	cachePolicy  *htpack.CachePolicy
+	indexFile    string
+	fallback     string
+	fallbackOpts htpack.FallbackOptions
+	errorPages   map[int]string
+	earlyHints   bool
+	cors         *htpack.CORSPolicy
+	auth         *authConfig
+	signedKeys   string
+
+	// responseBandwidth and mountBandwidth cap the bytes per second sent
+	// in each response, and by the mount as a whole; zero means unlimited.
+	responseBandwidth float64
+	mountBandwidth    float64
+}
+
+// site is the complete set of handlers for the configured virtual hosts. It
+// is replaced as a whole when the server is reloaded.
+type site struct {
+	hosts          map[string]*hostSite
+	defaultHost    *hostSite
+	unknownStatus  int
+	unknownMessage string
+	hsts           string
+	clients        *clientLimiter
+
+	// acme holds the handlers for ACME challenges, keyed by their
+	// source directory or .htpack file.
+	acme map[string]http.Handler
+
+	handlers []*htpack.Handler
+	packs    map[string][]htpack.PackInfo
+
+	// inflight counts the requests currently being served by this site, so
+	// that its packs are not closed until they have finished.
+	inflight sync.WaitGroup
+}
+
+// hostSite holds the handlers and certificate for a virtual host.
+type hostSite struct {
+	mux  *http.ServeMux
+	cert *keyPair
+}
+
+// limitedLabel is the prefix under which requests rejected by the per-client
+// rate limit are recorded. They are refused before being routed to a mount,
+// so the label is empty.
+const limitedLabel = ""
+
+// loadSite opens the pack files, header files and certificates of each
+// virtual host, returning a new site. Each request is passed to logger and
+// recorded in stats, either of which may be nil.
+func loadSite(cfg *siteConfig, logger func(*htpack.Event), stats *metrics,
+) (*site, error) {
+	s := &site{
+		hosts:          make(map[string]*hostSite),
+		unknownStatus:  cfg.unknownStatus,
+		unknownMessage: cfg.unknownMessage,
+		hsts:           cfg.hsts,
+		acme:           make(map[string]http.Handler),
+		packs:          make(map[string][]htpack.PackInfo),
+	}
+	s.clients = newClientLimiter(&cfg.limits,
+		eventLogger(limitedLabel, logger, stats))
+	for _, vh := range cfg.hosts {
+		hs, err := s.addHost(vh, logger, stats)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		for _, name := range vh.names {
+			s.hosts[name] = hs
+		}
+		if vh.isDefault {
+			s.defaultHost = hs
+		}
+	}
+
+	for _, l := range cfg.listeners {
+		if l.acmeChallenge == "" || s.acme[l.acmeChallenge] != nil {
+			continue
+		}
+		acme, err := s.loadACME(l.acmeChallenge)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.acme[l.acmeChallenge] = acme
+	}
+	return s, nil
+}
+
+// addHost opens the pack files and certificate for a virtual host.
+func (s *site) addHost(vh *vhost, logger func(*htpack.Event), stats *metrics,
+) (*hostSite, error) {
+	hs := &hostSite{
+		mux: http.NewServeMux(),
+	}
+	if vh.keyFile != "" {
+		hs.cert = &keyPair{certFile: vh.certFile, keyFile: vh.keyFile}
+		if err := hs.cert.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range vh.mounts {
+		label := vh.label(m.prefix)
+		handler, err := s.addMount(m, label,
+			eventLogger(label, logger, stats))
+		if err != nil {
+			return nil, err
+		}
+
+		if m.prefix != "/" {
+			hs.mux.Handle(m.prefix+"/",
+				http.StripPrefix(m.prefix, handler))
+		} else {
+			hs.mux.Handle("/", handler)
+		}
+	}
+	return hs, nil
+}
+
+// addMount opens the pack files for a mount, returning its handler.
+func (s *site) addMount(m *mount, label string, logger func(*htpack.Event),
+) (http.Handler, error) {
+	extraHeaders := make(http.Header)
+	for name, values := range m.headers {
+		extraHeaders[name] = append([]string(nil), values...)
+	}
+	if err := loadHeaderFile(m.headerFile, extraHeaders); err != nil {
+		return nil, err
+	}
+	extraHeaders.Set("Cache-Control", m.cacheControl)
+
+	packHandler, err := htpack.NewLayered(m.packs...)
+	if err != nil {
+		return nil, err
+	}
+	s.handlers = append(s.handlers, packHandler)
+	s.packs[label] = packHandler.Packs()
+
+	if m.indexFile != "" {
+		packHandler.SetIndex(m.indexFile)
+	}
+	if m.fallback != "" {
+		packHandler.SetFallback(m.fallback, m.fallbackOpts)
+	}
+	for status, filename := range m.errorPages {
+		packHandler.SetErrorPage(status, filename)
+	}
+	packHandler.SetCachePolicy(m.cachePolicy)
+	packHandler.SetEarlyHints(m.earlyHints)
+	packHandler.SetCORS(m.cors)
+	packHandler.SetLogger(logger)
+	packHandler.SetLimiter(requestByteLimiter)
+	packHandler.SetBandwidth(m.responseBandwidth, m.mountBandwidth)
+
+	var handler http.Handler = packHandler
+	if m.signedKeys != "" {
+		keys, err := loadSigningKeys(m.signedKeys)
+		if err != nil {
+			return nil, err
+		}
+		signed := htpack.NewSignedHandler(packHandler, keys...)
+		signed.SetLogger(logger)
+		handler = signed
+	}
+	if m.auth != nil {
+		handler, err = newAuthHandler(m.auth, m.cors != nil, logger,
+			handler)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &addHeaders{
+		extraHeaders: extraHeaders,
+		handler:      handler,
+		private:      m.auth != nil || m.signedKeys != "",
+	}, nil
+}
+
+// lookupHost returns the virtual host for a host name, which may include a
+// port. Names are matched exactly, and then against wildcards such as
+// "*.example.com". Returns nil if there is no match.
+func (s *site) lookupHost(name string) *hostSite {
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+
+	if hs := s.hosts[name]; hs != nil {
+		return hs
+	}
+	if pos := strings.IndexByte(name, '.'); pos != -1 {
+		return s.hosts["*"+name[pos:]]
+	}
+	return nil
+}
+
+func (s *site) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.clients.serve(w, r, http.HandlerFunc(s.route))
+}
+
+// route passes a request to the handlers of its virtual host.
+func (s *site) route(w http.ResponseWriter, r *http.Request) {
+	// browsers ignore HSTS over plain HTTP (RFC 6797 §8.1)
+	if s.hsts != "" && r.TLS != nil {
+		w.Header().Set("Strict-Transport-Security", s.hsts)
+	}
+
+	hs := s.lookupHost(r.Host)
+	if hs == nil {
+		hs = s.defaultHost
+	}
+	if hs == nil {
+		http.Error(w, s.unknownMessage, s.unknownStatus)
+		return
+	}
+	hs.mux.ServeHTTP(w, r)
+}
+
+// close releases the site's pack files. It must not be called while requests
+// are still in flight.
+func (s *site) close() {
+	for _, h := range s.handlers {
+		h.Close()
+	}
+}
+
+// siteHandler serves requests from the current site, which may be swapped for
+// a new one at any time.
+type siteHandler struct {
+	mu   sync.RWMutex
+	site *site
+
+	// inflight counts all requests being served, across every site. Since
+	// htpack.Handler hijacks the connection to use sendfile(2), these
+	// requests are not tracked by http.Server.Shutdown.
+	inflight sync.WaitGroup
+}
+
+func (sh *siteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sh.serve(func(s *site) {
+		s.ServeHTTP(w, r)
+	})
+}
+
+// serve calls fn with the current site, which will not be closed until fn
+// returns.
+func (sh *siteHandler) serve(fn func(*site)) {
+	sh.mu.RLock()
+	s := sh.site
+	s.inflight.Add(1)
+	sh.inflight.Add(1)
+	sh.mu.RUnlock()
+
+	defer sh.inflight.Done()
+	defer s.inflight.Done()
+	fn(s)
+}
+
+// swap replaces the current site. The old site is closed once the requests
+// it is serving have finished.
+func (sh *siteHandler) swap(s *site) {
+	sh.mu.Lock()
+	old := sh.site
+	sh.site = s
+	sh.mu.Unlock()
+
+	if old != nil {
+		go func() {
+			old.inflight.Wait()
+			old.close()
+		}()
+	}
+}
+
+// certificate returns the certificate to use for a TLS connection, chosen by
+// SNI from the current site's virtual hosts. If no virtual host matches (or
+// it has no certificate of its own), the listener's key pair is used.
+func (sh *siteHandler) certificate(hello *tls.ClientHelloInfo, kp *keyPair,
+) (*tls.Certificate, error) {
+	sh.mu.RLock()
+	hs := sh.site.lookupHost(hello.ServerName)
+	if hs == nil {
+		hs = sh.site.defaultHost
+	}
+	sh.mu.RUnlock()
+
+	if hs != nil && hs.cert != nil {
+		return hs.cert.GetCertificate(hello)
+	}
+	if kp != nil {
+		return kp.GetCertificate(hello)
+	}
+	return nil, errors.New("no certificate for server name " +
+		hello.ServerName)
+}
+
+// wait blocks until all in-flight requests have finished, or the context is
+// done.
+func (sh *siteHandler) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		sh.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lwithers/htpack"
+	"github.com/lwithers/htpack/packed"
+)
+
+// writeTestPack writes a pack holding "/index.html" to a temporary
+// directory, returning its path.
+func writeTestPack(t *testing.T) string {
+	const headerLen, content = 36, "hello"
+	dir := &packed.Directory{
+		Files: map[string]*packed.File{
+			"/index.html": {
+				ContentType: "text/html",
+				Etag:        `"hello"`,
+				Uncompressed: &packed.FileData{
+					Offset: headerLen,
+					Length: uint64(len(content)),
+				},
+			},
+		},
+	}
+	rawDir, err := dir.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := &packed.Header{
+		Magic:           packed.Magic,
+		Version:         packed.VersionInitial,
+		DirectoryOffset: headerLen + uint64(len(content)),
+		DirectoryLength: uint64(len(rawDir)),
+	}
+	rawHdr, err := hdr.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := append(append(rawHdr, content...), rawDir...)
+	filename := filepath.Join(t.TempDir(), "site.htpack")
+	if err := os.WriteFile(filename, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// isOpen reports whether this process has a file descriptor open on filename.
+func isOpen(t *testing.T, filename string) bool {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range fds {
+		target, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if target == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// TestSiteSwap checks that a site replaced by a reload is not closed while it
+// is still serving a request.
+func TestSiteSwap(t *testing.T) {
+	filename := writeTestPack(t)
+	handler, err := htpack.New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSite := &site{handlers: []*htpack.Handler{handler}}
+	newSite := new(site)
+	sh := new(siteHandler)
+	sh.swap(oldSite)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		sh.serve(func(s *site) {
+			if s != oldSite {
+				t.Error("request not served by the old site")
+			}
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	sh.swap(newSite)
+	sh.serve(func(s *site) {
+		if s != newSite {
+			t.Error("request after swap not served by the new site")
+		}
+	})
+
+	// the held request keeps wait from returning, until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(),
+		20*time.Millisecond)
+	defer cancel()
+	if err := sh.wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wait with request in flight: got %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := sh.wait(ctx); err != context.Canceled {
+		t.Errorf("wait with cancelled context: got %v", err)
+	}
+	if !isOpen(t, filename) {
+		t.Fatal("old site closed with request in flight")
+	}
+
+	close(release)
+	<-finished
+	if err := sh.wait(context.Background()); err != nil {
+		t.Errorf("wait after request finished: got %v", err)
+	}
+	for deadline := time.Now().Add(time.Second); isOpen(t, filename); {
+		if time.Now().After(deadline) {
+			t.Fatal("old site not closed after request finished")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLookupHost(t *testing.T) {
+	exact, wild := new(hostSite), new(hostSite)
+	s := &site{hosts: map[string]*hostSite{
+		"example.com":   exact,
+		"*.example.org": wild,
+	}}
+
+	tests := []struct {
+		name string
+		want *hostSite
+	}{
+		{"example.com", exact},
+		{"example.com:8443", exact},
+		{"Example.COM.", exact},
+		{"EXAMPLE.com.:443", exact},
+		{"www.example.com", nil},
+		{"a.example.org", wild},
+		{"A.Example.Org.", wild},
+		{"a.example.org:8443", wild},
+		{"a.b.example.org", nil},
+		{"example.org", nil},
+		{"", nil},
+		{"[::1]:443", nil},
+	}
+	for _, tt := range tests {
+		if got := s.lookupHost(tt.name); got != tt.want {
+			t.Errorf("%q: got %p, want %p", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSiteCertificate(t *testing.T) {
+	// loaded certificates, which are not checked for changes until
+	// keyPairCheckInterval has passed
+	newKeyPair := func() *keyPair {
+		return &keyPair{cert: new(tls.Certificate), checked: time.Now()}
+	}
+	exact := &hostSite{cert: newKeyPair()}
+	wild := &hostSite{cert: newKeyPair()}
+	noCert := new(hostSite)
+	def := &hostSite{cert: newKeyPair()}
+	listenerKP := newKeyPair()
+
+	tests := []struct {
+		name       string
+		serverName string
+		defaultHS  *hostSite
+		kp         *keyPair
+		want       *keyPair // nil for an error
+	}{
+		{"exact", "example.com", def, listenerKP, exact.cert},
+		{"case", "EXAMPLE.com", nil, listenerKP, exact.cert},
+		{"wildcard", "a.example.org", def, listenerKP, wild.cert},
+		{"wildcard one label", "a.b.example.org", def, listenerKP,
+			def.cert},
+		{"default host", "other.example.net", def, listenerKP,
+			def.cert},
+		{"no SNI", "", def, listenerKP, def.cert},
+		{"vhost without cert", "nocert.example.com", def, listenerKP,
+			listenerKP},
+		{"default without cert", "other.example.net", noCert,
+			listenerKP, listenerKP},
+		{"no default", "other.example.net", nil, listenerKP,
+			listenerKP},
+		{"nothing", "other.example.net", nil, nil, nil},
+	}
+	for _, tt := range tests {
+		sh := new(siteHandler)
+		sh.swap(&site{
+			hosts: map[string]*hostSite{
+				"example.com":        exact,
+				"*.example.org":      wild,
+				"nocert.example.com": noCert,
+			},
+			defaultHost: tt.defaultHS,
+		})
+
+		hello := &tls.ClientHelloInfo{ServerName: tt.serverName}
+		cert, err := sh.certificate(hello, tt.kp)
+		switch {
+		case tt.want == nil:
+			if err == nil {
+				t.Errorf("%s: no error", tt.name)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case cert != tt.want.cert:
+			t.Errorf("%s: wrong certificate", tt.name)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyPairCheckInterval is how often a key pair's files are checked for
+// changes (e.g. after a certificate is renewed).
+const keyPairCheckInterval = 10 * time.Second
+
+// keyPair holds a TLS certificate, which is reloaded whenever its files
+// change, without restarting the listener.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// load (re)reads the certificate and key files. On error, the previously
+// loaded certificate remains in use.
+func (kp *keyPair) load() error {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	return kp.loadLocked()
+}
+
+func (kp *keyPair) loadLocked() error {
+	modTime, err := kp.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		return err
+	}
+	kp.cert = &cert
+	kp.modTime = modTime
+	kp.checked = time.Now()
+	return nil
+}
+
+// latestModTime returns the most recent modification time of the
+// certificate and key files.
+func (kp *keyPair) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, filename := range []string{kp.certFile, kp.keyFile} {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate returns the current certificate, first reloading it if its
+// files have changed. It is suitable for use in tls.Config.
+func (kp *keyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate,
+	error,
+) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	if time.Since(kp.checked) >= keyPairCheckInterval {
+		kp.checked = time.Now()
+		modTime, err := kp.latestModTime()
+		if err == nil && !modTime.Equal(kp.modTime) {
+			err = kp.loadLocked()
+		}
+		if err != nil {
+			// keep serving the old certificate; the files may be
+			// part way through being replaced, so try again later
+			fmt.Fprintf(os.Stderr, "reloading %s: %v\n",
+				kp.certFile, err)
+		}
+	}
+	return kp.cert, nil
+}
+
+// tlsOptions are the settings of an HTTPS listener.
+type tlsOptions struct {
+	minVersion   uint16
+	cipherSuites []uint16
+	alpn         []string
+	clientCAs    *x509.CertPool
+	clientAuth   tls.ClientAuthType
+}
+
+// defaultALPN is the list of protocols offered by default.
+var defaultALPN = []string{"h2", "http/1.1"}
+
+// parseTLSOptions parses the textual form of the TLS options, as given on the
+// command line or in the configuration file. Empty values take defaults.
+func parseTLSOptions(minVersion string, cipherSuites, alpn []string,
+	clientCA, clientAuth string,
+) (tlsOptions, error) {
+	var (
+		opts tlsOptions
+		err  error
+	)
+
+	switch minVersion {
+	case "1.0":
+		opts.minVersion = tls.VersionTLS10
+	case "1.1":
+		opts.minVersion = tls.VersionTLS11
+	case "", "1.2":
+		opts.minVersion = tls.VersionTLS12
+	case "1.3":
+		opts.minVersion = tls.VersionTLS13
+	default:
+		return opts, fmt.Errorf("TLS version %q not one of 1.0, 1.1, "+
+			"1.2, 1.3", minVersion)
+	}
+
+	if len(cipherSuites) > 0 {
+		if opts.cipherSuites, err = parseCipherSuites(cipherSuites); err != nil {
+			return opts, err
+		}
+	}
+
+	opts.alpn = defaultALPN
+	if len(alpn) > 0 {
+		opts.alpn = alpn
+	}
+	for _, proto := range opts.alpn {
+		if proto != "h2" && proto != "http/1.1" {
+			return opts, fmt.Errorf("ALPN protocol %q not one of "+
+				"h2, http/1.1", proto)
+		}
+	}
+	if err = checkH2CipherSuites(&opts); err != nil {
+		return opts, err
+	}
+
+	switch clientAuth {
+	case "", "require":
+		opts.clientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		opts.clientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return opts, fmt.Errorf("client auth %q not one of require, "+
+			"optional", clientAuth)
+	}
+	if clientCA == "" {
+		if clientAuth != "" {
+			return opts, fmt.Errorf("client auth %q requires a "+
+				"client CA", clientAuth)
+		}
+		opts.clientAuth = tls.NoClientCert
+		return opts, nil
+	}
+
+	raw, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return opts, err
+	}
+	opts.clientCAs = x509.NewCertPool()
+	if !opts.clientCAs.AppendCertsFromPEM(raw) {
+		return opts, fmt.Errorf("%s: no PEM-encoded certificates found",
+			clientCA)
+	}
+	return opts, nil
+}
+
+// parseCipherSuites converts cipher suite names, as listed by "openssl
+// ciphers -stdname" or in crypto/tls (e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256), to their IDs. Suites known to be
+// insecure are rejected. Note that the TLS 1.3 cipher suites are not
+// configurable.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	insecure := make(map[string]bool)
+	for _, cs := range tls.InsecureCipherSuites() {
+		insecure[cs.Name] = true
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		id, ok := known[name]
+		switch {
+		case insecure[name]:
+			return nil, fmt.Errorf("cipher suite %s is insecure", name)
+		case !ok:
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// checkH2CipherSuites ensures that, if h2 is offered, the cipher suites
+// include one required by HTTP/2 (RFC 7540 §9.2.2). Without it, net/http
+// refuses to start the server. The suites only matter below TLS 1.3.
+func checkH2CipherSuites(opts *tlsOptions) error {
+	if opts.cipherSuites == nil || opts.minVersion >= tls.VersionTLS13 ||
+		!opts.offersH2() {
+		return nil
+	}
+
+	for _, id := range opts.cipherSuites {
+		switch id {
+		case tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256:
+			return nil
+		}
+	}
+	return errors.New("ALPN protocol h2 requires cipher suite " +
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or " +
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256; add one of these, " +
+		"or offer only http/1.1")
+}
+
+// offersH2 reports whether HTTP/2 is among the ALPN protocols.
+func (opts *tlsOptions) offersH2() bool {
+	for _, proto := range opts.alpn {
+		if proto == "h2" {
+			return true
+		}
+	}
+	return false
+}
+
+// configure applies the options to an HTTPS server, whose certificates are
+// returned by getCert.
+func (opts *tlsOptions) configure(server *http.Server,
+	getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+) {
+	server.TLSConfig = &tls.Config{
+		GetCertificate: getCert,
+		MinVersion:     opts.minVersion,
+		CipherSuites:   opts.cipherSuites,
+		NextProtos:     opts.alpn,
+		ClientCAs:      opts.clientCAs,
+		ClientAuth:     opts.clientAuth,
+	}
+
+	// net/http always adds h2 to NextProtos unless HTTP/2 is disabled by
+	// setting TLSNextProto to a non-nil map
+	if !opts.offersH2() {
+		server.TLSNextProto = make(map[string]func(*http.Server,
+			*tls.Conn, http.Handler))
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseCipherSuites(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  []uint16
+		ok    bool
+	}{
+		{[]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+			[]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, true},
+		{[]string{" tls_ecdhe_ecdsa_with_aes_256_gcm_sha384",
+			"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+			[]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+			true},
+		{[]string{"TLS_RSA_WITH_RC4_128_SHA"}, nil, false},
+		{[]string{"ECDHE-RSA-AES128-GCM-SHA256"}, nil, false},
+	}
+	for _, tt := range tests {
+		ids, err := parseCipherSuites(tt.names)
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: got error %v, want ok=%v", tt.names, err,
+				tt.ok)
+			continue
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.names, ids, tt.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("%v: got %v, want %v", tt.names, ids,
+					tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseTLSOptions(t *testing.T) {
+	const (
+		h2Suite    = "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"
+		nonH2Suite = "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"
+	)
+	tests := []struct {
+		name         string
+		minVersion   string
+		cipherSuites []string
+		alpn         []string
+		clientAuth   string
+		want         string // expected in the error; empty if ok
+	}{
+		{"defaults", "", nil, nil, "", ""},
+		{"TLS 1.3", "1.3", nil, nil, "", ""},
+		{"bad version", "1.4", nil, nil, "", `TLS version "1.4"`},
+		{"bad ALPN", "", nil, []string{"h3"}, "",
+			`ALPN protocol "h3"`},
+		{"h2 suite", "", []string{nonH2Suite, h2Suite}, nil, "", ""},
+		{"no h2 suite", "", []string{nonH2Suite}, nil, "",
+			"ALPN protocol h2 requires cipher suite"},
+		{"no h2 suite, explicit ALPN", "1.2", []string{nonH2Suite},
+			[]string{"http/1.1", "h2"}, "",
+			"ALPN protocol h2 requires cipher suite"},
+		{"no h2 suite, HTTP/1.1 only", "", []string{nonH2Suite},
+			[]string{"http/1.1"}, "", ""},
+		{"no h2 suite, TLS 1.3", "1.3", []string{nonH2Suite}, nil, "",
+			""},
+		{"client auth without CA", "", nil, nil, "optional",
+			"requires a client CA"},
+		{"bad client auth", "", nil, nil, "sometimes",
+			`client auth "sometimes"`},
+	}
+	for _, tt := range tests {
+		opts, err := parseTLSOptions(tt.minVersion, tt.cipherSuites,
+			tt.alpn, "", tt.clientAuth)
+		switch {
+		case tt.want != "":
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: got error %v, want %q", tt.name,
+					err, tt.want)
+			}
+			continue
+		case err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		// net/http must accept the resulting configuration
+		if err := serveClosed(&opts); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+// TestH2CipherSuites checks that the cipher suites which checkH2CipherSuites
+// rejects are also rejected by net/http.
+func TestH2CipherSuites(t *testing.T) {
+	opts := tlsOptions{
+		minVersion: tls.VersionTLS12,
+		cipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		},
+		alpn: defaultALPN,
+	}
+	if checkH2CipherSuites(&opts) == nil {
+		t.Fatal("h2 without a required cipher suite accepted")
+	}
+	if serveClosed(&opts) == nil {
+		t.Error("net/http accepted h2 without a required cipher suite")
+	}
+}
+
+// serveClosed configures a server with the options and calls ServeTLS on a
+// closed listener, returning any error other than that caused by the listener
+// being closed.
+func serveClosed(opts *tlsOptions) error {
+	server := new(http.Server)
+	opts.configure(server, func(*tls.ClientHelloInfo) (*tls.Certificate,
+		error,
+	) {
+		return nil, errors.New("no certificate")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	ln.Close()
+	if err := server.ServeTLS(ln, "", ""); !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
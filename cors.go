@@ -0,0 +1,164 @@
+package htpack
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy allows resources to be fetched by pages from other origins, by
+// Cross-Origin Resource Sharing. See
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/CORS
+type CORSPolicy struct {
+	// AllowOrigins lists the origins (such as "https://example.com") which
+	// may fetch resources. An entry may be a glob pattern (see path.Match),
+	// such as "https://*.example.com", and "*" allows any origin (though
+	// not if AllowCredentials is set; see below).
+	AllowOrigins []string
+
+	// AllowMethods lists the methods which may be used in a cross-origin
+	// request. If empty, GET and HEAD are allowed (which are the only
+	// methods ServeHTTP supports).
+	AllowMethods []string
+
+	// AllowHeaders lists the request headers which may be sent in a
+	// cross-origin request, beyond those that are always allowed (such as
+	// Accept). "*" allows any header.
+	AllowHeaders []string
+
+	// ExposeHeaders lists the response headers which the requesting page
+	// may read, beyond those that are always exposed (such as
+	// Content-Type).
+	ExposeHeaders []string
+
+	// MaxAge is how long the client may cache the result of a preflight
+	// request. If zero, the client's default is used.
+	MaxAge time.Duration
+
+	// AllowCredentials permits requests which carry credentials (cookies
+	// or HTTP authentication). The allowed origin is then always echoed
+	// back, rather than "*". Since that would let any site make
+	// credentialed requests, a "*" entry in AllowOrigins matches nothing
+	// when this is set; the origins must be listed explicitly.
+	AllowCredentials bool
+}
+
+// SetCORS sets the policy used to answer cross-origin requests, including
+// preflight OPTIONS requests. Passing nil removes the policy, so that no CORS
+// headers are set and OPTIONS requests are rejected.
+func (h *Handler) SetCORS(policy *CORSPolicy) {
+	h.cors = policy
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header for
+// a request from the given origin, or an empty string if it is not allowed.
+func (policy *CORSPolicy) allowOrigin(origin string) string {
+	for _, pattern := range policy.AllowOrigins {
+		if pattern == "*" {
+			if policy.AllowCredentials {
+				// never reflect an arbitrary origin
+				continue
+			}
+			return "*"
+		}
+		if ok, _ := path.Match(pattern, origin); ok {
+			return origin
+		}
+	}
+	return ""
+}
+
+// allowMethod returns true if the method may be used in a cross-origin
+// request.
+func (policy *CORSPolicy) allowMethod(method string) bool {
+	if len(policy.AllowMethods) == 0 {
+		return method == "GET" || method == "HEAD"
+	}
+	for _, allowed := range policy.AllowMethods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders returns true if all of the headers (a comma-separated list, as
+// in the Access-Control-Request-Headers header) may be sent in a
+// cross-origin request.
+func (policy *CORSPolicy) allowHeaders(headers string) bool {
+outer:
+	for _, header := range strings.Split(headers, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		for _, allowed := range policy.AllowHeaders {
+			if allowed == "*" || strings.EqualFold(allowed, header) {
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// serveCORS sets the CORS headers for a request. It returns true if the
+// request was a preflight request, which it has answered.
+func (h *Handler) serveCORS(w http.ResponseWriter, req *http.Request,
+	ev *Event,
+) bool {
+	policy := h.cors
+	origin := req.Header.Get("Origin")
+	reqMethod := req.Header.Get("Access-Control-Request-Method")
+	preflight := req.Method == "OPTIONS" && origin != "" && reqMethod != ""
+	if preflight {
+		w.Header().Add("Vary", "Origin, Access-Control-Request-Method, "+
+			"Access-Control-Request-Headers")
+	} else {
+		w.Header().Add("Vary", "Origin")
+	}
+	if origin == "" {
+		return false
+	}
+
+	allowOrigin := policy.allowOrigin(origin)
+	if allowOrigin == "" {
+		if preflight {
+			// not allowed; the lack of CORS headers tells the
+			// client so
+			w.WriteHeader(http.StatusNoContent)
+			ev.Status = http.StatusNoContent
+		}
+		return preflight
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	if policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		if len(policy.ExposeHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers",
+				strings.Join(policy.ExposeHeaders, ", "))
+		}
+		return false
+	}
+
+	reqHeaders := req.Header.Get("Access-Control-Request-Headers")
+	if policy.allowMethod(reqMethod) && policy.allowHeaders(reqHeaders) {
+		w.Header().Set("Access-Control-Allow-Methods", reqMethod)
+		if reqHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers",
+				reqHeaders)
+		}
+		if policy.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age",
+				strconv.Itoa(int(policy.MaxAge/time.Second)))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	ev.Status = http.StatusNoContent
+	return true
+}
@@ -0,0 +1,195 @@
+package htpack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAllowOrigin(t *testing.T) {
+	tests := []struct {
+		origins     []string
+		credentials bool
+		origin      string
+		want        string
+	}{
+		{[]string{"*"}, false, "https://a.example", "*"},
+		{[]string{"*"}, true, "https://a.example", ""},
+		{[]string{"*", "https://a.example"}, true, "https://a.example",
+			"https://a.example"},
+		{[]string{"https://a.example"}, false, "https://a.example",
+			"https://a.example"},
+		{[]string{"https://a.example"}, false, "https://b.example", ""},
+		{[]string{"https://*.example.com"}, false,
+			"https://cdn.example.com", "https://cdn.example.com"},
+		{[]string{"https://*.example.com"}, false,
+			"http://cdn.example.com", ""},
+		{[]string{"https://*.example.com"}, false,
+			"https://example.com", ""},
+	}
+	for _, tt := range tests {
+		policy := &CORSPolicy{
+			AllowOrigins:     tt.origins,
+			AllowCredentials: tt.credentials,
+		}
+		if got := policy.allowOrigin(tt.origin); got != tt.want {
+			t.Errorf("%v (credentials %v): allowOrigin(%q) = %q, "+
+				"want %q", tt.origins, tt.credentials, tt.origin,
+				got, tt.want)
+		}
+	}
+}
+
+func TestAllowMethod(t *testing.T) {
+	tests := []struct {
+		methods []string
+		method  string
+		want    bool
+	}{
+		{nil, "GET", true},
+		{nil, "HEAD", true},
+		{nil, "POST", false},
+		{[]string{"GET", "PUT"}, "PUT", true},
+		{[]string{"GET", "PUT"}, "HEAD", false},
+	}
+	for _, tt := range tests {
+		policy := &CORSPolicy{AllowMethods: tt.methods}
+		if got := policy.allowMethod(tt.method); got != tt.want {
+			t.Errorf("%v: allowMethod(%q) = %v, want %v",
+				tt.methods, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestAllowHeaders(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		headers string
+		want    bool
+	}{
+		{nil, "", true},
+		{nil, "X-Token", false},
+		{[]string{"X-Token"}, "x-token", true},
+		{[]string{"X-Token"}, "X-Token, X-Other", false},
+		{[]string{"X-Token", "X-Other"}, " X-Other ,x-token,", true},
+		{[]string{"*"}, "X-Anything", true},
+	}
+	for _, tt := range tests {
+		policy := &CORSPolicy{AllowHeaders: tt.allowed}
+		if got := policy.allowHeaders(tt.headers); got != tt.want {
+			t.Errorf("%v: allowHeaders(%q) = %v, want %v",
+				tt.allowed, tt.headers, got, tt.want)
+		}
+	}
+}
+
+func TestServeCORS(t *testing.T) {
+	h := &Handler{cors: &CORSPolicy{
+		AllowOrigins:     []string{"https://a.example"},
+		AllowHeaders:     []string{"X-Token"},
+		ExposeHeaders:    []string{"Etag"},
+		MaxAge:           time.Hour,
+		AllowCredentials: true,
+	}}
+
+	tests := []struct {
+		name      string
+		method    string
+		headers   map[string]string
+		preflight bool
+		want      map[string]string
+	}{
+		{
+			name:   "same origin",
+			method: "GET",
+			want: map[string]string{
+				"Vary":                        "Origin",
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:    "simple",
+			method:  "GET",
+			headers: map[string]string{"Origin": "https://a.example"},
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://a.example",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "Etag",
+				"Access-Control-Allow-Methods":     "",
+			},
+		},
+		{
+			name:    "other origin",
+			method:  "GET",
+			headers: map[string]string{"Origin": "https://b.example"},
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+		{
+			name:   "preflight",
+			method: "OPTIONS",
+			headers: map[string]string{
+				"Origin":                         "https://a.example",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "x-token",
+			},
+			preflight: true,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "https://a.example",
+				"Access-Control-Allow-Methods": "GET",
+				"Access-Control-Allow-Headers": "x-token",
+				"Access-Control-Max-Age":       "3600",
+			},
+		},
+		{
+			name:   "preflight bad method",
+			method: "OPTIONS",
+			headers: map[string]string{
+				"Origin":                        "https://a.example",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			preflight: true,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "https://a.example",
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+		{
+			name:   "preflight other origin",
+			method: "OPTIONS",
+			headers: map[string]string{
+				"Origin":                        "https://b.example",
+				"Access-Control-Request-Method": "GET",
+			},
+			preflight: true,
+			want: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", nil)
+		for hkey, hval := range tt.headers {
+			req.Header.Set(hkey, hval)
+		}
+		w := httptest.NewRecorder()
+		ev := new(Event)
+		if got := h.serveCORS(w, req, ev); got != tt.preflight {
+			t.Errorf("%s: serveCORS returned %v", tt.name, got)
+		}
+		if tt.preflight && (w.Code != http.StatusNoContent ||
+			ev.Status != http.StatusNoContent) {
+			t.Errorf("%s: status %d, logged %d", tt.name, w.Code,
+				ev.Status)
+		}
+		for hkey, want := range tt.want {
+			if got := w.Header().Get(hkey); got != want {
+				t.Errorf("%s: %s %q, want %q", tt.name, hkey, got,
+					want)
+			}
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package htpack
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -21,10 +25,46 @@ const (
 	encodingBrotli = "br"
 )
 
-// TODO: logging
-
 // New returns a new handler. Standard security headers are set.
 func New(packfile string) (*Handler, error) {
+	return NewLayered(packfile)
+}
+
+// NewLayered returns a new handler which serves files from an ordered list of
+// packs. For each request, the packs are searched in the order given, and the
+// first pack containing the path is used to serve it. This allows (for
+// example) a site-specific pack to override files in a shared base pack.
+// Standard security headers are set.
+func NewLayered(packfiles ...string) (*Handler, error) {
+	if len(packfiles) == 0 {
+		return nil, errors.New("no pack files specified")
+	}
+
+	h := &Handler{
+		headers:    make(map[string]string),
+		startTime:  time.Now(),
+		errorPages: make(map[int]string),
+	}
+	for _, packfile := range packfiles {
+		p, err := openPack(packfile)
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
+		h.packs = append(h.packs, p)
+	}
+
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Frame-Options
+	h.SetHeader("X-Frame-Options", "sameorigin")
+
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Content-Type-Options
+	h.SetHeader("X-Content-Type-Options", "nosniff")
+
+	return h, nil
+}
+
+// openPack opens and memory-maps a single pack file, loading its directory.
+func openPack(packfile string) (*pack, error) {
 	f, err := os.Open(packfile)
 	if err != nil {
 		return nil, err
@@ -32,6 +72,7 @@ func New(packfile string) (*Handler, error) {
 
 	fi, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
 	mapped, err := unix.Mmap(int(f.Fd()), 0, int(fi.Size()),
@@ -48,30 +89,88 @@ func New(packfile string) (*Handler, error) {
 		return nil, err
 	}
 
-	h := &Handler{
+	return &pack{
 		f:         f,
 		mapped:    mapped,
 		dir:       dir.Files,
-		headers:   make(map[string]string),
-		startTime: time.Now(),
-	}
-
-	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Frame-Options
-	h.SetHeader("X-Frame-Options", "sameorigin")
-
-	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Content-Type-Options
-	h.SetHeader("X-Content-Type-Options", "nosniff")
-
-	return h, nil
+		redirects: dir.Redirects,
+		rewrites:  dir.Rewrites,
+		info: PackInfo{
+			Filename: packfile,
+			Files:    len(dir.Files),
+			Size:     fi.Size(),
+			LoadTime: time.Now(),
+		},
+	}, nil
 }
 
 // Handler implements http.Handler and allows options to be set.
 type Handler struct {
+	packs        []*pack
+	headers      map[string]string
+	startTime    time.Time
+	fallback     string
+	fallbackOpts FallbackOptions
+	errorPages   map[int]string
+	cachePolicy  *CachePolicy
+	cors         *CORSPolicy
+	earlyHints   bool
+	limiter      func(*http.Request) Limiter
+	responseRate float64
+	bandwidth    *RateLimiter
+	logger       func(*Event)
+}
+
+// pack is a single memory-mapped pack file. Each layer of a Handler has its
+// own file descriptor (used for sendfile) and mapping (used for fallback).
+type pack struct {
 	f         *os.File
 	mapped    []byte
 	dir       map[string]*packed.File
-	headers   map[string]string
-	startTime time.Time
+	redirects map[string]*packed.Redirect
+	rewrites  map[string]string
+	info      PackInfo
+}
+
+// PackInfo describes one of the packs served by a Handler.
+type PackInfo struct {
+	// Filename of the pack, as passed to New or NewLayered.
+	Filename string
+
+	// Files is the number of files in the pack.
+	Files int
+
+	// Size of the pack file, in bytes.
+	Size int64
+
+	// LoadTime is the time at which the pack was opened.
+	LoadTime time.Time
+}
+
+// Packs returns information about each of the packs served by the handler, in
+// the order they are searched.
+func (h *Handler) Packs() []PackInfo {
+	info := make([]PackInfo, len(h.packs))
+	for i, p := range h.packs {
+		info[i] = p.info
+	}
+	return info
+}
+
+// Close releases the memory mappings and file descriptors of all packs. The
+// handler must not be used after (or while) calling Close.
+func (h *Handler) Close() error {
+	var firstErr error
+	for _, p := range h.packs {
+		if err := unix.Munmap(p.mapped); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := p.f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	h.packs = nil
+	return firstErr
 }
 
 // SetHeader allows a custom header to be set on HTTP responses. These are
@@ -98,124 +197,442 @@ func (h *Handler) SetHeader(key, value string) {
 //
 // Existing routes are not overwritten, and this function could be called
 // multiple times with different filenames (noting later calls would not
-// overwrite files matching earlier calls).
+// overwrite files matching earlier calls). For a layered handler, the routes
+// are added to each layer separately.
 func (h *Handler) SetIndex(filename string) {
-	for k, v := range h.dir {
-		if filepath.Base(k) == filename {
-			routeToAdd := filepath.Dir(k)
-			if _, exists := h.dir[routeToAdd]; !exists {
-				h.dir[routeToAdd] = v
+	for _, p := range h.packs {
+		for k, v := range p.dir {
+			if filepath.Base(k) == filename {
+				routeToAdd := filepath.Dir(k)
+				if _, exists := p.dir[routeToAdd]; !exists {
+					p.dir[routeToAdd] = v
+				}
 			}
 		}
 	}
 }
 
-// ServeHTTP handles requests for files. It supports GET and HEAD methods, with
-// anything else returning a 405. Exact path matches are required, else a 404 is
-// returned.
+// SetErrorPage allows a file from the pack to be served as the body of error
+// responses with the given status code (ServeHTTP generates only 404 and 405
+// errors). For instance, calling this with 404 and "/404.html" will serve the
+// contents of that file (with the usual content encoding negotiation and
+// headers) whenever a path is not found. If the file is not present in the
+// pack, a plain text error is served instead. Passing an empty string for
+// filename removes the error page.
+func (h *Handler) SetErrorPage(status int, filename string) {
+	if filename == "" {
+		delete(h.errorPages, status)
+	} else {
+		h.errorPages[status] = filename
+	}
+}
+
+// Integrity returns the Subresource Integrity string (e.g. "sha384-…") of the
+// file that would be served at the given path, for use in the HTML
+// "integrity" attribute. Rewrites are followed, but not redirects or the
+// fallback route. It returns an empty string if there is no such file, or if
+// the pack was created without integrity information.
+func (h *Handler) Integrity(filename string) string {
+	filename = path.Clean(filename)
+	_, info := h.lookup(filename)
+	if info == nil {
+		_, info = h.lookupRewrite(filename)
+	}
+	if info == nil {
+		return ""
+	}
+	return info.Integrity
+}
+
+// SetEarlyHints controls whether a 103 Early Hints response is sent before
+// any file which has preload hints recorded in the pack (see the htpacker
+// "preload" option), allowing the client to start fetching the resources it
+// references while the response is still on its way. This requires the
+// client and any intermediate proxies to understand 1xx responses, so it is
+// disabled by default. The hints are always sent as Link headers on the final
+// response.
+func (h *Handler) SetEarlyHints(enable bool) {
+	h.earlyHints = enable
+}
+
+// FallbackOptions control which requests may be answered by the fallback
+// route set with SetFallback.
+type FallbackOptions struct {
+	// ExcludeFiles, if set, prevents the fallback being served for any path
+	// that looks like a file (i.e. its final element has an extension, as
+	// in "/missing.js"). Such requests will still be answered with a 404.
+	ExcludeFiles bool
+
+	// ExcludePrefixes lists path prefixes (such as "/api/") for which the
+	// fallback is never served. A prefix matches whole path elements only,
+	// with or without its trailing slash: "/api" excludes "/api" and
+	// "/api/x", but not "/apiary".
+	ExcludePrefixes []string
+}
+
+// SetFallback allows setting a file that will be served, with a 200 status,
+// in response to a request for a path that does not exist in the pack. This
+// is typically used for single page applications, where any route that is not
+// an asset should serve "/index.html" and let the client-side router take
+// over. opts may be used to exclude certain requests from the fallback.
+// Passing an empty string for filename disables the fallback.
+func (h *Handler) SetFallback(filename string, opts FallbackOptions) {
+	h.fallback = filename
+	h.fallbackOpts = opts
+}
+
+// useFallback returns true if the fallback route may be used to answer a
+// request for the given (cleaned) path.
+func (h *Handler) useFallback(reqPath string) bool {
+	if h.fallback == "" {
+		return false
+	}
+	if h.fallbackOpts.ExcludeFiles && path.Ext(reqPath) != "" {
+		return false
+	}
+	for _, prefix := range h.fallbackOpts.ExcludePrefixes {
+		prefix = strings.TrimSuffix(prefix, "/") + "/"
+		if strings.HasPrefix(reqPath+"/", prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup searches each layer in turn for the given path, returning the first
+// match, or nil if the path is not present in any layer.
+func (h *Handler) lookup(path string) (*pack, *packed.File) {
+	for _, p := range h.packs {
+		if info := p.dir[path]; info != nil {
+			return p, info
+		}
+	}
+	return nil, nil
+}
+
+// lookupRewrite searches each layer in turn for a rewrite rule matching the
+// given path, and returns the file that the first such rule points to (which
+// may be in any layer).
+func (h *Handler) lookupRewrite(path string) (*pack, *packed.File) {
+	for _, p := range h.packs {
+		if target, ok := p.rewrites[path]; ok {
+			return h.lookup(target)
+		}
+	}
+	return nil, nil
+}
+
+// lookupRedirect searches each layer in turn for a redirect matching the
+// given path, returning the first match, or nil if there is none.
+func (h *Handler) lookupRedirect(path string) *packed.Redirect {
+	for _, p := range h.packs {
+		if redir := p.redirects[path]; redir != nil {
+			return redir
+		}
+	}
+	return nil
+}
+
+// ServeHTTP handles requests for files. It supports GET and HEAD methods (and
+// CORS preflight OPTIONS requests, if SetCORS has been called), with anything
+// else returning a 405. Exact path matches are required. If no file matches,
+// then any rewrite or redirect rules recorded in the pack are applied; failing
+// that, the fallback route (if set) is served or a 404 is returned.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ev := &Event{
+		Request: req,
+		Path:    path.Clean(req.URL.Path),
+		Start:   time.Now(),
+	}
+	if h.logger != nil {
+		defer func() {
+			ev.Latency = time.Since(ev.Start)
+			h.logger(ev)
+		}()
+	}
+
 	// set custom headers before any processing; ensures these are set even
 	// on error responses
 	for hkey, hval := range h.headers {
 		w.Header().Set(hkey, hval)
 	}
 
+	// CORS headers are also needed on error responses; preflight requests
+	// are answered immediately
+	if h.cors != nil && h.serveCORS(w, req, ev) {
+		return
+	}
+
 	switch req.Method {
 	case "HEAD", "GET":
 		// OK
 	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		h.serveError(w, req, ev, http.StatusMethodNotAllowed,
+			"method not allowed")
 		return
 	}
 
-	info := h.dir[path.Clean(req.URL.Path)]
+	reqPath := ev.Path
+	p, info := h.lookup(reqPath)
 	if info == nil {
-		http.NotFound(w, req)
+		p, info = h.lookupRewrite(reqPath)
+	}
+	if info == nil {
+		if redir := h.lookupRedirect(reqPath); redir != nil {
+			serveRedirect(w, req, redir)
+			ev.Status = int(redir.Status)
+			return
+		}
+	}
+	if info == nil && h.useFallback(reqPath) {
+		p, info = h.lookup(h.fallback)
+	}
+	if info == nil {
+		h.serveError(w, req, ev, http.StatusNotFound,
+			"404 page not found")
 		return
 	}
 
+	if h.cachePolicy != nil {
+		cc := h.cachePolicy.cacheControl(reqPath, info.ContentType)
+		if cc != "" {
+			w.Header().Set("Cache-Control", cc)
+		}
+	}
+
+	h.serveFile(w, req, ev, p, info, http.StatusOK)
+}
+
+// serveFile writes out the given file from pack p, choosing between its
+// variants (if any) by content negotiation. If status is http.StatusOK,
+// conditional and range requests are processed; for any other status (i.e. an
+// error page), the full file is always sent with that status. The details of
+// the response are recorded in ev.
+func (h *Handler) serveFile(w http.ResponseWriter, req *http.Request,
+	ev *Event, p *pack, info *packed.File, status int,
+) {
+	info, vary := selectVariant(info, req)
+
+	// set per-file headers, overriding any custom headers
+	for hkey, hval := range info.Headers {
+		w.Header().Set(hkey, hval)
+	}
+
 	// set standard headers
-	w.Header().Set("Vary", "Accept-Encoding")
-	w.Header().Set("Etag", info.Etag)
+	vary = append([]string{"Accept-Encoding"}, vary...)
+	if h.cors != nil {
+		vary = append(vary, "Origin")
+	}
+	w.Header().Set("Vary", strings.Join(vary, ", "))
 	w.Header().Set("Content-Type", info.ContentType)
-	w.Header().Set("Accept-Ranges", "bytes")
-
-	// process etag / modtime
-	if clientHasCachedVersion(info.Etag, h.startTime, req) {
-		w.WriteHeader(http.StatusNotModified)
-		return
+	if info.Language != "" {
+		w.Header().Set("Content-Language", info.Language)
+	}
+	if status == http.StatusOK {
+		w.Header().Set("Etag", info.Etag)
+		w.Header().Set("Accept-Ranges", "bytes")
+
+		// process etag / modtime
+		cached := clientHasCachedVersion(info.Etag, h.startTime, req)
+		if len(info.Preloads) > 0 {
+			h.sendPreloads(w, req, info.Preloads, cached)
+		}
+		if cached {
+			w.WriteHeader(http.StatusNotModified)
+			ev.Status = http.StatusNotModified
+			return
+		}
 	}
 
 	// select compression
 	data := info.Uncompressed
+	var encoding string
 	gzip, brotli := acceptedEncodings(req)
 	if brotli && info.Brotli != nil {
 		data = info.Brotli
-		w.Header().Set("Content-Encoding", encodingBrotli)
+		encoding = encodingBrotli
 	} else if gzip && info.Gzip != nil {
 		data = info.Gzip
-		w.Header().Set("Content-Encoding", encodingGzip)
+		encoding = encodingGzip
+	}
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
 	}
 
 	// range support (single-part ranges only)
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Range_requests#Single_part_ranges
-	offset, length, isPartial := getFileRange(data, req)
+	offset, length, isPartial := uint64(0), data.Length, false
+	if status == http.StatusOK {
+		offset, length, isPartial = getFileRange(data, req)
+		_, ev.Range = req.Header["Range"]
+		ev.Partial = isPartial
+	}
 	if isPartial {
 		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d",
 			offset, offset+length-1, data.Length))
+		status = http.StatusPartialContent
 	}
 
 	// now we know exactly what we're writing, finalise HTTP header
 	w.Header().Set("Content-Length", strconv.FormatUint(length, 10))
-	if isPartial {
-		w.WriteHeader(http.StatusPartialContent)
-	} else {
-		w.WriteHeader(http.StatusOK)
-	}
+	w.WriteHeader(status)
+	ev.Status = status
 
 	// send body (though not for HEAD)
 	if req.Method == "HEAD" {
 		return
 	}
-	h.sendfile(w, data, offset, length)
+	ev.Encoding = encoding
+	ev.Bytes, ev.Sendfile, ev.Err = h.sendfile(req.Context(), w, p, data,
+		offset, length, h.responseLimiter(req))
+}
+
+// sendPreloads adds a Link header for each preload hint, and sends them as a
+// 103 Early Hints response first if enabled (and if the client is going to
+// receive the full response).
+func (h *Handler) sendPreloads(w http.ResponseWriter, req *http.Request,
+	preloads []*packed.Preload, cached bool,
+) {
+	prefix := mountPrefix(req)
+	for _, pl := range preloads {
+		target := pl.Path
+		if strings.HasPrefix(target, "/") {
+			target = prefix + target
+		}
+		link := fmt.Sprintf("<%s>; rel=preload; as=%s",
+			(&url.URL{Path: target}).EscapedPath(), pl.As)
+		if pl.Crossorigin {
+			link += "; crossorigin"
+		}
+		w.Header().Add("Link", link)
+	}
+
+	if !h.earlyHints || cached || req.Method != "GET" {
+		return
+	}
+
+	// the 103 response is sent with whatever headers are set at the time,
+	// but should carry only the links, so hide the others until after
+	hdr := w.Header()
+	saved := make(http.Header, len(hdr))
+	for hkey, hval := range hdr {
+		if hkey != "Link" {
+			saved[hkey] = hval
+			delete(hdr, hkey)
+		}
+	}
+	w.WriteHeader(http.StatusEarlyHints)
+	for hkey, hval := range saved {
+		hdr[hkey] = hval
+	}
+}
+
+// serveError writes an error response. If an error page has been set for the
+// status code (see SetErrorPage) and is present in the pack, it is served;
+// otherwise, a plain text response containing msg is written.
+func (h *Handler) serveError(w http.ResponseWriter, req *http.Request,
+	ev *Event, status int, msg string,
+) {
+	if filename, ok := h.errorPages[status]; ok {
+		if p, info := h.lookup(filename); info != nil {
+			h.serveFile(w, req, ev, p, info, status)
+			return
+		}
+	}
+	http.Error(w, msg, status)
+	ev.Status = status
+	ev.Bytes = uint64(len(msg) + 1) // http.Error appends newline
 }
 
-func (h *Handler) sendfile(w http.ResponseWriter, data *packed.FileData,
-	offset, length uint64,
+// serveRedirect writes a redirect response. An absolute path in the redirect's
+// location is treated as relative to the point at which the pack is served.
+func serveRedirect(w http.ResponseWriter, req *http.Request,
+	redir *packed.Redirect,
 ) {
+	location := redir.Location
+	if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") {
+		location = mountPrefix(req) + location
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(int(redir.Status))
+}
+
+// mountPrefix returns the prefix that was stripped from the request path
+// (e.g. by http.StripPrefix) before the request reached the handler. It
+// returns an empty string if the handler is serving at the root.
+func mountPrefix(req *http.Request) string {
+	orig, err := url.ParseRequestURI(req.RequestURI)
+	if err != nil {
+		return ""
+	}
+	if !strings.HasSuffix(orig.Path, req.URL.Path) {
+		return ""
+	}
+	return strings.TrimSuffix(orig.Path[:len(orig.Path)-len(req.URL.Path)],
+		"/")
+}
+
+// sendfile writes out the response body, using sendfile(2) if the underlying
+// connection allows it, or falling back to copyfile otherwise. If limiter is
+// not nil, the body is paced by it. Sending stops early if ctx is done or the
+// client goes away. Returns the number of bytes written, whether sendfile(2)
+// was used, and any error.
+func (h *Handler) sendfile(ctx context.Context, w http.ResponseWriter,
+	p *pack, data *packed.FileData, offset, length uint64, limiter Limiter,
+) (uint64, bool, error) {
 	hj, ok := w.(http.Hijacker)
 	if !ok {
 		// fallback
-		h.copyfile(w, data, offset, length)
-		return
+		n, err := h.copyfile(ctx, w, p, data, offset, length, limiter)
+		return n, false, err
 	}
 
 	conn, buf, err := hj.Hijack()
 	if err != nil {
 		// fallback
-		h.copyfile(w, data, offset, length)
-		return
+		n, err := h.copyfile(ctx, w, p, data, offset, length, limiter)
+		return n, false, err
+	}
+	defer conn.Close()
+
+	// once hijacked, the request's context is no longer cancelled when
+	// the client goes away, so watch for that here; otherwise a paced
+	// response would carry on waiting for its limiter
+	if limiter != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go watchClose(buf.Reader, cancel)
 	}
 
-	tcp, ok := conn.(*net.TCPConn)
+	// sendfile(2) can write to any socket whose file descriptor is
+	// exposed, i.e. TCP and Unix domain sockets (or wrappers around them,
+	// e.g. for counting connections), but not TLS connections
+	sock, ok := conn.(syscall.Conn)
 	if !ok {
-		// fallback
-		h.copyfile(w, data, offset, length)
-		return
+		// fallback; since the connection has already been hijacked,
+		// we must write to it directly (e.g. TLS connections)
+		n, err := h.copyfile(ctx, buf, p, data, offset, length, limiter)
+		if err == nil {
+			err = buf.Flush()
+		}
+		return n, false, err
 	}
-	defer tcp.Close()
 
-	rawsock, err := tcp.SyscallConn()
+	rawsock, err := sock.SyscallConn()
 	if err == nil {
 		err = buf.Flush()
 	}
 	if err != nil {
 		// error only returned if the underlying connection is broken,
 		// so there's no point calling sendfile
-		return
+		return 0, true, err
 	}
 
-	var breakErr error
+	var (
+		breakErr error
+		paid     int // bytes allowed by limiter but not yet written
+	)
 	off := int64(data.Offset + offset)
 	remain := length
 
@@ -227,6 +644,18 @@ func (h *Handler) sendfile(w http.ResponseWriter, data *packed.FileData,
 		} else {
 			amt = int(remain)
 		}
+		if limiter != nil {
+			if amt > limitChunkSize {
+				amt = limitChunkSize
+			}
+			if paid < amt {
+				if err := limiter.Wait(ctx, amt-paid); err != nil {
+					breakErr = err
+					break
+				}
+				paid = amt
+			}
+		}
 
 		// behaviour of control function:
 		//  · some bytes written: sets written > 0, returns true (breaks
@@ -235,8 +664,8 @@ func (h *Handler) sendfile(w http.ResponseWriter, data *packed.FileData,
 		//            success or permanent failure)
 		//  · other error: sets breakErr
 		var written int
-		rawsock.Write(func(outfd uintptr) bool {
-			written, err = unix.Sendfile(int(outfd), int(h.f.Fd()), &off, amt)
+		werr := rawsock.Write(func(outfd uintptr) bool {
+			written, err = unix.Sendfile(int(outfd), int(p.f.Fd()), &off, amt)
 			switch err {
 			case nil:
 				return true
@@ -247,19 +676,81 @@ func (h *Handler) sendfile(w http.ResponseWriter, data *packed.FileData,
 				return true
 			}
 		})
+		if breakErr == nil {
+			// error from the connection itself (e.g. closed)
+			breakErr = werr
+		}
 
 		// we may have had a partial write, or file may have been > 1GiB
-		remain -= uint64(written)
+		if written > 0 {
+			remain -= uint64(written)
+			paid -= written
+		}
 	}
+
+	return length - remain, true, breakErr
 }
 
+// watchClose reads from a hijacked connection until it fails, which happens
+// when the client closes it (or it is closed once the response is sent), and
+// then calls cancel. Anything the client sends in the meantime, such as a
+// pipelined request, is discarded, since the connection is not reused.
+func watchClose(r *bufio.Reader, cancel context.CancelFunc) {
+	var buf [512]byte
+	for {
+		if _, err := r.Read(buf[:]); err != nil {
+			cancel()
+			return
+		}
+	}
+}
+
+// copyChunkSize is the amount of memory-mapped data written at a time by
+// copyfile.
+const copyChunkSize = 256 << 10
+
 // copyfile is a fallback handler that uses write(2) on our memory-mapped data
-// to push out the response.
-func (h *Handler) copyfile(w http.ResponseWriter, data *packed.FileData,
-	offset, length uint64,
-) {
+// to push out the response. This is the path taken for HTTP/2, where the data
+// is written in chunks, flushing after each one: this lets the stream send
+// frames as its flow control window allows, rather than the whole body being
+// handed to the HTTP/2 server in one write, and means a client that resets
+// the stream is noticed promptly. If limiter is not nil, smaller chunks are
+// written, each once the limiter allows. Writing stops early if ctx is done.
+func (h *Handler) copyfile(ctx context.Context, w io.Writer, p *pack,
+	data *packed.FileData, offset, length uint64, limiter Limiter,
+) (uint64, error) {
+	chunkSize := uint64(copyChunkSize)
+	if limiter != nil {
+		chunkSize = limitChunkSize
+	}
+	flusher, _ := w.(http.Flusher)
 	offset += data.Offset
-	w.Write(h.mapped[offset : offset+length])
+
+	var written uint64
+	for written < length {
+		amt := length - written
+		if amt > chunkSize {
+			amt = chunkSize
+		}
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		if limiter != nil {
+			if err := limiter.Wait(ctx, int(amt)); err != nil {
+				return written, err
+			}
+		}
+		start := offset + written
+		n, err := w.Write(p.mapped[start : start+amt])
+		written += uint64(n)
+		if err != nil {
+			return written, err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return written, nil
 }
 
 func acceptedEncodings(req *http.Request) (gzip, brotli bool) {
@@ -0,0 +1,914 @@
+package htpack
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lwithers/htpack/packed"
+)
+
+// testBody is large enough to need several chunks in copyfile.
+var testBody = bytes.Repeat([]byte("0123456789abcdef"), 40000)
+
+// testPack builds a pack file for tests.
+type testPack struct {
+	t    *testing.T
+	data bytes.Buffer
+	dir  packed.Directory
+}
+
+// testPackHeaderLen is the size of an encoded packed.Header, which has four
+// fixed64 fields. File data starts straight after it.
+const testPackHeaderLen = 36
+
+func newTestPack(t *testing.T) *testPack {
+	return &testPack{
+		t: t,
+		dir: packed.Directory{
+			Files:     make(map[string]*packed.File),
+			Redirects: make(map[string]*packed.Redirect),
+			Rewrites:  make(map[string]string),
+		},
+	}
+}
+
+// fileData appends content to the pack's data, returning its location.
+func (tp *testPack) fileData(content []byte) *packed.FileData {
+	fd := &packed.FileData{
+		Offset: uint64(testPackHeaderLen + tp.data.Len()),
+		Length: uint64(len(content)),
+	}
+	tp.data.Write(content)
+	return fd
+}
+
+// add adds an uncompressed file to the pack, using its content as its etag.
+func (tp *testPack) add(path, contentType, content string) *packed.File {
+	info := &packed.File{
+		ContentType:  contentType,
+		Etag:         fmt.Sprintf("%q", content),
+		Uncompressed: tp.fileData([]byte(content)),
+	}
+	tp.dir.Files[path] = info
+	return info
+}
+
+// write writes the pack to a temporary directory, returning its path.
+func (tp *testPack) write() string {
+	t := tp.t
+	rawDir, err := tp.dir.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := &packed.Header{
+		Magic:           packed.Magic,
+		Version:         packed.VersionInitial,
+		DirectoryOffset: uint64(testPackHeaderLen + tp.data.Len()),
+		DirectoryLength: uint64(len(rawDir)),
+	}
+	rawHdr, err := hdr.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rawHdr) != testPackHeaderLen {
+		t.Fatalf("header is %d bytes", len(rawHdr))
+	}
+
+	var pack bytes.Buffer
+	pack.Write(rawHdr)
+	pack.Write(tp.data.Bytes())
+	pack.Write(rawDir)
+	f, err := os.CreateTemp(t.TempDir(), "*.htpack")
+	if err == nil {
+		_, err = f.Write(pack.Bytes())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+// writeTestPack writes a pack holding "/file.txt" (with a gzip encoding) to a
+// temporary directory, returning its path.
+func writeTestPack(t *testing.T) string {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(testBody)
+	zw.Close()
+
+	tp := newTestPack(t)
+	tp.dir.Files["/file.txt"] = &packed.File{
+		ContentType:  "text/plain",
+		Etag:         `"test"`,
+		Uncompressed: tp.fileData(testBody),
+		Gzip:         tp.fileData(gz.Bytes()),
+	}
+	return tp.write()
+}
+
+// TestServe fetches a file over HTTP/1.1 (where sendfile(2) is used), TLS
+// (where the hijacked connection is written to directly) and HTTP/2 (where
+// the response is copied from memory), checking full, ranged, HEAD and
+// conditional requests.
+func TestServe(t *testing.T) {
+	h, err := New(writeTestPack(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	var (
+		mu     sync.Mutex
+		events []*Event
+	)
+	h.SetLogger(func(ev *Event) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	})
+	lastEvent := func() *Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return events[len(events)-1]
+	}
+
+	servers := []struct {
+		name     string
+		start    func(*httptest.Server)
+		proto    string
+		sendfile bool
+	}{
+		{"http", (*httptest.Server).Start, "HTTP/1.1", true},
+		{"tls", (*httptest.Server).StartTLS, "HTTP/1.1", false},
+		{"h2", func(ts *httptest.Server) {
+			ts.EnableHTTP2 = true
+			ts.StartTLS()
+		}, "HTTP/2.0", false},
+	}
+
+	tests := []struct {
+		name, method string
+		headers      map[string]string
+		status       int
+		body         []byte
+		encoding     string
+		contentRange string
+	}{
+		{
+			name:   "full",
+			method: "GET",
+			status: http.StatusOK,
+			body:   testBody,
+		},
+		{
+			name:     "gzip",
+			method:   "GET",
+			headers:  map[string]string{"Accept-Encoding": "gzip"},
+			status:   http.StatusOK,
+			body:     testBody,
+			encoding: "gzip",
+		},
+		{
+			name:         "range",
+			method:       "GET",
+			headers:      map[string]string{"Range": "bytes=16-47"},
+			status:       http.StatusPartialContent,
+			body:         testBody[16:48],
+			contentRange: fmt.Sprintf("bytes 16-47/%d", len(testBody)),
+		},
+		{
+			name:   "bad range",
+			method: "GET",
+			headers: map[string]string{
+				"Range": fmt.Sprintf("bytes=0-%d", len(testBody)),
+			},
+			status: http.StatusOK,
+			body:   testBody,
+		},
+		{
+			name:     "head",
+			method:   "HEAD",
+			headers:  map[string]string{"Accept-Encoding": "gzip"},
+			status:   http.StatusOK,
+			encoding: "gzip",
+		},
+		{
+			name:    "not modified",
+			method:  "GET",
+			headers: map[string]string{"If-None-Match": `"test"`},
+			status:  http.StatusNotModified,
+		},
+	}
+
+	for _, srv := range servers {
+		ts := httptest.NewUnstartedServer(h)
+		srv.start(ts)
+		client := ts.Client()
+
+		for _, tt := range tests {
+			name := srv.name + " " + tt.name
+			req, err := http.NewRequest(tt.method, ts.URL+"/file.txt",
+				nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// set explicitly, so the transport does not decompress
+			req.Header.Set("Accept-Encoding", "identity")
+			for hkey, hval := range tt.headers {
+				req.Header.Set(hkey, hval)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Errorf("%s: reading body: %v", name, err)
+				continue
+			}
+			ev := lastEvent()
+
+			if resp.Proto != srv.proto {
+				t.Errorf("%s: protocol %s, want %s", name,
+					resp.Proto, srv.proto)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("%s: status %d, want %d", name,
+					resp.StatusCode, tt.status)
+			}
+			if ev.Status != tt.status {
+				t.Errorf("%s: logged status %d, want %d", name,
+					ev.Status, tt.status)
+			}
+			if cr := resp.Header.Get("Content-Range"); cr != tt.contentRange {
+				t.Errorf("%s: Content-Range %q, want %q", name, cr,
+					tt.contentRange)
+			}
+			if ce := resp.Header.Get("Content-Encoding"); ce != tt.encoding {
+				t.Errorf("%s: Content-Encoding %q, want %q", name,
+					ce, tt.encoding)
+			}
+
+			if tt.encoding == "gzip" && tt.method == "GET" {
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				if err == nil {
+					body, err = io.ReadAll(zr)
+				}
+				if err != nil {
+					t.Errorf("%s: decompressing: %v", name, err)
+					continue
+				}
+			}
+			if !bytes.Equal(body, tt.body) {
+				t.Errorf("%s: got %d bytes of body, want %d", name,
+					len(body), len(tt.body))
+			}
+
+			// events record the encoding and transfer method only if
+			// a body was sent
+			wantEncoding, wantSendfile := "", false
+			if tt.method == "GET" && tt.status != http.StatusNotModified {
+				wantEncoding, wantSendfile = tt.encoding, srv.sendfile
+			}
+			if ev.Encoding != wantEncoding {
+				t.Errorf("%s: logged encoding %q, want %q", name,
+					ev.Encoding, wantEncoding)
+			}
+			if ev.Sendfile != wantSendfile {
+				t.Errorf("%s: logged sendfile %v, want %v", name,
+					ev.Sendfile, wantSendfile)
+			}
+			if ev.Err != nil {
+				t.Errorf("%s: logged error %v", name, ev.Err)
+			}
+		}
+		ts.Close()
+	}
+}
+
+// TestServeAbandoned checks that a paced response stops once the client goes
+// away, rather than waiting for its limiter until the whole body is sent.
+func TestServeAbandoned(t *testing.T) {
+	h, err := New(writeTestPack(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	// the first chunk of the body takes about 15 seconds to send
+	h.SetBandwidth(1024, 0)
+	events := make(chan *Event, 1)
+	h.SetLogger(func(ev *Event) {
+		events <- ev
+	})
+
+	servers := []struct {
+		name  string
+		start func(*httptest.Server)
+	}{
+		{"http", (*httptest.Server).Start},
+		{"tls", (*httptest.Server).StartTLS},
+		{"h2", func(ts *httptest.Server) {
+			ts.EnableHTTP2 = true
+			ts.StartTLS()
+		}},
+	}
+	for _, srv := range servers {
+		ts := httptest.NewUnstartedServer(h)
+		srv.start(ts)
+
+		ctx, cancel := context.WithTimeout(context.Background(),
+			100*time.Millisecond)
+		req, err := http.NewRequestWithContext(ctx, "GET",
+			ts.URL+"/file.txt", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", "identity")
+		start := time.Now()
+		if resp, err := ts.Client().Do(req); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+
+		select {
+		case ev := <-events:
+			if ev.Err == nil || ev.Bytes >= uint64(len(testBody)) {
+				t.Errorf("%s: logged %d bytes, error %v", srv.name,
+					ev.Bytes, ev.Err)
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("%s: took %v to stop", srv.name, d)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: response not abandoned", srv.name)
+		}
+		ts.Close()
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	h, err := New(writeTestPack(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/missing", http.StatusNotFound},
+		{"POST", "/file.txt", http.StatusMethodNotAllowed},
+		{"GET", "/./file.txt", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path,
+				w.Code, tt.status)
+		}
+		if tt.status != http.StatusOK &&
+			!strings.HasPrefix(w.Header().Get("Content-Type"),
+				"text/plain") {
+			t.Errorf("%s %s: Content-Type %q", tt.method, tt.path,
+				w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+// routeTest is a request made directly to a handler, and the response
+// expected.
+type routeTest struct {
+	name, method, target string
+	headers              map[string]string
+	status               int
+	body                 string
+
+	// wantHeaders are checked against the response; an empty value
+	// means the header must be absent
+	wantHeaders map[string]string
+}
+
+// checkRoutes makes each request to handler, checking the response.
+func checkRoutes(t *testing.T, handler http.Handler, tests []routeTest) {
+	t.Helper()
+	for _, tt := range tests {
+		method := tt.method
+		if method == "" {
+			method = "GET"
+		}
+		req := httptest.NewRequest(method, tt.target, nil)
+		for hkey, hval := range tt.headers {
+			req.Header.Set(hkey, hval)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code,
+				tt.status)
+		}
+		if body := w.Body.String(); body != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, body, tt.body)
+		}
+		for hkey, want := range tt.wantHeaders {
+			if got := w.Header().Get(hkey); got != want {
+				t.Errorf("%s: %s %q, want %q", tt.name, hkey, got,
+					want)
+			}
+		}
+	}
+}
+
+func TestServeLayers(t *testing.T) {
+	site := newTestPack(t)
+	site.add("/a.txt", "text/plain", "site a")
+	site.add("/sub/index.html", "text/html", "site sub")
+	site.add("/shared/index.html", "text/html", "site shared")
+
+	base := newTestPack(t)
+	base.add("/a.txt", "text/plain", "base a")
+	base.add("/b.txt", "text/plain", "base b")
+	base.add("/other/index.html", "text/html", "base other")
+	base.add("/shared/index.html", "text/html", "base shared")
+	base.add("/index.html", "text/html", "base root")
+
+	h, err := NewLayered(site.write(), base.write())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetIndex("index.html")
+
+	if packs := h.Packs(); len(packs) != 2 || packs[0].Files != 3 ||
+		packs[1].Files != 5 {
+		t.Errorf("Packs() = %+v", packs)
+	}
+
+	checkRoutes(t, h, []routeTest{
+		{name: "shadowed", target: "/a.txt", status: 200,
+			body: "site a"},
+		{name: "later layer", target: "/b.txt", status: 200,
+			body: "base b"},
+		{name: "index in first layer", target: "/sub/", status: 200,
+			body: "site sub"},
+		{name: "index in later layer", target: "/other", status: 200,
+			body: "base other"},
+		{name: "index shadowed", target: "/shared", status: 200,
+			body: "site shared"},
+		{name: "root index", target: "/", status: 200,
+			body: "base root"},
+		{name: "missing", target: "/c.txt", status: 404,
+			body: "404 page not found\n"},
+	})
+
+	if _, err := NewLayered(); err == nil {
+		t.Error("NewLayered with no packs succeeded")
+	}
+	if _, err := NewLayered(site.write(), "/nonexistent.htpack"); err == nil {
+		t.Error("NewLayered with missing pack succeeded")
+	}
+}
+
+func TestServeFallback(t *testing.T) {
+	tp := newTestPack(t)
+	tp.add("/index.html", "text/html", "app")
+	tp.add("/app.js", "text/javascript", "js")
+	h, err := New(tp.write())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	type fallbackTest struct {
+		name   string
+		opts   FallbackOptions
+		target string
+		status int
+		body   string
+	}
+	notFound := "404 page not found\n"
+	tests := []fallbackTest{
+		{"route", FallbackOptions{}, "/users/42", 200, "app"},
+		{"file", FallbackOptions{}, "/missing.js", 200, "app"},
+		{"existing", FallbackOptions{}, "/app.js", 200, "js"},
+		{"exclude files", FallbackOptions{ExcludeFiles: true},
+			"/missing.js", 404, notFound},
+		{"exclude files route", FallbackOptions{ExcludeFiles: true},
+			"/users/42", 200, "app"},
+	}
+	for _, prefix := range []string{"/api/", "/api"} {
+		opts := FallbackOptions{ExcludePrefixes: []string{prefix}}
+		tests = append(tests, []fallbackTest{
+			{prefix + " itself", opts, "/api", 404, notFound},
+			{prefix + " slash", opts, "/api/", 404, notFound},
+			{prefix + " beneath", opts, "/api/x", 404, notFound},
+			{prefix + " similar", opts, "/apiary", 200, "app"},
+		}...)
+	}
+
+	for _, tt := range tests {
+		h.SetFallback("/index.html", tt.opts)
+		checkRoutes(t, h, []routeTest{{
+			name:   tt.name,
+			target: tt.target,
+			status: tt.status,
+			body:   tt.body,
+		}})
+	}
+
+	h.SetFallback("", FallbackOptions{})
+	checkRoutes(t, h, []routeTest{{name: "disabled", target: "/users/42",
+		status: 404, body: notFound}})
+}
+
+func TestServeErrorPage(t *testing.T) {
+	tp := newTestPack(t)
+	tp.add("/404.html", "text/html", "custom not found")
+	h, err := New(tp.write())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetErrorPage(http.StatusNotFound, "/404.html")
+	h.SetErrorPage(http.StatusMethodNotAllowed, "/missing.html")
+
+	errorHeaders := map[string]string{
+		"Content-Type":  "text/html",
+		"Etag":          "",
+		"Accept-Ranges": "",
+		"Content-Range": "",
+	}
+	checkRoutes(t, h, []routeTest{
+		{name: "not found", target: "/nope", status: 404,
+			body: "custom not found", wantHeaders: errorHeaders},
+		{
+			name:   "conditional",
+			target: "/nope",
+			headers: map[string]string{
+				"If-None-Match": `"custom not found"`,
+			},
+			status:      404,
+			body:        "custom not found",
+			wantHeaders: errorHeaders,
+		},
+		{
+			name:        "range",
+			target:      "/nope",
+			headers:     map[string]string{"Range": "bytes=0-5"},
+			status:      404,
+			body:        "custom not found",
+			wantHeaders: errorHeaders,
+		},
+		{name: "page itself", target: "/404.html", status: 200,
+			body: "custom not found",
+			wantHeaders: map[string]string{
+				"Etag": `"custom not found"`,
+			}},
+		{name: "page missing", method: "POST", target: "/404.html",
+			status: 405, body: "method not allowed\n",
+			wantHeaders: map[string]string{
+				"Content-Type": "text/plain; charset=utf-8",
+			}},
+	})
+
+	h.SetErrorPage(http.StatusNotFound, "")
+	checkRoutes(t, h, []routeTest{{name: "removed", target: "/nope",
+		status: 404, body: "404 page not found\n"}})
+}
+
+func TestServeRewritesAndRedirects(t *testing.T) {
+	tp := newTestPack(t)
+	tp.add("/new.html", "text/html", "new page")
+	tp.add("/both", "text/plain", "file wins")
+	tp.dir.Rewrites["/alias"] = "/new.html"
+	tp.dir.Rewrites["/dangling"] = "/absent.html"
+	tp.dir.Redirects["/old"] = &packed.Redirect{
+		Location: "/new.html",
+		Status:   http.StatusMovedPermanently,
+	}
+	tp.dir.Redirects["/ext"] = &packed.Redirect{
+		Location: "https://example.com/x",
+		Status:   http.StatusFound,
+	}
+	tp.dir.Redirects["/cdn"] = &packed.Redirect{
+		Location: "//cdn.example.com/x",
+		Status:   http.StatusTemporaryRedirect,
+	}
+	tp.dir.Redirects["/both"] = &packed.Redirect{
+		Location: "/new.html",
+		Status:   http.StatusFound,
+	}
+	tp.dir.Redirects["/alias"] = &packed.Redirect{
+		Location: "/elsewhere",
+		Status:   http.StatusFound,
+	}
+	h, err := New(tp.write())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetFallback("/new.html", FallbackOptions{})
+
+	tests := []routeTest{
+		{name: "rewrite", target: "/alias", status: 200,
+			body: "new page", wantHeaders: map[string]string{
+				"Location": "",
+				"Etag":     `"new page"`,
+			}},
+		{name: "file over redirect", target: "/both", status: 200,
+			body: "file wins"},
+		{name: "dangling rewrite", target: "/dangling", status: 200,
+			body: "new page"},
+		{name: "redirect", target: "/old", status: 301,
+			wantHeaders: map[string]string{"Location": "/new.html"}},
+		{name: "absolute", target: "/ext", status: 302,
+			wantHeaders: map[string]string{
+				"Location": "https://example.com/x",
+			}},
+		{name: "scheme relative", target: "/cdn", status: 307,
+			wantHeaders: map[string]string{
+				"Location": "//cdn.example.com/x",
+			}},
+	}
+	checkRoutes(t, h, tests)
+
+	// beneath a prefix, local locations are relative to the mount point
+	mux := http.NewServeMux()
+	mux.Handle("/site/", http.StripPrefix("/site", h))
+	checkRoutes(t, mux, []routeTest{
+		{name: "mounted redirect", target: "/site/old", status: 301,
+			wantHeaders: map[string]string{
+				"Location": "/site/new.html",
+			}},
+		{name: "mounted absolute", target: "/site/ext", status: 302,
+			wantHeaders: map[string]string{
+				"Location": "https://example.com/x",
+			}},
+		{name: "mounted scheme relative", target: "/site/cdn",
+			status: 307, wantHeaders: map[string]string{
+				"Location": "//cdn.example.com/x",
+			}},
+		{name: "mounted rewrite", target: "/site/alias", status: 200,
+			body: "new page"},
+	})
+}
+
+func TestMountPrefix(t *testing.T) {
+	tests := []struct {
+		requestURI, path, want string
+	}{
+		{"/x", "/x", ""},
+		{"/site/x?q=1", "/x", "/site"},
+		{"/a/b/x", "/x", "/a/b"},
+		{"/site/", "/", "/site"},
+		{"/other", "/x", ""},
+		{"*", "/x", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RequestURI = tt.requestURI
+		req.URL.Path = tt.path
+		if got := mountPrefix(req); got != tt.want {
+			t.Errorf("mountPrefix(%q, %q) = %q, want %q",
+				tt.requestURI, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestServeHeaders(t *testing.T) {
+	tp := newTestPack(t)
+	tp.add("/plain.txt", "text/plain", "plain")
+	framed := tp.add("/framed.html", "text/html", "framed")
+	framed.Headers = map[string]string{
+		"X-Frame-Options":         "deny",
+		"Content-Security-Policy": "default-src 'self'",
+	}
+	h, err := New(tp.write())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetHeader("X-Site", "example")
+	h.SetHeader("X-Removed", "soon")
+	h.SetHeader("X-Removed", "")
+
+	checkRoutes(t, h, []routeTest{
+		{name: "handler headers", target: "/plain.txt", status: 200,
+			body: "plain", wantHeaders: map[string]string{
+				"X-Frame-Options":         "sameorigin",
+				"X-Content-Type-Options":  "nosniff",
+				"X-Site":                  "example",
+				"X-Removed":               "",
+				"Content-Security-Policy": "",
+			}},
+		{name: "per-file headers", target: "/framed.html",
+			status: 200, body: "framed",
+			wantHeaders: map[string]string{
+				"X-Frame-Options":         "deny",
+				"X-Content-Type-Options":  "nosniff",
+				"X-Site":                  "example",
+				"Content-Security-Policy": "default-src 'self'",
+			}},
+		{name: "per-file headers on 304", target: "/framed.html",
+			headers: map[string]string{
+				"If-None-Match": `"framed"`,
+			},
+			status: 304, wantHeaders: map[string]string{
+				"X-Frame-Options": "deny",
+			}},
+		{name: "error", target: "/missing", status: 404,
+			body: "404 page not found\n",
+			wantHeaders: map[string]string{
+				"X-Frame-Options": "sameorigin",
+				"X-Site":          "example",
+			}},
+	})
+}
+
+func TestServePreloads(t *testing.T) {
+	tp := newTestPack(t)
+	tp.add("/app.css", "text/css", "css")
+	tp.add("/fonts/my font.woff2", "font/woff2", "font")
+	index := tp.add("/index.html", "text/html", "index")
+	index.Preloads = []*packed.Preload{
+		{Path: "/app.css", As: "style"},
+		{Path: "/fonts/my font.woff2", As: "font", Crossorigin: true},
+	}
+	h, err := New(tp.write())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/", h)
+	mux.Handle("/site/", http.StripPrefix("/site", h))
+
+	// the handler hijacks the connection to use sendfile(2), so it may
+	// still be running once the client has the response; wait for it
+	// before changing or closing the handler
+	var inflight sync.WaitGroup
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			inflight.Add(1)
+			defer inflight.Done()
+			mux.ServeHTTP(w, r)
+		}))
+	defer ts.Close()
+	defer inflight.Wait()
+
+	links := func(prefix string) string {
+		return "<" + prefix + "/app.css>; rel=preload; as=style, <" +
+			prefix + "/fonts/my%20font.woff2>; rel=preload; " +
+			"as=font; crossorigin"
+	}
+	tests := []struct {
+		name, method, target string
+		earlyHints           bool
+		headers              map[string]string
+		status               int
+		hints                bool
+		links                string
+	}{
+		{"GET", "GET", "/index.html", true, nil, 200, true, links("")},
+		{"mounted", "GET", "/site/index.html", true, nil, 200, true,
+			links("/site")},
+		{"HEAD", "HEAD", "/index.html", true, nil, 200, false,
+			links("")},
+		{"not modified", "GET", "/index.html", true,
+			map[string]string{"If-None-Match": `"index"`}, 304, false,
+			links("")},
+		{"early hints off", "GET", "/index.html", false, nil, 200,
+			false, links("")},
+		{"no preloads", "GET", "/app.css", true, nil, 200, false, ""},
+	}
+	for _, tt := range tests {
+		inflight.Wait()
+		h.SetEarlyHints(tt.earlyHints)
+
+		var (
+			informational []int
+			hintHeaders   []http.Header
+		)
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, hdr textproto.MIMEHeader,
+			) error {
+				informational = append(informational, code)
+				hintHeaders = append(hintHeaders, http.Header(hdr))
+				return nil
+			},
+		}
+		req, err := http.NewRequest(tt.method, ts.URL+tt.target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(),
+			trace))
+		for hkey, hval := range tt.headers {
+			req.Header.Set(hkey, hval)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name,
+				resp.StatusCode, tt.status)
+		}
+		got := strings.Join(resp.Header.Values("Link"), ", ")
+		if got != tt.links {
+			t.Errorf("%s: Link %q, want %q", tt.name, got, tt.links)
+		}
+
+		switch {
+		case !tt.hints:
+			if len(informational) != 0 {
+				t.Errorf("%s: got informational responses %v",
+					tt.name, informational)
+			}
+		case len(informational) != 1 ||
+			informational[0] != http.StatusEarlyHints:
+			t.Errorf("%s: got informational responses %v, want "+
+				"one 103", tt.name, informational)
+		default:
+			// only the links are sent early
+			hdr := hintHeaders[0]
+			got := strings.Join(hdr.Values("Link"), ", ")
+			if got != tt.links {
+				t.Errorf("%s: 103 Link %q, want %q", tt.name, got,
+					tt.links)
+			}
+			for hkey := range hdr {
+				if hkey != "Link" {
+					t.Errorf("%s: 103 has %s header", tt.name,
+						hkey)
+				}
+			}
+		}
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	site := newTestPack(t)
+	site.add("/app.js", "text/javascript", "js").Integrity = "sha384-site"
+	site.add("/plain.txt", "text/plain", "no integrity")
+	site.dir.Rewrites["/alias.js"] = "/base.js"
+
+	base := newTestPack(t)
+	base.add("/app.js", "text/javascript", "old").Integrity = "sha384-old"
+	base.add("/base.js", "text/javascript", "b").Integrity = "sha384-base"
+	base.dir.Redirects["/moved.js"] = &packed.Redirect{
+		Location: "/app.js",
+		Status:   http.StatusFound,
+	}
+
+	h, err := NewLayered(site.write(), base.write())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetFallback("/app.js", FallbackOptions{})
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/app.js", "sha384-site"},
+		{"/./app.js", "sha384-site"},
+		{"/base.js", "sha384-base"},
+		{"/alias.js", "sha384-base"},
+		{"/plain.txt", ""},
+		{"/moved.js", ""},
+		{"/missing.js", ""},
+	}
+	for _, tt := range tests {
+		if got := h.Integrity(tt.path); got != tt.want {
+			t.Errorf("Integrity(%q) = %q, want %q", tt.path, got,
+				tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package htpack
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limiter paces the sending of response bodies, e.g. to limit the bandwidth
+// used by a client. Wait is called before each chunk of a response body is
+// sent, including when it is sent with sendfile(2), and blocks until n bytes
+// may be sent. It must return early, with an error, if ctx is done (e.g.
+// because the client has gone away); the response is then abandoned. It may
+// be called concurrently from several goroutines.
+type Limiter interface {
+	Wait(ctx context.Context, n int) error
+}
+
+// limitChunkSize is the amount of data sent at a time when a response is
+// paced by a Limiter, which bounds how far a response can run ahead of its
+// limit.
+const limitChunkSize = 16 << 10
+
+// SetLimiter sets a function which returns the Limiter used to pace the body
+// of each response, allowing per-client limits to be applied (for instance,
+// by looking up the client's address, or a value stored in the request's
+// context by middleware). The function may return nil, in which case only the
+// limits set by SetBandwidth apply. Passing nil removes the function.
+func (h *Handler) SetLimiter(limiter func(*http.Request) Limiter) {
+	h.limiter = limiter
+}
+
+// SetBandwidth caps the rate, in bytes per second, at which response bodies
+// are sent: perResponse applies to each response separately, and perHandler
+// to all of the responses being sent by the handler at once. Either may be
+// zero for no limit. Each limit allows a burst of one second's worth of data
+// before pacing starts. These limits apply as well as any set by SetLimiter,
+// so that (for example) large downloads can be kept from saturating the
+// uplink and starving other traffic served by the same process.
+func (h *Handler) SetBandwidth(perResponse, perHandler float64) {
+	h.responseRate = perResponse
+	h.bandwidth = nil
+	if perHandler > 0 {
+		h.bandwidth = NewLimiter(perHandler, 0)
+	}
+}
+
+// responseLimiter returns the Limiter used to pace the body of a response, or
+// nil if it is not limited.
+func (h *Handler) responseLimiter(req *http.Request) Limiter {
+	var limiters multiLimiter
+	if h.responseRate > 0 {
+		limiters = append(limiters, NewLimiter(h.responseRate, 0))
+	}
+	if h.bandwidth != nil {
+		limiters = append(limiters, h.bandwidth)
+	}
+	if h.limiter != nil {
+		if l := h.limiter(req); l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+
+	switch len(limiters) {
+	case 0:
+		return nil
+	case 1:
+		return limiters[0]
+	}
+	return limiters
+}
+
+// multiLimiter waits for each of several Limiters in turn.
+type multiLimiter []Limiter
+
+func (ml multiLimiter) Wait(ctx context.Context, n int) error {
+	for _, l := range ml {
+		if err := l.Wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RateLimiter is a token bucket: tokens accumulate at a fixed rate, up to a
+// maximum (the burst size), and each unit of work takes one token. It
+// implements Limiter, with each byte taking a token.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a RateLimiter which allows rate tokens per second, and
+// holds at most burst tokens (which it starts with). burst is raised to at
+// least one second's worth of tokens if it is smaller.
+func NewLimiter(rate float64, burst int) *RateLimiter {
+	b := math.Max(float64(burst), rate)
+	return &RateLimiter{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens accumulated since the last call. The mutex must be
+// held.
+func (rl *RateLimiter) refill() {
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+}
+
+// Allow takes n tokens and returns true if they are available now. Otherwise,
+// it takes none and returns false, along with how long it will be until they
+// are available.
+func (rl *RateLimiter) Allow(n int) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	if rl.tokens >= float64(n) {
+		rl.tokens -= float64(n)
+		return true, 0
+	}
+	return false, rl.delay(float64(n) - rl.tokens)
+}
+
+// Wait takes n tokens, blocking until they are available. Waiters are not
+// queued: the tokens are taken immediately (leaving the bucket in debt if
+// need be), so later callers wait for earlier ones to be paid off. If ctx is
+// done first, the tokens are returned and its error is returned.
+func (rl *RateLimiter) Wait(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rl.mu.Lock()
+	rl.refill()
+	rl.tokens -= float64(n)
+	var d time.Duration
+	if rl.tokens < 0 {
+		d = rl.delay(-rl.tokens)
+	}
+	rl.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		rl.mu.Lock()
+		rl.tokens += float64(n)
+		rl.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// delay returns the time taken to accumulate the given number of tokens.
+func (rl *RateLimiter) delay(tokens float64) time.Duration {
+	return time.Duration(tokens / rl.rate * float64(time.Second))
+}
@@ -0,0 +1,161 @@
+package htpack
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		rate  float64
+		burst int
+		want  int // requests of one token allowed at once
+	}{
+		{10, 0, 10},  // burst raised to one second's worth
+		{10, 5, 10},  // likewise
+		{10, 25, 25}, // larger burst kept
+		{0.5, 0, 0},  // less than one token per second
+		{0.5, 3, 3},
+	}
+	for _, tt := range tests {
+		rl := NewLimiter(tt.rate, tt.burst)
+		allowed := 0
+		for i := 0; i < 100; i++ {
+			if ok, _ := rl.Allow(1); !ok {
+				break
+			}
+			allowed++
+		}
+		if allowed != tt.want {
+			t.Errorf("NewLimiter(%v, %d): allowed %d, want %d",
+				tt.rate, tt.burst, allowed, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	rl := NewLimiter(10, 20)
+	if ok, _ := rl.Allow(20); !ok {
+		t.Fatal("full burst not allowed")
+	}
+
+	// a denied request takes nothing, and reports how long to wait
+	ok, wait := rl.Allow(5)
+	if ok {
+		t.Fatal("allowed with empty bucket")
+	}
+	if wait < 450*time.Millisecond || wait > 500*time.Millisecond {
+		t.Errorf("wait %v, want about 500ms", wait)
+	}
+
+	// pretend time has passed, rather than sleeping
+	rl.mu.Lock()
+	rl.last = rl.last.Add(-time.Second)
+	rl.mu.Unlock()
+	if ok, _ := rl.Allow(10); !ok {
+		t.Error("not refilled after one second")
+	}
+	if ok, _ := rl.Allow(1); ok {
+		t.Error("refilled by more than one second's worth")
+	}
+
+	// the bucket never holds more than the burst
+	rl.mu.Lock()
+	rl.last = rl.last.Add(-time.Hour)
+	rl.mu.Unlock()
+	if ok, _ := rl.Allow(21); ok {
+		t.Error("refilled beyond the burst")
+	}
+	if ok, _ := rl.Allow(20); !ok {
+		t.Error("not refilled to the burst")
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	ctx := context.Background()
+	rl := NewLimiter(1000, 0)
+
+	// within the burst, no waiting
+	start := time.Now()
+	if err := rl.Wait(ctx, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("waited %v within burst", d)
+	}
+
+	// beyond it, the bucket goes into debt and the caller waits for it
+	// to be paid off
+	start = time.Now()
+	if err := rl.Wait(ctx, 100); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("waited %v, want about 100ms", d)
+	}
+
+	// a later caller waits for the earlier debt too
+	rl.mu.Lock()
+	rl.tokens = -100
+	rl.last = time.Now()
+	rl.mu.Unlock()
+	if ok, wait := rl.Allow(100); ok || wait < 190*time.Millisecond {
+		t.Errorf("Allow after debt = %v, %v; want false, about 200ms",
+			ok, wait)
+	}
+}
+
+func TestRateLimiterWaitCancel(t *testing.T) {
+	rl := NewLimiter(1000, 0)
+	if ok, _ := rl.Allow(1000); !ok {
+		t.Fatal("full burst not allowed")
+	}
+
+	// a wait that would take an hour is abandoned when the context is
+	// done, and its tokens returned
+	ctx, cancel := context.WithTimeout(context.Background(),
+		20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := rl.Wait(ctx, 3600*1000); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("waited %v after context done", d)
+	}
+	if ok, _ := rl.Allow(1); !ok {
+		t.Error("tokens not returned after cancelled wait")
+	}
+
+	// an already cancelled context takes no tokens
+	cancel()
+	rl.mu.Lock()
+	rl.last = rl.last.Add(-time.Second)
+	rl.mu.Unlock()
+	if err := rl.Wait(ctx, 1); err == nil {
+		t.Error("no error with cancelled context")
+	}
+	if ok, _ := rl.Allow(1000); !ok {
+		t.Error("tokens taken with cancelled context")
+	}
+}
+
+func TestMultiLimiterWait(t *testing.T) {
+	fast, slow := NewLimiter(1e6, 0), NewLimiter(1, 0)
+	ml := multiLimiter{fast, slow}
+	if err := ml.Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the slow limiter is empty, so a short deadline expires
+	ctx, cancel := context.WithTimeout(context.Background(),
+		20*time.Millisecond)
+	defer cancel()
+	if err := ml.Wait(ctx, 1); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := ml.Wait(ctx, 1); err != context.DeadlineExceeded {
+		t.Errorf("after deadline: got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package htpack
+
+import (
+	"net/http"
+	"path"
+	"time"
+)
+
+// Event describes a request that has been handled by a Handler. It is passed to
+// the function set with SetLogger once the response has been written, and
+// may be used for access logging or metrics.
+type Event struct {
+	// Request that was handled. Note that if the handler is wrapped by
+	// http.StripPrefix, URL.Path will have had the prefix removed, but
+	// RequestURI is unaltered.
+	Request *http.Request
+
+	// Path that was requested, cleaned of any "." or ".." elements.
+	Path string
+
+	// Status code of the response.
+	Status int
+
+	// Encoding selected for the response body: "gzip", "br", or empty if
+	// the body was sent uncompressed (or not sent at all).
+	Encoding string
+
+	// Bytes of the response body that were sent.
+	Bytes uint64
+
+	// Range is set if the request had a Range header, and Partial is set
+	// if that resulted in a partial (206) response.
+	Range, Partial bool
+
+	// Sendfile is set if the body was sent using sendfile(2). If the body
+	// was sent by copying from memory instead (e.g. over TLS or HTTP/2),
+	// it is false.
+	Sendfile bool
+
+	// Start is the time at which the handler received the request, and
+	// Latency is the time it took to write the full response.
+	Start   time.Time
+	Latency time.Duration
+
+	// Err records any error that occurred while writing the response body.
+	Err error
+}
+
+// SetLogger sets a function that is called with the details of each request
+// once its response has been written. It may be called concurrently from
+// several goroutines. Passing nil disables logging.
+func (h *Handler) SetLogger(logger func(*Event)) {
+	h.logger = logger
+}
+
+// LogError writes a plain text error response with the given status, marked
+// as not to be cached, and passes the details to logger (if not nil). It lets
+// handlers which refuse a request before it reaches a Handler (e.g. for
+// authentication or rate limiting) report it in the same way. Any headers
+// specific to the error, such as WWW-Authenticate, must be set beforehand.
+func LogError(logger func(*Event), w http.ResponseWriter, r *http.Request,
+	status int, msg string,
+) {
+	ev := &Event{
+		Request: r,
+		Path:    path.Clean(r.URL.Path),
+		Start:   time.Now(),
+		Status:  status,
+		Bytes:   uint64(len(msg) + 1), // http.Error appends newline
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, msg, status)
+	if logger != nil {
+		ev.Latency = time.Since(ev.Start)
+		logger(ev)
+	}
+}
@@ -0,0 +1,212 @@
+package htpack
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lwithers/htpack/packed"
+)
+
+// acceptRange is a single element of an Accept-style header, such as
+// "image/webp;q=0.8", split into its value and quality.
+type acceptRange struct {
+	value string
+	q     float64
+}
+
+// parseAccept splits an Accept-style header into its ranges. Parameters other
+// than the quality value are discarded. Malformed quality values are treated
+// as 1.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, elem := range strings.Split(header, ",") {
+		params := strings.Split(elem, ";")
+		r := acceptRange{
+			value: strings.ToLower(strings.TrimSpace(params[0])),
+			q:     1,
+		}
+		if r.value == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				r.q = q
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// mediaTypeQuality returns the quality the client assigns to the given content
+// type, along with the specificity of the range that matched it (2 for an
+// exact match, 1 for "type/*", 0 for "*/*"). The most specific matching range
+// is used. If the client sent no ranges, every type is acceptable.
+func mediaTypeQuality(ranges []acceptRange, contentType string,
+) (q float64, specificity int) {
+	if len(ranges) == 0 {
+		return 1, 0
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(contentType)
+	}
+	majorType := mediaType
+	if pos := strings.IndexByte(mediaType, '/'); pos != -1 {
+		majorType = mediaType[:pos]
+	}
+
+	specificity = -1
+	for _, r := range ranges {
+		var spec int
+		switch {
+		case r.value == mediaType:
+			spec = 2
+		case r.value == majorType+"/*":
+			spec = 1
+		case r.value == "*/*":
+			spec = 0
+		default:
+			continue
+		}
+		if spec > specificity {
+			q, specificity = r.q, spec
+		}
+	}
+	return
+}
+
+// languageQuality returns the quality the client assigns to the given
+// language tag, along with the specificity of the range that matched it (3
+// for an exact match; 2 for a range which is a prefix of the tag, e.g. "de"
+// for "de-CH"; 1 for a tag which is a prefix of the range, e.g. "de-CH" for
+// "de", so that clients receive the closest available language; 0 for "*").
+// The most specific matching range is used. An empty tag matches only "*".
+func languageQuality(ranges []acceptRange, tag string,
+) (q float64, specificity int) {
+	tag = strings.ToLower(tag)
+	specificity = -1
+	for _, r := range ranges {
+		var spec int
+		switch {
+		case tag == "" && r.value != "*":
+			continue
+		case r.value == tag:
+			spec = 3
+		case strings.HasPrefix(tag, r.value+"-"):
+			spec = 2
+		case strings.HasPrefix(r.value, tag+"-"):
+			spec = 1
+		case r.value == "*":
+			spec = 0
+		default:
+			continue
+		}
+		if spec > specificity {
+			q, specificity = r.q, spec
+		}
+	}
+	return
+}
+
+// selectVariant chooses which representation of a file to serve, based on the
+// request's Accept-Language and Accept headers. The language is chosen first,
+// and then the media type from those representations in that language. The
+// file itself is the default representation.
+//
+// Also returns the request headers that the choice depended on, for the Vary
+// header.
+func selectVariant(info *packed.File, req *http.Request,
+) (*packed.File, []string) {
+	if len(info.Variants) == 0 {
+		return info, nil
+	}
+
+	candidates := make([]*packed.File, 0, 1+len(info.Variants))
+	candidates = append(candidates, info)
+	candidates = append(candidates, info.Variants...)
+
+	var vary []string
+	if varies(candidates, func(f *packed.File) string {
+		return f.ContentType
+	}) {
+		vary = append(vary, "Accept")
+	}
+	if varies(candidates, func(f *packed.File) string {
+		return strings.ToLower(f.Language)
+	}) {
+		vary = append(vary, "Accept-Language")
+		candidates = selectLanguage(candidates,
+			parseAccept(req.Header.Get("Accept-Language")))
+	}
+
+	return selectMediaType(candidates,
+		parseAccept(req.Header.Get("Accept"))), vary
+}
+
+// varies returns true if the given property differs between candidates.
+func varies(candidates []*packed.File, prop func(*packed.File) string) bool {
+	for _, f := range candidates[1:] {
+		if prop(f) != prop(candidates[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectLanguage returns the subset of candidates in the language most
+// preferred by the client. Ties in quality are broken first by the specificity
+// of the matching range and then by order. If the client finds no language
+// acceptable (or did not send an Accept-Language header), the language of the
+// first candidate (the default) is used.
+func selectLanguage(candidates []*packed.File, ranges []acceptRange,
+) []*packed.File {
+	best := candidates[0].Language
+	bestQ, bestSpec := languageQuality(ranges, best)
+	for _, f := range candidates[1:] {
+		q, spec := languageQuality(ranges, f.Language)
+		if q > bestQ || (q == bestQ && spec > bestSpec) {
+			best, bestQ, bestSpec = f.Language, q, spec
+		}
+	}
+	if bestQ <= 0 {
+		best = candidates[0].Language
+	}
+
+	var selected []*packed.File
+	for _, f := range candidates {
+		if strings.EqualFold(f.Language, best) {
+			selected = append(selected, f)
+		}
+	}
+	return selected
+}
+
+// selectMediaType chooses the candidate with the media type most preferred by
+// the client. Ties in quality are broken first by the specificity of the
+// matching range (so that a client sending "image/webp, */*" receives a WebP
+// variant in preference to the default JPEG) and then by order. If the client
+// finds no media type acceptable, the first candidate (the default) is
+// returned.
+func selectMediaType(candidates []*packed.File, ranges []acceptRange,
+) *packed.File {
+	best := candidates[0]
+	bestQ, bestSpec := mediaTypeQuality(ranges, best.ContentType)
+	for _, f := range candidates[1:] {
+		q, spec := mediaTypeQuality(ranges, f.ContentType)
+		if q > bestQ || (q == bestQ && spec > bestSpec) {
+			best, bestQ, bestSpec = f, q, spec
+		}
+	}
+	if bestQ <= 0 {
+		best = candidates[0]
+	}
+	return best
+}
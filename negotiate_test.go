@@ -0,0 +1,151 @@
+package htpack
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/htpack/packed"
+)
+
+func TestParseAccept(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []acceptRange
+	}{
+		{"", nil},
+		{"text/html", []acceptRange{{"text/html", 1}}},
+		{
+			"image/webp, image/*;q=0.8, */*;q=0.1",
+			[]acceptRange{
+				{"image/webp", 1},
+				{"image/*", 0.8},
+				{"*/*", 0.1},
+			},
+		},
+		{
+			"DE-ch;q=0.9 , en; level=1; q=0.5,,",
+			[]acceptRange{{"de-ch", 0.9}, {"en", 0.5}},
+		},
+		{"fr;q=bogus", []acceptRange{{"fr", 1}}},
+		{"fr;q=0", []acceptRange{{"fr", 0}}},
+	}
+	for _, tt := range tests {
+		if got := parseAccept(tt.header); !reflect.DeepEqual(got,
+			tt.want) {
+			t.Errorf("parseAccept(%q) = %v, want %v", tt.header, got,
+				tt.want)
+		}
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	jpeg := &packed.File{ContentType: "image/jpeg", Etag: "jpeg"}
+	webp := &packed.File{ContentType: "image/webp", Etag: "webp"}
+	avif := &packed.File{ContentType: "image/avif", Etag: "avif"}
+	image := &packed.File{
+		ContentType: jpeg.ContentType,
+		Etag:        jpeg.Etag,
+		Variants:    []*packed.File{webp, avif},
+	}
+
+	en := &packed.File{ContentType: "text/html", Language: "en",
+		Etag: "en"}
+	de := &packed.File{ContentType: "text/html", Language: "de",
+		Etag: "de"}
+	deCH := &packed.File{ContentType: "text/html", Language: "de-CH",
+		Etag: "de-CH"}
+	ptBR := &packed.File{ContentType: "text/html", Language: "pt-BR",
+		Etag: "pt-BR"}
+	page := &packed.File{
+		ContentType: en.ContentType,
+		Language:    en.Language,
+		Etag:        en.Etag,
+		Variants:    []*packed.File{de, deCH, ptBR},
+	}
+
+	tests := []struct {
+		name         string
+		file         *packed.File
+		accept, lang string
+		wantEtag     string
+		wantVary     string
+	}{
+		{"no variants", jpeg, "image/webp", "", "jpeg", ""},
+		{"no accept", image, "", "", "jpeg", "Accept"},
+		{"webp", image, "image/webp,*/*", "", "webp", "Accept"},
+		{"avif preferred", image, "image/avif,image/webp;q=0.9,*/*;q=0.8",
+			"", "avif", "Accept"},
+		{"wildcard only", image, "*/*", "", "jpeg", "Accept"},
+		{"major wildcard", image, "image/*", "", "jpeg", "Accept"},
+		{"nothing acceptable", image, "text/html", "", "jpeg", "Accept"},
+		{"jpeg refused", image, "image/jpeg;q=0,image/*", "", "webp",
+			"Accept"},
+
+		{"no language", page, "", "", "en", "Accept-Language"},
+		{"exact", page, "", "de", "de", "Accept-Language"},
+		{"region", page, "", "de-CH,de;q=0.5", "de-CH",
+			"Accept-Language"},
+		{"prefix of tag", page, "", "pt", "pt-BR", "Accept-Language"},
+		{"range prefix", page, "", "de-AT", "de", "Accept-Language"},
+		{"quality", page, "", "de;q=0.5,en;q=0.8", "en",
+			"Accept-Language"},
+		{"unavailable", page, "", "fr", "en", "Accept-Language"},
+		{"any", page, "", "fr,*;q=0.1", "en", "Accept-Language"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if tt.lang != "" {
+			req.Header.Set("Accept-Language", tt.lang)
+		}
+		got, vary := selectVariant(tt.file, req)
+		if got.Etag != tt.wantEtag {
+			t.Errorf("%s: selected %s, want %s", tt.name, got.Etag,
+				tt.wantEtag)
+		}
+		if v := strings.Join(vary, ", "); v != tt.wantVary {
+			t.Errorf("%s: vary %q, want %q", tt.name, v, tt.wantVary)
+		}
+	}
+}
+
+func TestSelectVariantLanguageThenType(t *testing.T) {
+	enPNG := &packed.File{ContentType: "image/png", Language: "en",
+		Etag: "en-png"}
+	enWebP := &packed.File{ContentType: "image/webp", Language: "en",
+		Etag: "en-webp"}
+	dePNG := &packed.File{ContentType: "image/png", Language: "de",
+		Etag: "de-png"}
+	file := &packed.File{
+		ContentType: enPNG.ContentType,
+		Language:    enPNG.Language,
+		Etag:        enPNG.Etag,
+		Variants:    []*packed.File{enWebP, dePNG},
+	}
+
+	tests := []struct {
+		accept, lang, want string
+	}{
+		{"image/webp,*/*", "en", "en-webp"},
+		{"image/webp,*/*", "de", "de-png"},
+		{"", "de", "de-png"},
+		{"", "", "en-png"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", tt.accept)
+		req.Header.Set("Accept-Language", tt.lang)
+		got, vary := selectVariant(file, req)
+		if got.Etag != tt.want {
+			t.Errorf("Accept %q, Accept-Language %q: selected %s, "+
+				"want %s", tt.accept, tt.lang, got.Etag, tt.want)
+		}
+		if v := strings.Join(vary, ", "); v != "Accept, Accept-Language" {
+			t.Errorf("vary %q", v)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package packed
 
 import (
+	"errors"
 	fmt "fmt"
 	"os"
 	"path"
@@ -116,7 +117,7 @@ func loadDirectory(f *os.File, hdr *Header) (*Directory, error) {
 }
 
 // checkDirectory verifies the consistency of the htpack file (offsets,
-// filenames). It does not verify integrity (checksums).
+// filenames, redirects). It does not verify integrity (checksums).
 func checkDirectory(dir *Directory, fileSize uint64) error {
 	files := map[string]struct{}{}
 
@@ -129,11 +130,8 @@ func checkDirectory(dir *Directory, fileSize uint64) error {
 		}
 		files[filename] = struct{}{}
 
-		if !path.IsAbs(filename) {
-			err = fmt.Errorf("relative path %q", filename)
-		}
-		if path.Clean(filename) != filename {
-			err = fmt.Errorf("non-canonical path %q", filename)
+		if perr := checkPath(filename); perr != nil {
+			err = perr
 		}
 		if err != nil {
 			return &LoadError{
@@ -143,21 +141,61 @@ func checkDirectory(dir *Directory, fileSize uint64) error {
 			}
 		}
 
-		// ensure uncompressed data is present
-		if info.Uncompressed == nil {
+		if err = checkFileData(filename, info, fileSize); err != nil {
+			return err
+		}
+		for _, variant := range info.Variants {
+			if len(variant.Variants) != 0 {
+				return &LoadError{
+					Cause: NestedVariants,
+					Path:  filename,
+				}
+			}
+			if err = checkFileData(filename, variant, fileSize); err != nil {
+				return err
+			}
+		}
+	}
+
+	for filename, redir := range dir.Redirects {
+		if err := checkPath(filename); err != nil {
+			return &LoadError{
+				Cause:      InvalidPath,
+				Underlying: err,
+				Path:       filename,
+			}
+		}
+
+		switch redir.GetStatus() {
+		case 301, 302, 307, 308:
+			// OK
+		default:
 			return &LoadError{
-				Cause: MissingUncompressed,
-				Path:  filename,
+				Cause: InvalidRedirect,
+				Underlying: fmt.Errorf("invalid status %d",
+					redir.GetStatus()),
+				Path: filename,
+			}
+		}
+		if redir.GetLocation() == "" {
+			return &LoadError{
+				Cause:      InvalidRedirect,
+				Underlying: errors.New("missing location"),
+				Path:       filename,
 			}
 		}
+	}
 
-		// validate offsets
-		checkOffset(&err, filename, info.Uncompressed, fileSize)
-		checkOffset(&err, filename, info.Gzip, fileSize)
-		checkOffset(&err, filename, info.Brotli, fileSize)
+	for filename, target := range dir.Rewrites {
+		err := checkPath(filename)
+		if err == nil {
+			err = checkPath(target)
+		}
 		if err != nil {
 			return &LoadError{
-				Cause: BadOffsetError,
+				Cause:      InvalidPath,
+				Underlying: err,
+				Path:       filename,
 			}
 		}
 	}
@@ -165,6 +203,36 @@ func checkDirectory(dir *Directory, fileSize uint64) error {
 	return nil
 }
 
+// checkFileData ensures that a file has uncompressed data present, and that all
+// of its data lies within the pack.
+func checkFileData(filename string, info *File, fileSize uint64) error {
+	// ensure uncompressed data is present
+	if info.Uncompressed == nil {
+		return &LoadError{
+			Cause: MissingUncompressed,
+			Path:  filename,
+		}
+	}
+
+	// validate offsets
+	var err error
+	checkOffset(&err, filename, info.Uncompressed, fileSize)
+	checkOffset(&err, filename, info.Gzip, fileSize)
+	checkOffset(&err, filename, info.Brotli, fileSize)
+	return err
+}
+
+// checkPath ensures that a path is absolute and canonical.
+func checkPath(filename string) error {
+	if !path.IsAbs(filename) {
+		return fmt.Errorf("relative path %q", filename)
+	}
+	if path.Clean(filename) != filename {
+		return fmt.Errorf("non-canonical path %q", filename)
+	}
+	return nil
+}
+
 func checkOffset(perr *error, filename string, data *FileData, fileSize uint64) {
 	if *perr != nil || data == nil {
 		return
@@ -234,6 +302,15 @@ const (
 	// MissingUncompressed indicates that a file in the pack does not have
 	// an uncompressed version present, which is mandatory.
 	MissingUncompressed
+
+	// InvalidRedirect is returned for a redirect with a missing location
+	// or an unsupported status code. Underlying is set to a free-form
+	// string error describing the problem.
+	InvalidRedirect
+
+	// NestedVariants indicates that a variant of a file in the pack has
+	// variants of its own, which is not permitted.
+	NestedVariants
 )
 
 // Desc returns a description of the error cause.
@@ -257,6 +334,10 @@ func (le *LoadError) Desc() string {
 		return "filename invalid"
 	case MissingUncompressed:
 		return "missing uncompressed version"
+	case InvalidRedirect:
+		return "redirect invalid"
+	case NestedVariants:
+		return "variant has nested variants"
 	default:
 		return "unknown error"
 	}
@@ -278,8 +359,10 @@ func (le *LoadError) Error() string {
 		version = true
 	case BadOffsetError:
 		path = le.Path != ""
-	case InvalidPath, MissingUncompressed:
+	case InvalidPath, MissingUncompressed, NestedVariants:
 		path = true
+	case InvalidRedirect:
+		underlying, path = true, true
 	}
 
 	if underlying {
@@ -0,0 +1,196 @@
+package packed
+
+import "testing"
+
+func TestCheckPath(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"/", true},
+		{"/index.html", true},
+		{"/a/b/c.js", true},
+		{"", false},
+		{"index.html", false},
+		{"/a/../b", false},
+		{"/a/./b", false},
+		{"/a//b", false},
+		{"/dir/", false},
+	}
+	for _, tt := range tests {
+		if err := checkPath(tt.path); (err == nil) != tt.ok {
+			t.Errorf("checkPath(%q) = %v, want ok=%v", tt.path, err,
+				tt.ok)
+		}
+	}
+}
+
+func TestCheckFileData(t *testing.T) {
+	const fileSize = 1000
+	tests := []struct {
+		name  string
+		info  *File
+		cause ErrorCause // -1 for no error
+	}{
+		{
+			name:  "ok",
+			info:  &File{Uncompressed: &FileData{Offset: 0, Length: 1000}},
+			cause: -1,
+		},
+		{
+			name: "compressed ok",
+			info: &File{
+				Uncompressed: &FileData{Offset: 0, Length: 500},
+				Gzip:         &FileData{Offset: 500, Length: 300},
+				Brotli:       &FileData{Offset: 800, Length: 200},
+			},
+			cause: -1,
+		},
+		{
+			name:  "missing uncompressed",
+			info:  &File{Gzip: &FileData{Offset: 0, Length: 10}},
+			cause: MissingUncompressed,
+		},
+		{
+			name:  "uncompressed past end",
+			info:  &File{Uncompressed: &FileData{Offset: 1, Length: 1000}},
+			cause: BadOffsetError,
+		},
+		{
+			name: "brotli past end",
+			info: &File{
+				Uncompressed: &FileData{Offset: 0, Length: 10},
+				Brotli:       &FileData{Offset: 995, Length: 10},
+			},
+			cause: BadOffsetError,
+		},
+	}
+	for _, tt := range tests {
+		err := checkFileData("/f", tt.info, fileSize)
+		if tt.cause == -1 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		le, ok := err.(*LoadError)
+		if !ok {
+			t.Errorf("%s: got %v, want LoadError", tt.name, err)
+			continue
+		}
+		if le.Cause != tt.cause || le.Path != "/f" {
+			t.Errorf("%s: got cause %d path %q, want cause %d "+
+				"path /f", tt.name, le.Cause, le.Path, tt.cause)
+		}
+	}
+}
+
+func TestCheckDirectory(t *testing.T) {
+	const fileSize = 100
+	file := func() *File {
+		return &File{Uncompressed: &FileData{Offset: 0, Length: 10}}
+	}
+	tests := []struct {
+		name  string
+		dir   *Directory
+		cause ErrorCause // -1 for no error
+		path  string
+	}{
+		{
+			name: "ok",
+			dir: &Directory{
+				Files: map[string]*File{"/a": file(), "/b": file()},
+				Redirects: map[string]*Redirect{
+					"/old": {Location: "/a", Status: 301},
+				},
+				Rewrites: map[string]string{"/c": "/a"},
+			},
+			cause: -1,
+		},
+		{
+			name: "relative file path",
+			dir: &Directory{
+				Files: map[string]*File{"a": file()},
+			},
+			cause: InvalidPath,
+			path:  "a",
+		},
+		{
+			name: "nested variants",
+			dir: &Directory{
+				Files: map[string]*File{"/a": {
+					Uncompressed: &FileData{Length: 10},
+					Variants: []*File{{
+						Uncompressed: &FileData{Length: 10},
+						Variants:     []*File{file()},
+					}},
+				}},
+			},
+			cause: NestedVariants,
+			path:  "/a",
+		},
+		{
+			name: "variant past end",
+			dir: &Directory{
+				Files: map[string]*File{"/a": {
+					Uncompressed: &FileData{Length: 10},
+					Variants: []*File{{
+						Uncompressed: &FileData{
+							Offset: 95,
+							Length: 10,
+						},
+					}},
+				}},
+			},
+			cause: BadOffsetError,
+			path:  "/a",
+		},
+		{
+			name: "redirect status",
+			dir: &Directory{
+				Redirects: map[string]*Redirect{
+					"/old": {Location: "/a", Status: 200},
+				},
+			},
+			cause: InvalidRedirect,
+			path:  "/old",
+		},
+		{
+			name: "redirect location",
+			dir: &Directory{
+				Redirects: map[string]*Redirect{
+					"/old": {Status: 302},
+				},
+			},
+			cause: InvalidRedirect,
+			path:  "/old",
+		},
+		{
+			name: "rewrite target",
+			dir: &Directory{
+				Rewrites: map[string]string{"/c": "a/../b"},
+			},
+			cause: InvalidPath,
+			path:  "/c",
+		},
+	}
+	for _, tt := range tests {
+		err := checkDirectory(tt.dir, fileSize)
+		if tt.cause == -1 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		le, ok := err.(*LoadError)
+		if !ok {
+			t.Errorf("%s: got %v, want LoadError", tt.name, err)
+			continue
+		}
+		if le.Cause != tt.cause || le.Path != tt.path {
+			t.Errorf("%s: got cause %d path %q, want cause %d "+
+				"path %q", tt.name, le.Cause, le.Path, tt.cause,
+				tt.path)
+		}
+	}
+}
@@ -10,7 +10,9 @@
 	It has these top-level messages:
 		Header
 		Directory
+		Redirect
 		File
+		Preload
 		FileData
 */
 package packed
@@ -19,6 +21,8 @@ import proto "github.com/gogo/protobuf/proto"
 import fmt "fmt"
 import math "math"
 
+import binary "encoding/binary"
+
 import io "io"
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -32,7 +36,8 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.GoGoProtoPackageIsVersion2 // please upgrade the proto package
 
-// Header at start of file. This must be a fixed, known size.
+// Header at start of file. This must be a fixed, known size. Fields cannot
+// be zero.
 type Header struct {
 	// Magic number, used to quickly detect misconfigured systems or
 	// corrupted files.
@@ -85,6 +90,14 @@ type Directory struct {
 	// Files available within this pack. The key is the path of the URL to
 	// serve, and the value describes the file associated with that path.
 	Files map[string]*File `protobuf:"bytes,1,rep,name=files" json:"files,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value"`
+	// Redirects tell the client to fetch a resource from another URL. The
+	// key is the path of the URL that was requested, and the value
+	// describes where to send the client instead.
+	Redirects map[string]*Redirect `protobuf:"bytes,2,rep,name=redirects" json:"redirects,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value"`
+	// Rewrites serve the File of another path, without the client being
+	// aware. The key is the path of the URL that was requested, and the
+	// value is the path of the file to serve in its place.
+	Rewrites map[string]string `protobuf:"bytes,3,rep,name=rewrites" json:"rewrites,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 }
 
 func (m *Directory) Reset()                    { *m = Directory{} }
@@ -99,6 +112,50 @@ func (m *Directory) GetFiles() map[string]*File {
 	return nil
 }
 
+func (m *Directory) GetRedirects() map[string]*Redirect {
+	if m != nil {
+		return m.Redirects
+	}
+	return nil
+}
+
+func (m *Directory) GetRewrites() map[string]string {
+	if m != nil {
+		return m.Rewrites
+	}
+	return nil
+}
+
+// Redirect to another URL.
+type Redirect struct {
+	// Location to redirect to, copied into the "Location" header. If this
+	// is an absolute path, it is interpreted relative to the point at
+	// which the pack is being served.
+	Location string `protobuf:"bytes,1,opt,name=location,proto3" json:"location,omitempty"`
+	// Status code of the response, which must be one of 301, 302, 307 or
+	// 308.
+	Status uint32 `protobuf:"varint,2,opt,name=status,proto3" json:"status,omitempty"`
+}
+
+func (m *Redirect) Reset()                    { *m = Redirect{} }
+func (m *Redirect) String() string            { return proto.CompactTextString(m) }
+func (*Redirect) ProtoMessage()               {}
+func (*Redirect) Descriptor() ([]byte, []int) { return fileDescriptorPacked, []int{2} }
+
+func (m *Redirect) GetLocation() string {
+	if m != nil {
+		return m.Location
+	}
+	return ""
+}
+
+func (m *Redirect) GetStatus() uint32 {
+	if m != nil {
+		return m.Status
+	}
+	return 0
+}
+
 // File that can be served.
 type File struct {
 	// ContentType of the file, copied directly into the "Content-Type" header.
@@ -113,12 +170,36 @@ type File struct {
 	Gzip *FileData `protobuf:"bytes,4,opt,name=gzip" json:"gzip,omitempty"`
 	// Brotli compressed version of the file.
 	Brotli *FileData `protobuf:"bytes,5,opt,name=brotli" json:"brotli,omitempty"`
+	// Headers to emit when serving the file (e.g. "Content-Disposition").
+	// These take precedence over headers set globally on the handler, but
+	// not over the standard headers that describe the response (such as
+	// "Content-Type" or "Etag").
+	Headers map[string]string `protobuf:"bytes,6,rep,name=headers" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Integrity of the uncompressed file, in the form used by the HTML
+	// "integrity" attribute for Subresource Integrity (e.g. "sha384-…").
+	Integrity string `protobuf:"bytes,7,opt,name=integrity,proto3" json:"integrity,omitempty"`
+	// Variants are alternative representations of the file (for instance,
+	// the same image in other formats, or the same page in other
+	// languages), from which one is chosen by content negotiation on the
+	// Accept and Accept-Language headers. Each variant has its own
+	// content type, Etag and data, but may not itself have variants. The
+	// File holding the variants is the default representation.
+	Variants []*File `protobuf:"bytes,8,rep,name=variants" json:"variants,omitempty"`
+	// Language of the file, as a BCP 47 language tag (e.g. "en" or
+	// "de-CH"), copied directly into the "Content-Language" header. May be
+	// empty if the file is not language-specific.
+	Language string `protobuf:"bytes,9,opt,name=language,proto3" json:"language,omitempty"`
+	// Preloads are resources referenced by the file (an HTML document)
+	// which the client will need in order to render it. They are sent as
+	// "Link: <…>; rel=preload" headers, and optionally as 103 Early Hints,
+	// so that the client can start fetching them sooner.
+	Preloads []*Preload `protobuf:"bytes,10,rep,name=preloads" json:"preloads,omitempty"`
 }
 
 func (m *File) Reset()                    { *m = File{} }
 func (m *File) String() string            { return proto.CompactTextString(m) }
 func (*File) ProtoMessage()               {}
-func (*File) Descriptor() ([]byte, []int) { return fileDescriptorPacked, []int{2} }
+func (*File) Descriptor() ([]byte, []int) { return fileDescriptorPacked, []int{3} }
 
 func (m *File) GetContentType() string {
 	if m != nil {
@@ -155,6 +236,82 @@ func (m *File) GetBrotli() *FileData {
 	return nil
 }
 
+func (m *File) GetHeaders() map[string]string {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *File) GetIntegrity() string {
+	if m != nil {
+		return m.Integrity
+	}
+	return ""
+}
+
+func (m *File) GetVariants() []*File {
+	if m != nil {
+		return m.Variants
+	}
+	return nil
+}
+
+func (m *File) GetLanguage() string {
+	if m != nil {
+		return m.Language
+	}
+	return ""
+}
+
+func (m *File) GetPreloads() []*Preload {
+	if m != nil {
+		return m.Preloads
+	}
+	return nil
+}
+
+// Preload describes a resource which the client should fetch early.
+type Preload struct {
+	// Path of the resource within the pack. If this is an absolute path, it
+	// is interpreted relative to the point at which the pack is being
+	// served.
+	Path string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	// As is the type of the resource, as used in the "as" attribute of
+	// the link (e.g. "style", "script" or "font").
+	As string `protobuf:"bytes,2,opt,name=as,proto3" json:"as,omitempty"`
+	// Crossorigin is set if the resource will be fetched in CORS mode (as
+	// fonts and module scripts are), in which case the link must carry the
+	// "crossorigin" attribute for the preloaded response to be used.
+	Crossorigin bool `protobuf:"varint,3,opt,name=crossorigin,proto3" json:"crossorigin,omitempty"`
+}
+
+func (m *Preload) Reset()                    { *m = Preload{} }
+func (m *Preload) String() string            { return proto.CompactTextString(m) }
+func (*Preload) ProtoMessage()               {}
+func (*Preload) Descriptor() ([]byte, []int) { return fileDescriptorPacked, []int{4} }
+
+func (m *Preload) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *Preload) GetAs() string {
+	if m != nil {
+		return m.As
+	}
+	return ""
+}
+
+func (m *Preload) GetCrossorigin() bool {
+	if m != nil {
+		return m.Crossorigin
+	}
+	return false
+}
+
 // FileData records the position of the file data within the pack.
 type FileData struct {
 	// Offset is the start of the file, in bytes relative to the start of
@@ -167,7 +324,7 @@ type FileData struct {
 func (m *FileData) Reset()                    { *m = FileData{} }
 func (m *FileData) String() string            { return proto.CompactTextString(m) }
 func (*FileData) ProtoMessage()               {}
-func (*FileData) Descriptor() ([]byte, []int) { return fileDescriptorPacked, []int{3} }
+func (*FileData) Descriptor() ([]byte, []int) { return fileDescriptorPacked, []int{5} }
 
 func (m *FileData) GetOffset() uint64 {
 	if m != nil {
@@ -186,7 +343,9 @@ func (m *FileData) GetLength() uint64 {
 func init() {
 	proto.RegisterType((*Header)(nil), "packed.Header")
 	proto.RegisterType((*Directory)(nil), "packed.Directory")
+	proto.RegisterType((*Redirect)(nil), "packed.Redirect")
 	proto.RegisterType((*File)(nil), "packed.File")
+	proto.RegisterType((*Preload)(nil), "packed.Preload")
 	proto.RegisterType((*FileData)(nil), "packed.FileData")
 }
 func (m *Header) Marshal() (dAtA []byte, err error) {
@@ -207,22 +366,26 @@ func (m *Header) MarshalTo(dAtA []byte) (int, error) {
 	if m.Magic != 0 {
 		dAtA[i] = 0x9
 		i++
-		i = encodeFixed64Packed(dAtA, i, uint64(m.Magic))
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(m.Magic))
+		i += 8
 	}
 	if m.Version != 0 {
 		dAtA[i] = 0x11
 		i++
-		i = encodeFixed64Packed(dAtA, i, uint64(m.Version))
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(m.Version))
+		i += 8
 	}
 	if m.DirectoryOffset != 0 {
 		dAtA[i] = 0x19
 		i++
-		i = encodeFixed64Packed(dAtA, i, uint64(m.DirectoryOffset))
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(m.DirectoryOffset))
+		i += 8
 	}
 	if m.DirectoryLength != 0 {
 		dAtA[i] = 0x21
 		i++
-		i = encodeFixed64Packed(dAtA, i, uint64(m.DirectoryLength))
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(m.DirectoryLength))
+		i += 8
 	}
 	return i, nil
 }
@@ -270,6 +433,80 @@ func (m *Directory) MarshalTo(dAtA []byte) (int, error) {
 			}
 		}
 	}
+	if len(m.Redirects) > 0 {
+		for k, _ := range m.Redirects {
+			dAtA[i] = 0x12
+			i++
+			v := m.Redirects[k]
+			msgSize := 0
+			if v != nil {
+				msgSize = v.Size()
+				msgSize += 1 + sovPacked(uint64(msgSize))
+			}
+			mapSize := 1 + len(k) + sovPacked(uint64(len(k))) + msgSize
+			i = encodeVarintPacked(dAtA, i, uint64(mapSize))
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintPacked(dAtA, i, uint64(len(k)))
+			i += copy(dAtA[i:], k)
+			if v != nil {
+				dAtA[i] = 0x12
+				i++
+				i = encodeVarintPacked(dAtA, i, uint64(v.Size()))
+				n2, err := v.MarshalTo(dAtA[i:])
+				if err != nil {
+					return 0, err
+				}
+				i += n2
+			}
+		}
+	}
+	if len(m.Rewrites) > 0 {
+		for k, _ := range m.Rewrites {
+			dAtA[i] = 0x1a
+			i++
+			v := m.Rewrites[k]
+			mapSize := 1 + len(k) + sovPacked(uint64(len(k))) + 1 + len(v) + sovPacked(uint64(len(v)))
+			i = encodeVarintPacked(dAtA, i, uint64(mapSize))
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintPacked(dAtA, i, uint64(len(k)))
+			i += copy(dAtA[i:], k)
+			dAtA[i] = 0x12
+			i++
+			i = encodeVarintPacked(dAtA, i, uint64(len(v)))
+			i += copy(dAtA[i:], v)
+		}
+	}
+	return i, nil
+}
+
+func (m *Redirect) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Redirect) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Location) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintPacked(dAtA, i, uint64(len(m.Location)))
+		i += copy(dAtA[i:], m.Location)
+	}
+	if m.Status != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintPacked(dAtA, i, uint64(m.Status))
+	}
 	return i, nil
 }
 
@@ -304,31 +541,124 @@ func (m *File) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0x1a
 		i++
 		i = encodeVarintPacked(dAtA, i, uint64(m.Uncompressed.Size()))
-		n2, err := m.Uncompressed.MarshalTo(dAtA[i:])
+		n3, err := m.Uncompressed.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n2
+		i += n3
 	}
 	if m.Gzip != nil {
 		dAtA[i] = 0x22
 		i++
 		i = encodeVarintPacked(dAtA, i, uint64(m.Gzip.Size()))
-		n3, err := m.Gzip.MarshalTo(dAtA[i:])
+		n4, err := m.Gzip.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n3
+		i += n4
 	}
 	if m.Brotli != nil {
 		dAtA[i] = 0x2a
 		i++
 		i = encodeVarintPacked(dAtA, i, uint64(m.Brotli.Size()))
-		n4, err := m.Brotli.MarshalTo(dAtA[i:])
+		n5, err := m.Brotli.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n4
+		i += n5
+	}
+	if len(m.Headers) > 0 {
+		for k, _ := range m.Headers {
+			dAtA[i] = 0x32
+			i++
+			v := m.Headers[k]
+			mapSize := 1 + len(k) + sovPacked(uint64(len(k))) + 1 + len(v) + sovPacked(uint64(len(v)))
+			i = encodeVarintPacked(dAtA, i, uint64(mapSize))
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintPacked(dAtA, i, uint64(len(k)))
+			i += copy(dAtA[i:], k)
+			dAtA[i] = 0x12
+			i++
+			i = encodeVarintPacked(dAtA, i, uint64(len(v)))
+			i += copy(dAtA[i:], v)
+		}
+	}
+	if len(m.Integrity) > 0 {
+		dAtA[i] = 0x3a
+		i++
+		i = encodeVarintPacked(dAtA, i, uint64(len(m.Integrity)))
+		i += copy(dAtA[i:], m.Integrity)
+	}
+	if len(m.Variants) > 0 {
+		for _, msg := range m.Variants {
+			dAtA[i] = 0x42
+			i++
+			i = encodeVarintPacked(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.Language) > 0 {
+		dAtA[i] = 0x4a
+		i++
+		i = encodeVarintPacked(dAtA, i, uint64(len(m.Language)))
+		i += copy(dAtA[i:], m.Language)
+	}
+	if len(m.Preloads) > 0 {
+		for _, msg := range m.Preloads {
+			dAtA[i] = 0x52
+			i++
+			i = encodeVarintPacked(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func (m *Preload) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Preload) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Path) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintPacked(dAtA, i, uint64(len(m.Path)))
+		i += copy(dAtA[i:], m.Path)
+	}
+	if len(m.As) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintPacked(dAtA, i, uint64(len(m.As)))
+		i += copy(dAtA[i:], m.As)
+	}
+	if m.Crossorigin {
+		dAtA[i] = 0x18
+		i++
+		if m.Crossorigin {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
 	}
 	return i, nil
 }
@@ -351,34 +681,18 @@ func (m *FileData) MarshalTo(dAtA []byte) (int, error) {
 	if m.Offset != 0 {
 		dAtA[i] = 0x9
 		i++
-		i = encodeFixed64Packed(dAtA, i, uint64(m.Offset))
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(m.Offset))
+		i += 8
 	}
 	if m.Length != 0 {
 		dAtA[i] = 0x11
 		i++
-		i = encodeFixed64Packed(dAtA, i, uint64(m.Length))
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(m.Length))
+		i += 8
 	}
 	return i, nil
 }
 
-func encodeFixed64Packed(dAtA []byte, offset int, v uint64) int {
-	dAtA[offset] = uint8(v)
-	dAtA[offset+1] = uint8(v >> 8)
-	dAtA[offset+2] = uint8(v >> 16)
-	dAtA[offset+3] = uint8(v >> 24)
-	dAtA[offset+4] = uint8(v >> 32)
-	dAtA[offset+5] = uint8(v >> 40)
-	dAtA[offset+6] = uint8(v >> 48)
-	dAtA[offset+7] = uint8(v >> 56)
-	return offset + 8
-}
-func encodeFixed32Packed(dAtA []byte, offset int, v uint32) int {
-	dAtA[offset] = uint8(v)
-	dAtA[offset+1] = uint8(v >> 8)
-	dAtA[offset+2] = uint8(v >> 16)
-	dAtA[offset+3] = uint8(v >> 24)
-	return offset + 4
-}
 func encodeVarintPacked(dAtA []byte, offset int, v uint64) int {
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
@@ -422,6 +736,40 @@ func (m *Directory) Size() (n int) {
 			n += mapEntrySize + 1 + sovPacked(uint64(mapEntrySize))
 		}
 	}
+	if len(m.Redirects) > 0 {
+		for k, v := range m.Redirects {
+			_ = k
+			_ = v
+			l = 0
+			if v != nil {
+				l = v.Size()
+				l += 1 + sovPacked(uint64(l))
+			}
+			mapEntrySize := 1 + len(k) + sovPacked(uint64(len(k))) + l
+			n += mapEntrySize + 1 + sovPacked(uint64(mapEntrySize))
+		}
+	}
+	if len(m.Rewrites) > 0 {
+		for k, v := range m.Rewrites {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovPacked(uint64(len(k))) + 1 + len(v) + sovPacked(uint64(len(v)))
+			n += mapEntrySize + 1 + sovPacked(uint64(mapEntrySize))
+		}
+	}
+	return n
+}
+
+func (m *Redirect) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Location)
+	if l > 0 {
+		n += 1 + l + sovPacked(uint64(l))
+	}
+	if m.Status != 0 {
+		n += 1 + sovPacked(uint64(m.Status))
+	}
 	return n
 }
 
@@ -448,6 +796,51 @@ func (m *File) Size() (n int) {
 		l = m.Brotli.Size()
 		n += 1 + l + sovPacked(uint64(l))
 	}
+	if len(m.Headers) > 0 {
+		for k, v := range m.Headers {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovPacked(uint64(len(k))) + 1 + len(v) + sovPacked(uint64(len(v)))
+			n += mapEntrySize + 1 + sovPacked(uint64(mapEntrySize))
+		}
+	}
+	l = len(m.Integrity)
+	if l > 0 {
+		n += 1 + l + sovPacked(uint64(l))
+	}
+	if len(m.Variants) > 0 {
+		for _, e := range m.Variants {
+			l = e.Size()
+			n += 1 + l + sovPacked(uint64(l))
+		}
+	}
+	l = len(m.Language)
+	if l > 0 {
+		n += 1 + l + sovPacked(uint64(l))
+	}
+	if len(m.Preloads) > 0 {
+		for _, e := range m.Preloads {
+			l = e.Size()
+			n += 1 + l + sovPacked(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *Preload) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Path)
+	if l > 0 {
+		n += 1 + l + sovPacked(uint64(l))
+	}
+	l = len(m.As)
+	if l > 0 {
+		n += 1 + l + sovPacked(uint64(l))
+	}
+	if m.Crossorigin {
+		n += 2
+	}
 	return n
 }
 
@@ -513,15 +906,8 @@ func (m *Header) Unmarshal(dAtA []byte) error {
 			if (iNdEx + 8) > l {
 				return io.ErrUnexpectedEOF
 			}
+			m.Magic = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
 			iNdEx += 8
-			m.Magic = uint64(dAtA[iNdEx-8])
-			m.Magic |= uint64(dAtA[iNdEx-7]) << 8
-			m.Magic |= uint64(dAtA[iNdEx-6]) << 16
-			m.Magic |= uint64(dAtA[iNdEx-5]) << 24
-			m.Magic |= uint64(dAtA[iNdEx-4]) << 32
-			m.Magic |= uint64(dAtA[iNdEx-3]) << 40
-			m.Magic |= uint64(dAtA[iNdEx-2]) << 48
-			m.Magic |= uint64(dAtA[iNdEx-1]) << 56
 		case 2:
 			if wireType != 1 {
 				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
@@ -530,15 +916,8 @@ func (m *Header) Unmarshal(dAtA []byte) error {
 			if (iNdEx + 8) > l {
 				return io.ErrUnexpectedEOF
 			}
+			m.Version = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
 			iNdEx += 8
-			m.Version = uint64(dAtA[iNdEx-8])
-			m.Version |= uint64(dAtA[iNdEx-7]) << 8
-			m.Version |= uint64(dAtA[iNdEx-6]) << 16
-			m.Version |= uint64(dAtA[iNdEx-5]) << 24
-			m.Version |= uint64(dAtA[iNdEx-4]) << 32
-			m.Version |= uint64(dAtA[iNdEx-3]) << 40
-			m.Version |= uint64(dAtA[iNdEx-2]) << 48
-			m.Version |= uint64(dAtA[iNdEx-1]) << 56
 		case 3:
 			if wireType != 1 {
 				return fmt.Errorf("proto: wrong wireType = %d for field DirectoryOffset", wireType)
@@ -547,15 +926,8 @@ func (m *Header) Unmarshal(dAtA []byte) error {
 			if (iNdEx + 8) > l {
 				return io.ErrUnexpectedEOF
 			}
+			m.DirectoryOffset = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
 			iNdEx += 8
-			m.DirectoryOffset = uint64(dAtA[iNdEx-8])
-			m.DirectoryOffset |= uint64(dAtA[iNdEx-7]) << 8
-			m.DirectoryOffset |= uint64(dAtA[iNdEx-6]) << 16
-			m.DirectoryOffset |= uint64(dAtA[iNdEx-5]) << 24
-			m.DirectoryOffset |= uint64(dAtA[iNdEx-4]) << 32
-			m.DirectoryOffset |= uint64(dAtA[iNdEx-3]) << 40
-			m.DirectoryOffset |= uint64(dAtA[iNdEx-2]) << 48
-			m.DirectoryOffset |= uint64(dAtA[iNdEx-1]) << 56
 		case 4:
 			if wireType != 1 {
 				return fmt.Errorf("proto: wrong wireType = %d for field DirectoryLength", wireType)
@@ -564,15 +936,8 @@ func (m *Header) Unmarshal(dAtA []byte) error {
 			if (iNdEx + 8) > l {
 				return io.ErrUnexpectedEOF
 			}
+			m.DirectoryLength = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
 			iNdEx += 8
-			m.DirectoryLength = uint64(dAtA[iNdEx-8])
-			m.DirectoryLength |= uint64(dAtA[iNdEx-7]) << 8
-			m.DirectoryLength |= uint64(dAtA[iNdEx-6]) << 16
-			m.DirectoryLength |= uint64(dAtA[iNdEx-5]) << 24
-			m.DirectoryLength |= uint64(dAtA[iNdEx-4]) << 32
-			m.DirectoryLength |= uint64(dAtA[iNdEx-3]) << 40
-			m.DirectoryLength |= uint64(dAtA[iNdEx-2]) << 48
-			m.DirectoryLength |= uint64(dAtA[iNdEx-1]) << 56
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPacked(dAtA[iNdEx:])
@@ -746,20 +1111,359 @@ func (m *Directory) Unmarshal(dAtA []byte) error {
 			}
 			m.Files[mapkey] = mapvalue
 			iNdEx = postIndex
-		default:
-			iNdEx = preIndex
-			skippy, err := skipPacked(dAtA[iNdEx:])
-			if err != nil {
-				return err
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Redirects", wireType)
 			}
-			if skippy < 0 {
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
 				return ErrInvalidLengthPacked
 			}
-			if (iNdEx + skippy) > l {
+			postIndex := iNdEx + msglen
+			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			iNdEx += skippy
-		}
+			if m.Redirects == nil {
+				m.Redirects = make(map[string]*Redirect)
+			}
+			var mapkey string
+			var mapvalue *Redirect
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowPacked
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowPacked
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthPacked
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var mapmsglen int
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowPacked
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						mapmsglen |= (int(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					if mapmsglen < 0 {
+						return ErrInvalidLengthPacked
+					}
+					postmsgIndex := iNdEx + mapmsglen
+					if mapmsglen < 0 {
+						return ErrInvalidLengthPacked
+					}
+					if postmsgIndex > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = &Redirect{}
+					if err := mapvalue.Unmarshal(dAtA[iNdEx:postmsgIndex]); err != nil {
+						return err
+					}
+					iNdEx = postmsgIndex
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipPacked(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if skippy < 0 {
+						return ErrInvalidLengthPacked
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Redirects[mapkey] = mapvalue
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Rewrites", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPacked
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Rewrites == nil {
+				m.Rewrites = make(map[string]string)
+			}
+			var mapkey string
+			var mapvalue string
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowPacked
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowPacked
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthPacked
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var stringLenmapvalue uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowPacked
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapvalue |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapvalue := int(stringLenmapvalue)
+					if intStringLenmapvalue < 0 {
+						return ErrInvalidLengthPacked
+					}
+					postStringIndexmapvalue := iNdEx + intStringLenmapvalue
+					if postStringIndexmapvalue > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = string(dAtA[iNdEx:postStringIndexmapvalue])
+					iNdEx = postStringIndexmapvalue
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipPacked(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if skippy < 0 {
+						return ErrInvalidLengthPacked
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Rewrites[mapkey] = mapvalue
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipPacked(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthPacked
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Redirect) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowPacked
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Redirect: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Redirect: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Location", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPacked
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Location = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Status", wireType)
+			}
+			m.Status = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Status |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipPacked(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthPacked
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
 	}
 
 	if iNdEx > l {
@@ -780,27 +1484,184 @@ func (m *File) Unmarshal(dAtA []byte) error {
 			if iNdEx >= l {
 				return io.ErrUnexpectedEOF
 			}
-			b := dAtA[iNdEx]
-			iNdEx++
-			wire |= (uint64(b) & 0x7F) << shift
-			if b < 0x80 {
-				break
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: File: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: File: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ContentType", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPacked
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ContentType = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Etag", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPacked
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Etag = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Uncompressed", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPacked
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Uncompressed == nil {
+				m.Uncompressed = &FileData{}
+			}
+			if err := m.Uncompressed.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Gzip", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPacked
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Gzip == nil {
+				m.Gzip = &FileData{}
+			}
+			if err := m.Gzip.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Brotli", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthPacked
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Brotli == nil {
+				m.Brotli = &FileData{}
 			}
-		}
-		fieldNum := int32(wire >> 3)
-		wireType := int(wire & 0x7)
-		if wireType == 4 {
-			return fmt.Errorf("proto: File: wiretype end group for non-group")
-		}
-		if fieldNum <= 0 {
-			return fmt.Errorf("proto: File: illegal tag %d (wire type %d)", fieldNum, wire)
-		}
-		switch fieldNum {
-		case 1:
+			if err := m.Brotli.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 6:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field ContentType", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Headers", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowPacked
@@ -810,24 +1671,113 @@ func (m *File) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				msglen |= (int(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthPacked
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.ContentType = string(dAtA[iNdEx:postIndex])
+			if m.Headers == nil {
+				m.Headers = make(map[string]string)
+			}
+			var mapkey string
+			var mapvalue string
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowPacked
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowPacked
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthPacked
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var stringLenmapvalue uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowPacked
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapvalue |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapvalue := int(stringLenmapvalue)
+					if intStringLenmapvalue < 0 {
+						return ErrInvalidLengthPacked
+					}
+					postStringIndexmapvalue := iNdEx + intStringLenmapvalue
+					if postStringIndexmapvalue > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = string(dAtA[iNdEx:postStringIndexmapvalue])
+					iNdEx = postStringIndexmapvalue
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipPacked(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if skippy < 0 {
+						return ErrInvalidLengthPacked
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Headers[mapkey] = mapvalue
 			iNdEx = postIndex
-		case 2:
+		case 7:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Etag", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Integrity", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -852,11 +1802,11 @@ func (m *File) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Etag = string(dAtA[iNdEx:postIndex])
+			m.Integrity = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-		case 3:
+		case 8:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Uncompressed", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Variants", wireType)
 			}
 			var msglen int
 			for shift := uint(0); ; shift += 7 {
@@ -880,16 +1830,43 @@ func (m *File) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.Uncompressed == nil {
-				m.Uncompressed = &FileData{}
-			}
-			if err := m.Uncompressed.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+			m.Variants = append(m.Variants, &File{})
+			if err := m.Variants[len(m.Variants)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
 				return err
 			}
 			iNdEx = postIndex
-		case 4:
+		case 9:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Gzip", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Language", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPacked
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Language = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Preloads", wireType)
 			}
 			var msglen int
 			for shift := uint(0); ; shift += 7 {
@@ -913,18 +1890,66 @@ func (m *File) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.Gzip == nil {
-				m.Gzip = &FileData{}
-			}
-			if err := m.Gzip.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+			m.Preloads = append(m.Preloads, &Preload{})
+			if err := m.Preloads[len(m.Preloads)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
 				return err
 			}
 			iNdEx = postIndex
-		case 5:
+		default:
+			iNdEx = preIndex
+			skippy, err := skipPacked(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthPacked
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Preload) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowPacked
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Preload: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Preload: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Brotli", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Path", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowPacked
@@ -934,25 +1959,70 @@ func (m *File) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				stringLen |= (uint64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthPacked
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.Brotli == nil {
-				m.Brotli = &FileData{}
+			m.Path = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field As", wireType)
 			}
-			if err := m.Brotli.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthPacked
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
 			}
+			m.As = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Crossorigin", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPacked
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Crossorigin = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPacked(dAtA[iNdEx:])
@@ -1011,15 +2081,8 @@ func (m *FileData) Unmarshal(dAtA []byte) error {
 			if (iNdEx + 8) > l {
 				return io.ErrUnexpectedEOF
 			}
+			m.Offset = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
 			iNdEx += 8
-			m.Offset = uint64(dAtA[iNdEx-8])
-			m.Offset |= uint64(dAtA[iNdEx-7]) << 8
-			m.Offset |= uint64(dAtA[iNdEx-6]) << 16
-			m.Offset |= uint64(dAtA[iNdEx-5]) << 24
-			m.Offset |= uint64(dAtA[iNdEx-4]) << 32
-			m.Offset |= uint64(dAtA[iNdEx-3]) << 40
-			m.Offset |= uint64(dAtA[iNdEx-2]) << 48
-			m.Offset |= uint64(dAtA[iNdEx-1]) << 56
 		case 2:
 			if wireType != 1 {
 				return fmt.Errorf("proto: wrong wireType = %d for field Length", wireType)
@@ -1028,15 +2091,8 @@ func (m *FileData) Unmarshal(dAtA []byte) error {
 			if (iNdEx + 8) > l {
 				return io.ErrUnexpectedEOF
 			}
+			m.Length = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
 			iNdEx += 8
-			m.Length = uint64(dAtA[iNdEx-8])
-			m.Length |= uint64(dAtA[iNdEx-7]) << 8
-			m.Length |= uint64(dAtA[iNdEx-6]) << 16
-			m.Length |= uint64(dAtA[iNdEx-5]) << 24
-			m.Length |= uint64(dAtA[iNdEx-4]) << 32
-			m.Length |= uint64(dAtA[iNdEx-3]) << 40
-			m.Length |= uint64(dAtA[iNdEx-2]) << 48
-			m.Length |= uint64(dAtA[iNdEx-1]) << 56
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPacked(dAtA[iNdEx:])
@@ -1166,28 +2222,42 @@ var (
 func init() { proto.RegisterFile("packed.proto", fileDescriptorPacked) }
 
 var fileDescriptorPacked = []byte{
-	// 359 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x74, 0x92, 0xc1, 0x4e, 0xab, 0x50,
-	0x10, 0x86, 0x2f, 0x2d, 0x70, 0x6f, 0x07, 0x92, 0x4b, 0x26, 0xc6, 0x10, 0x63, 0x1a, 0x25, 0x2e,
-	0xea, 0xa6, 0x0b, 0x74, 0x61, 0xba, 0x34, 0xb5, 0x71, 0x61, 0x62, 0x42, 0xdc, 0x37, 0x14, 0xa6,
-	0x48, 0x4a, 0x81, 0x1c, 0x4e, 0x9b, 0xe0, 0x0b, 0xb8, 0x33, 0xbe, 0x93, 0x1b, 0x97, 0x3e, 0x82,
-	0xd1, 0x17, 0x11, 0x0e, 0x87, 0x6a, 0x8d, 0x2e, 0x4e, 0x72, 0xfe, 0x6f, 0x7e, 0x06, 0x66, 0x7e,
-	0xc0, 0xcc, 0xfd, 0x60, 0x41, 0xe1, 0x30, 0x67, 0x19, 0xcf, 0x50, 0x6f, 0x94, 0xf3, 0xa0, 0x80,
-	0x7e, 0x49, 0x7e, 0x48, 0x0c, 0x77, 0x40, 0x5b, 0xfa, 0x51, 0x1c, 0xd8, 0xca, 0x81, 0x32, 0xd0,
-	0xbd, 0x46, 0xa0, 0x0d, 0x7f, 0xd7, 0xc4, 0x8a, 0x38, 0x4b, 0xed, 0x8e, 0xe0, 0xad, 0xc4, 0x63,
-	0xb0, 0xc2, 0x98, 0x51, 0xc0, 0x33, 0x56, 0x4e, 0xb3, 0xf9, 0xbc, 0x20, 0x6e, 0x77, 0x85, 0xe5,
-	0xff, 0x86, 0x5f, 0x0b, 0xbc, 0x6d, 0x4d, 0x28, 0x8d, 0xf8, 0xad, 0xad, 0x7e, 0xb3, 0x5e, 0x09,
-	0xec, 0xdc, 0x2b, 0xd0, 0x1b, 0xb7, 0x0c, 0x5d, 0xd0, 0xe6, 0x71, 0x42, 0x45, 0xf5, 0x4d, 0xdd,
-	0x81, 0xe1, 0xee, 0x0f, 0xe5, 0x10, 0x1b, 0xc7, 0x70, 0x52, 0x97, 0x2f, 0x52, 0xce, 0x4a, 0xaf,
-	0xb1, 0xee, 0x4d, 0x00, 0x3e, 0x21, 0x5a, 0xd0, 0x5d, 0x50, 0x29, 0x66, 0xea, 0x79, 0xf5, 0x15,
-	0x1d, 0xd0, 0xd6, 0x7e, 0xb2, 0x22, 0x31, 0x8f, 0xe1, 0x9a, 0x6d, 0xcf, 0xfa, 0x21, 0xaf, 0x29,
-	0x8d, 0x3a, 0x67, 0x8a, 0xf3, 0xa4, 0x80, 0x5a, 0x33, 0x3c, 0x04, 0x33, 0xc8, 0x52, 0x4e, 0x29,
-	0x9f, 0xf2, 0x32, 0x27, 0xd9, 0xcb, 0x90, 0xec, 0xa6, 0x42, 0x88, 0xa0, 0x12, 0xf7, 0x23, 0xd1,
-	0xb2, 0xe7, 0x89, 0x3b, 0x9e, 0x82, 0xb9, 0x4a, 0x83, 0x6c, 0x99, 0x33, 0x2a, 0x0a, 0x0a, 0xc5,
-	0x6e, 0x0c, 0xd7, 0xfa, 0xfa, 0xba, 0xb1, 0xcf, 0x7d, 0x6f, 0xcb, 0x85, 0x47, 0xa0, 0x46, 0x77,
-	0x71, 0x2e, 0xd6, 0xf3, 0x93, 0x5b, 0x54, 0x71, 0x00, 0xfa, 0xac, 0xca, 0x31, 0x89, 0x6d, 0xed,
-	0x17, 0x9f, 0xac, 0x3b, 0x23, 0xf8, 0xd7, 0x32, 0xdc, 0x05, 0x5d, 0xe6, 0xd4, 0x44, 0x2c, 0x55,
-	0xcd, 0x65, 0x28, 0x4d, 0xc4, 0x52, 0x9d, 0x5b, 0xcf, 0x6f, 0x7d, 0xe5, 0xa5, 0x3a, 0xaf, 0xd5,
-	0x79, 0x7c, 0xef, 0xff, 0x99, 0xe9, 0xe2, 0xef, 0x39, 0xf9, 0x08, 0x00, 0x00, 0xff, 0xff, 0x07,
-	0x4a, 0x55, 0x53, 0x4d, 0x02, 0x00, 0x00,
+	// 582 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x54, 0xcd, 0x6a, 0xdb, 0x40,
+	0x10, 0x46, 0x96, 0x2d, 0x4b, 0x63, 0xe5, 0x87, 0x25, 0x94, 0xad, 0x09, 0xa9, 0x2b, 0x4a, 0x71,
+	0x29, 0xf8, 0x90, 0xf4, 0x50, 0x12, 0xc8, 0xa1, 0xa4, 0xa1, 0x87, 0xd2, 0x14, 0xd1, 0x7b, 0xd8,
+	0xc8, 0x1b, 0x79, 0x89, 0xa2, 0x15, 0xbb, 0x6b, 0x17, 0xf5, 0x21, 0x0a, 0x7d, 0xa8, 0x42, 0x8f,
+	0x7d, 0x84, 0xe2, 0x27, 0x29, 0xda, 0x5d, 0xc9, 0x52, 0xe3, 0x1c, 0x7a, 0xdb, 0xf9, 0xe6, 0xfb,
+	0x3e, 0x66, 0x46, 0xa3, 0x81, 0xb0, 0x20, 0xc9, 0x1d, 0x9d, 0xcf, 0x0a, 0xc1, 0x15, 0x47, 0x9e,
+	0x89, 0xa2, 0xef, 0x0e, 0x78, 0x1f, 0x28, 0x99, 0x53, 0x81, 0x0e, 0x60, 0x70, 0x4f, 0x52, 0x96,
+	0x60, 0x67, 0xe2, 0x4c, 0xbd, 0xd8, 0x04, 0x08, 0xc3, 0x70, 0x45, 0x85, 0x64, 0x3c, 0xc7, 0x3d,
+	0x8d, 0xd7, 0x21, 0x7a, 0x05, 0xfb, 0x73, 0x26, 0x68, 0xa2, 0xb8, 0x28, 0xaf, 0xf9, 0xed, 0xad,
+	0xa4, 0x0a, 0xbb, 0x9a, 0xb2, 0xd7, 0xe0, 0x57, 0x1a, 0xee, 0x52, 0x33, 0x9a, 0xa7, 0x6a, 0x81,
+	0xfb, 0xff, 0x50, 0x3f, 0x6a, 0x38, 0xfa, 0xe1, 0x42, 0x70, 0x51, 0x63, 0xe8, 0x18, 0x06, 0xb7,
+	0x2c, 0xa3, 0x12, 0x3b, 0x13, 0x77, 0x3a, 0x3a, 0x3e, 0x9c, 0xd9, 0x26, 0x1a, 0xc6, 0xec, 0xb2,
+	0x4a, 0xbf, 0xcf, 0x95, 0x28, 0x63, 0x43, 0x45, 0xe7, 0x10, 0x08, 0x6a, 0x6c, 0x25, 0xee, 0x69,
+	0xdd, 0xe4, 0xa1, 0x2e, 0xae, 0x29, 0x46, 0xbb, 0x91, 0xa0, 0x33, 0xf0, 0x05, 0xfd, 0x2a, 0x98,
+	0xa2, 0x12, 0xbb, 0x5a, 0xfe, 0x6c, 0x9b, 0xdc, 0x30, 0x8c, 0xba, 0x11, 0x8c, 0x2f, 0x01, 0x36,
+	0x15, 0xa1, 0x7d, 0x70, 0xef, 0x68, 0xa9, 0x07, 0x1a, 0xc4, 0xd5, 0x13, 0x45, 0x30, 0x58, 0x91,
+	0x6c, 0x49, 0xf5, 0x30, 0x47, 0xc7, 0x61, 0xed, 0x5c, 0x89, 0x62, 0x93, 0x3a, 0xed, 0xbd, 0x75,
+	0xc6, 0x9f, 0x60, 0xb7, 0x5b, 0xe1, 0x16, 0xaf, 0x97, 0x5d, 0xaf, 0xfd, 0xda, 0xab, 0x16, 0xb6,
+	0xfd, 0xce, 0x60, 0xa7, 0x53, 0xf2, 0x16, 0xbb, 0x83, 0xb6, 0x5d, 0xd0, 0x12, 0x47, 0xe7, 0xe0,
+	0xd7, 0x9e, 0x68, 0x0c, 0x7e, 0xc6, 0x13, 0xa2, 0xaa, 0x85, 0x30, 0xe2, 0x26, 0x46, 0x4f, 0xc0,
+	0x93, 0x8a, 0xa8, 0xa5, 0xd4, 0x16, 0x3b, 0xb1, 0x8d, 0xa2, 0x9f, 0x2e, 0xf4, 0xab, 0x06, 0xd1,
+	0x73, 0x08, 0x13, 0x9e, 0x2b, 0x9a, 0xab, 0x6b, 0x55, 0x16, 0xd4, 0x1a, 0x8c, 0x2c, 0xf6, 0xa5,
+	0x2c, 0x28, 0x42, 0xd0, 0xa7, 0x8a, 0xa4, 0xb6, 0x08, 0xfd, 0x46, 0x6f, 0x20, 0x5c, 0xe6, 0x09,
+	0xbf, 0x2f, 0x04, 0x95, 0x92, 0xce, 0xb1, 0xdb, 0xed, 0xb7, 0xb2, 0xbe, 0x20, 0x8a, 0xc4, 0x1d,
+	0x16, 0x7a, 0x01, 0xfd, 0xf4, 0x1b, 0x2b, 0x70, 0xff, 0x11, 0xb6, 0xce, 0xa2, 0x29, 0x78, 0x37,
+	0x82, 0xab, 0x8c, 0xe1, 0xc1, 0x23, 0x3c, 0x9b, 0x47, 0x27, 0x30, 0x5c, 0xe8, 0x3f, 0x45, 0x62,
+	0x4f, 0xaf, 0xc5, 0xd3, 0x36, 0x75, 0x66, 0xfe, 0x22, 0xbb, 0x10, 0x35, 0x13, 0x1d, 0x42, 0xc0,
+	0x72, 0x45, 0x53, 0xc1, 0x54, 0x89, 0x87, 0xba, 0xa7, 0x0d, 0x80, 0xa6, 0xe0, 0xaf, 0x88, 0x60,
+	0x24, 0x57, 0x12, 0xfb, 0x13, 0xf7, 0xc1, 0x42, 0x34, 0x59, 0x3d, 0x76, 0x92, 0xa7, 0x4b, 0x92,
+	0x52, 0x1c, 0xd8, 0xb1, 0xdb, 0x18, 0xbd, 0x06, 0xbf, 0x10, 0x34, 0xe3, 0x64, 0x2e, 0x31, 0x68,
+	0x97, 0xbd, 0xda, 0xe5, 0xb3, 0xc1, 0xe3, 0x86, 0x30, 0x3e, 0x85, 0xb0, 0x5d, 0xe9, 0x7f, 0xed,
+	0xc1, 0x15, 0x0c, 0xad, 0x61, 0xf5, 0x99, 0x0a, 0xa2, 0x16, 0x56, 0xa7, 0xdf, 0x68, 0x17, 0x7a,
+	0x44, 0x5a, 0x55, 0x8f, 0x48, 0x34, 0x81, 0x51, 0x22, 0xb8, 0x94, 0x5c, 0xb0, 0x94, 0xe5, 0xfa,
+	0xab, 0xf9, 0x71, 0x1b, 0x8a, 0x4e, 0xc1, 0xaf, 0xc7, 0x5c, 0x2d, 0x8f, 0x3d, 0x22, 0xe6, 0xfe,
+	0xd8, 0xa8, 0xc2, 0xed, 0xc5, 0x30, 0xf7, 0xc7, 0x46, 0xef, 0xc2, 0x5f, 0xeb, 0x23, 0xe7, 0xf7,
+	0xfa, 0xc8, 0xf9, 0xb3, 0x3e, 0x72, 0x6e, 0x3c, 0x7d, 0xd6, 0x4e, 0xfe, 0x0e, 0x00, 0xc3, 0xf5,
+	0xb6, 0xad, 0xe6, 0x04, 0x00, 0x00,
 }
@@ -0,0 +1,110 @@
+package htpack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// signedExpiryParam and signedSigParam are the query parameters of a
+	// signed URL holding its expiry time and signature.
+	signedExpiryParam = "exp"
+	signedSigParam    = "sig"
+)
+
+// SignURL returns a URL for path which will be accepted by a SignedHandler
+// using the same key until the expiry time. The path must be the full path
+// requested by the client, including any prefix at which the handler is
+// mounted (e.g. "/downloads/file.zip"). The expiry time and signature are
+// added as the query parameters "exp" and "sig".
+func SignURL(key []byte, path string, expiry time.Time) string {
+	exp := strconv.FormatInt(expiry.Unix(), 10)
+	q := url.Values{
+		signedExpiryParam: {exp},
+		signedSigParam:    {urlSignature(key, path, exp)},
+	}
+	return (&url.URL{Path: path, RawQuery: q.Encode()}).String()
+}
+
+// urlSignature returns the encoded HMAC-SHA256 of a path and expiry time.
+func urlSignature(key []byte, path, exp string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path))
+	mac.Write([]byte{0})
+	mac.Write([]byte(exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignedHandler wraps another handler (typically a Handler), passing on only
+// those requests whose URL carries a valid, unexpired signature, as created
+// by SignURL. Other requests receive a 403 response, which may not be cached.
+type SignedHandler struct {
+	handler http.Handler
+	keys    [][]byte
+	logger  func(*Event)
+}
+
+// NewSignedHandler returns a handler which verifies signed URLs before passing
+// requests on to handler. A signature made with any of the keys is accepted,
+// which allows keys to be rotated: add the new key, then start signing URLs
+// with it, and remove the old key once the URLs signed with it have expired.
+func NewSignedHandler(handler http.Handler, keys ...[]byte) *SignedHandler {
+	return &SignedHandler{
+		handler: handler,
+		keys:    keys,
+	}
+}
+
+// SetLogger sets a function that is called with the details of each request
+// which is rejected. Requests which are passed on are not logged, since the
+// wrapped handler is expected to log them. Passing nil disables logging.
+func (sh *SignedHandler) SetLogger(logger func(*Event)) {
+	sh.logger = logger
+}
+
+func (sh *SignedHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	msg := sh.verify(req)
+	if msg == "" {
+		sh.handler.ServeHTTP(w, req)
+		return
+	}
+
+	LogError(sh.logger, w, req, http.StatusForbidden, msg)
+}
+
+// verify checks the signature of a request's URL, returning an empty string
+// if it is valid or otherwise a message describing the problem.
+func (sh *SignedHandler) verify(req *http.Request) string {
+	q := req.URL.Query()
+	exp, sig := q.Get(signedExpiryParam), q.Get(signedSigParam)
+	if exp == "" || sig == "" {
+		return "missing signature"
+	}
+
+	expiry, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "invalid signature"
+	}
+	if time.Now().Unix() > expiry {
+		return "link expired"
+	}
+
+	// the signature covers the path requested by the client, before any
+	// prefix was stripped
+	reqPath := req.URL.Path
+	if orig, err := url.ParseRequestURI(req.RequestURI); err == nil {
+		reqPath = orig.Path
+	}
+	for _, key := range sh.keys {
+		if hmac.Equal([]byte(sig), []byte(urlSignature(key, reqPath,
+			exp))) {
+			return ""
+		}
+	}
+	return "invalid signature"
+}
@@ -0,0 +1,103 @@
+package htpack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLSignature(t *testing.T) {
+	key := []byte("key")
+	sig := urlSignature(key, "/file", "1700000000")
+	if strings.ContainsAny(sig, "+/=") {
+		t.Errorf("signature %q is not URL safe", sig)
+	}
+
+	tests := []struct {
+		name         string
+		key          []byte
+		path, expiry string
+	}{
+		{"key", []byte("other"), "/file", "1700000000"},
+		{"path", key, "/file2", "1700000000"},
+		{"expiry", key, "/file", "1700000001"},
+		// the separator stops path and expiry running together
+		{"boundary", key, "/file1", "700000000"},
+	}
+	for _, tt := range tests {
+		if urlSignature(tt.key, tt.path, tt.expiry) == sig {
+			t.Errorf("%s: signature unchanged", tt.name)
+		}
+	}
+}
+
+func TestSignedHandler(t *testing.T) {
+	oldKey, newKey := []byte("old"), []byte("new")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	sh := NewSignedHandler(http.StripPrefix("/dl", ok), oldKey, newKey)
+	var logged *Event
+	sh.SetLogger(func(ev *Event) {
+		logged = ev
+	})
+
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Minute)
+	tests := []struct {
+		name   string
+		target string
+		status int
+		msg    string
+	}{
+		{"valid", SignURL(newKey, "/dl/file.zip", future),
+			http.StatusOK, ""},
+		{"old key", SignURL(oldKey, "/dl/file.zip", future),
+			http.StatusOK, ""},
+		{"unknown key", SignURL([]byte("x"), "/dl/file.zip", future),
+			http.StatusForbidden, "invalid signature"},
+		{"expired", SignURL(newKey, "/dl/file.zip", past),
+			http.StatusForbidden, "link expired"},
+		{"without prefix", "/dl" + SignURL(newKey, "/file.zip", future),
+			http.StatusForbidden, "invalid signature"},
+		{"other path", strings.Replace(
+			SignURL(newKey, "/dl/file.zip", future), "file", "other",
+			1), http.StatusForbidden, "invalid signature"},
+		{"unsigned", "/dl/file.zip", http.StatusForbidden,
+			"missing signature"},
+		{"bad expiry", "/dl/file.zip?exp=soon&sig=x",
+			http.StatusForbidden, "invalid signature"},
+	}
+	for _, tt := range tests {
+		logged = nil
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code,
+				tt.status)
+		}
+		if tt.status == http.StatusOK {
+			if logged != nil {
+				t.Errorf("%s: accepted request logged", tt.name)
+			}
+			continue
+		}
+
+		if body := strings.TrimSpace(w.Body.String()); body != tt.msg {
+			t.Errorf("%s: body %q, want %q", tt.name, body, tt.msg)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("%s: Cache-Control %q", tt.name, cc)
+		}
+		switch {
+		case logged == nil:
+			t.Errorf("%s: rejection not logged", tt.name)
+		case logged.Status != tt.status ||
+			logged.Bytes != uint64(w.Body.Len()):
+			t.Errorf("%s: logged status %d, %d bytes", tt.name,
+				logged.Status, logged.Bytes)
+		}
+	}
+}